@@ -0,0 +1,117 @@
+package libstore
+
+import (
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// Future is the pending result of an asynchronous Libstore call.
+type Future interface {
+
+	// Done returns a channel that is closed once the call has completed.
+	Done() <-chan struct{}
+}
+
+// WaitAll blocks until all of futures have completed.
+func WaitAll(futures ...Future) {
+	for _, f := range futures {
+		<-f.Done()
+	}
+}
+
+// GetFuture is the pending result of a GetAsync call.
+type GetFuture struct {
+	f *readFuture
+}
+
+func (f *GetFuture) Done() <-chan struct{} {
+	return f.f.done
+}
+
+// Wait blocks until the Get has completed and returns its result.
+func (f *GetFuture) Wait() (string, error) {
+	<-f.f.done
+	if f.f.entry == nil {
+		return "", f.f.err
+	}
+	return f.f.entry.value, f.f.err
+}
+
+// GetListFuture is the pending result of a GetListAsync call.
+type GetListFuture struct {
+	f *readFuture
+}
+
+func (f *GetListFuture) Done() <-chan struct{} {
+	return f.f.done
+}
+
+// Wait blocks until the GetList has completed and returns its result.
+func (f *GetListFuture) Wait() ([]string, error) {
+	<-f.f.done
+	if f.f.entry == nil {
+		return nil, f.f.err
+	}
+	return append([]string(nil), f.f.entry.list...), f.f.err
+}
+
+// readFuture is the pending result of a read started by readAsync.
+type readFuture struct {
+	done  chan struct{}
+	entry *cacheEntry // The value or list read, possibly a stale one; nil on error.
+	err   error
+}
+
+// doneFuture returns a completed readFuture with the given result.
+func doneFuture(entry *cacheEntry, err error) *readFuture {
+	f := &readFuture{done: make(chan struct{}), entry: entry, err: err}
+	close(f.done)
+	return f
+}
+
+func (ls *libstore) GetAsync(key string) *GetFuture {
+	return &GetFuture{ls.readAsync(key, false, ls.readLevel)}
+}
+
+func (ls *libstore) GetListAsync(key string) *GetListFuture {
+	return &GetListFuture{ls.readAsync(key, true, ls.readLevel)}
+}
+
+// readAsync starts a Get or, if isList is set, a GetList of key at
+// consistency c, and returns without waiting for it. The read is served from
+// the cache if possible, and otherwise sent like any other read (see read);
+// if it fails to reach the storage server, a stale value may be returned
+// instead (see WithStaleGrace).
+func (ls *libstore) readAsync(key string, isList bool, c storagerpc.Consistency) *readFuture {
+	now := time.Now()
+	if entry, ok := ls.cached(key, now); ok {
+		return doneFuture(entry, nil)
+	}
+
+	args := &storagerpc.GetArgs{Key: key, WantLease: ls.wantLease(key), HostPort: ls.myHostPort, Consistency: c}
+	gen := ls.cache.beginRead(key)
+	f := &readFuture{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		reply, err := ls.read(args, isList)
+		var entry *cacheEntry
+		if err == nil && reply.status == storagerpc.OK {
+			entry = leaseEntry(reply.value, reply.list, reply.lease, now)
+		}
+		ls.cache.endRead(key, gen, entry)
+		switch {
+		case err != nil:
+			f.entry, f.err = ls.staleFallback(key, err)
+		case reply.status != storagerpc.OK:
+			op := "Get"
+			if isList {
+				op = "GetList"
+			}
+			f.err = &StatusError{Op: op, Key: key, Status: reply.status}
+		default:
+			f.entry = &cacheEntry{value: reply.value, list: reply.list}
+		}
+	}()
+	return f
+}
@@ -0,0 +1,92 @@
+package libstore
+
+import (
+	"net/rpc"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestAsyncReads(t *testing.T) {
+	f := startFakeStorage(t)
+	f.values["a"] = "1"
+	f.values["b"] = "2"
+	f.lists["l"] = []string{"x", "y"}
+	f.delay = 100 * time.Millisecond
+
+	ls, err := NewLibstore(f.hostPort, "", Never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	// The reads run concurrently, so together they take about one delay.
+	start := time.Now()
+	a, b, missing := ls.GetAsync("a"), ls.GetAsync("b"), ls.GetAsync("missing")
+	l := ls.GetListAsync("l")
+	WaitAll(a, b, missing, l)
+	if elapsed := time.Since(start); elapsed >= 3*f.delay {
+		t.Errorf("three Gets took %v, want them to run concurrently", elapsed)
+	}
+	for _, fut := range []Future{a, b, missing, l} {
+		select {
+		case <-fut.Done():
+		default:
+			t.Error("future not done after WaitAll")
+		}
+	}
+
+	for key, fut := range map[string]*GetFuture{"a": a, "b": b} {
+		if value, err := fut.Wait(); err != nil || value != f.values[key] {
+			t.Errorf("GetAsync(%q) = %q, %v; want %q, nil", key, value, err, f.values[key])
+		}
+	}
+	if _, err := missing.Wait(); err == nil {
+		t.Error("GetAsync of a missing key succeeded")
+	} else if se, ok := err.(*StatusError); !ok || se.Status != storagerpc.KeyNotFound {
+		t.Errorf("GetAsync of a missing key = %v, want a KeyNotFound StatusError", err)
+	}
+	list, err := l.Wait()
+	if err != nil || !reflect.DeepEqual(list, []string{"x", "y"}) {
+		t.Errorf("GetListAsync = %q, %v; want [x y], nil", list, err)
+	}
+
+	// The returned list is the caller's to change.
+	list[0] = "changed"
+	if again, _ := l.Wait(); again[0] != "x" {
+		t.Errorf("second Wait = %q, want the list unchanged", again)
+	}
+}
+
+func TestAsyncReadFromCache(t *testing.T) {
+	f := startFakeStorage(t)
+	f.values["k"] = "v"
+
+	ls, err := NewLibstore(f.hostPort, "", Always, WithCallbackServer(rpc.NewServer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	if value, err := ls.GetAsync("k").Wait(); err != nil || value != "v" {
+		t.Fatalf("first GetAsync = %q, %v; want %q, nil", value, err, "v")
+	}
+	reads := f.readCount()
+
+	// The lease granted to the first read lets the second be served from the
+	// cache, complete as soon as it is returned.
+	fut := ls.GetAsync("k")
+	select {
+	case <-fut.Done():
+	default:
+		t.Error("cached GetAsync not done on return")
+	}
+	if value, err := fut.Wait(); err != nil || value != "v" {
+		t.Errorf("cached GetAsync = %q, %v; want %q, nil", value, err, "v")
+	}
+	if got := f.readCount(); got != reads {
+		t.Errorf("cached GetAsync sent %d reads, want none", got-reads)
+	}
+}
@@ -0,0 +1,138 @@
+package libstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// WithWriteBatching enables group commit of writes. Put, Delete, AppendToList
+// and RemoveFromList calls destined for the same storage node are held for up
+// to window (or until maxBatch of them have accumulated) and then sent
+// together in a single StorageServer.Batch RPC. Each caller still blocks until
+// its own write has been applied, and receives its own status.
+func WithWriteBatching(window time.Duration, maxBatch int) Option {
+	return func(ls *libstore) {
+		ls.batcher = &writeBatcher{
+			window:   window,
+			maxBatch: maxBatch,
+			batches:  make(map[storagerpc.Node]*writeBatch),
+			send: func(node storagerpc.Node, args *storagerpc.BatchArgs, reply *storagerpc.BatchReply) error {
+				return ls.call(node, "Batch", args, reply)
+			},
+		}
+	}
+}
+
+// writeResult is the outcome of a single batched write.
+type writeResult struct {
+	status storagerpc.Status
+	err    error
+}
+
+// writeBatch is the set of writes waiting to be sent to one node.
+type writeBatch struct {
+	writes  []storagerpc.Write
+	waiters []chan writeResult
+	timer   *time.Timer
+}
+
+// writeBatcher accumulates writes per storage node and sends them in batches.
+type writeBatcher struct {
+	window   time.Duration
+	maxBatch int
+	send     func(storagerpc.Node, *storagerpc.BatchArgs, *storagerpc.BatchReply) error
+
+	mu       sync.Mutex
+	batches  map[storagerpc.Node]*writeBatch
+	unsent   int        // Batches created but not yet fully flushed.
+	finished *sync.Cond // Signaled whenever a batch has been flushed.
+}
+
+// cond returns b.finished, creating it on first use. b.mu must be held.
+func (b *writeBatcher) cond() *sync.Cond {
+	if b.finished == nil {
+		b.finished = sync.NewCond(&b.mu)
+	}
+	return b.finished
+}
+
+// do adds w to node's current batch and waits for the batch to be applied.
+func (b *writeBatcher) do(node storagerpc.Node, w storagerpc.Write) (storagerpc.Status, error) {
+	done := make(chan writeResult, 1)
+
+	b.mu.Lock()
+	batch, ok := b.batches[node]
+	if !ok {
+		batch = new(writeBatch)
+		b.batches[node] = batch
+		b.unsent++
+		batch.timer = time.AfterFunc(b.window, func() {
+			b.flush(node, batch)
+		})
+	}
+	batch.writes = append(batch.writes, w)
+	batch.waiters = append(batch.waiters, done)
+	full := b.maxBatch > 0 && len(batch.writes) >= b.maxBatch
+	b.mu.Unlock()
+
+	if full && batch.timer.Stop() {
+		go b.flush(node, batch)
+	}
+	res := <-done
+	return res.status, res.err
+}
+
+// flush sends batch to node and hands each waiter its write's result.
+func (b *writeBatcher) flush(node storagerpc.Node, batch *writeBatch) {
+	b.mu.Lock()
+	if b.batches[node] == batch {
+		delete(b.batches, node)
+	}
+	b.mu.Unlock()
+
+	args := &storagerpc.BatchArgs{Writes: batch.writes}
+	var reply storagerpc.BatchReply
+	err := b.send(node, args, &reply)
+	if err == nil && len(reply.Statuses) != len(batch.writes) {
+		err = fmt.Errorf("batch of %d writes to %s returned %d statuses",
+			len(batch.writes), node.HostPort, len(reply.Statuses))
+	}
+	for i, done := range batch.waiters {
+		if err != nil {
+			done <- writeResult{err: err}
+		} else {
+			done <- writeResult{status: reply.Statuses[i]}
+		}
+	}
+
+	b.mu.Lock()
+	b.unsent--
+	b.cond().Broadcast()
+	b.mu.Unlock()
+}
+
+// flushAll immediately sends every batch that is waiting for its window to
+// close, and waits for those and the batches already being sent to complete.
+func (b *writeBatcher) flushAll() {
+	b.mu.Lock()
+	pending := make(map[storagerpc.Node]*writeBatch)
+	for node, batch := range b.batches {
+		if batch.timer.Stop() {
+			pending[node] = batch
+		}
+	}
+	b.mu.Unlock()
+
+	for node, batch := range pending {
+		go b.flush(node, batch)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.unsent > 0 {
+		b.cond().Wait()
+	}
+}
@@ -0,0 +1,199 @@
+package libstore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// fakeBatchSender records the batches sent to it and replies with the status
+// named by each write's value ("ok" for OK, anything else ItemExists). If
+// release is non-nil, sends to the node hold wait until it is closed.
+type fakeBatchSender struct {
+	mu      sync.Mutex
+	batches [][]storagerpc.Write
+	hold    string
+	release chan struct{}
+	err     error
+}
+
+func (s *fakeBatchSender) send(node storagerpc.Node, args *storagerpc.BatchArgs, reply *storagerpc.BatchReply) error {
+	s.mu.Lock()
+	s.batches = append(s.batches, args.Writes)
+	release, err := s.release, s.err
+	s.mu.Unlock()
+	if release != nil && node.HostPort == s.hold {
+		<-release
+	}
+	if err != nil {
+		return err
+	}
+	for _, w := range args.Writes {
+		status := storagerpc.ItemExists
+		if w.Value == "ok" {
+			status = storagerpc.OK
+		}
+		reply.Statuses = append(reply.Statuses, status)
+	}
+	return nil
+}
+
+// sent returns the number of writes in each batch sent so far.
+func (s *fakeBatchSender) sent() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sizes []int
+	for _, writes := range s.batches {
+		sizes = append(sizes, len(writes))
+	}
+	return sizes
+}
+
+func newTestBatcher(s *fakeBatchSender, window time.Duration, maxBatch int) *writeBatcher {
+	return &writeBatcher{
+		window:   window,
+		maxBatch: maxBatch,
+		batches:  make(map[storagerpc.Node]*writeBatch),
+		send:     s.send,
+	}
+}
+
+// pending returns the number of writes waiting to be sent to node.
+func (b *writeBatcher) pending(node storagerpc.Node) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if batch, ok := b.batches[node]; ok {
+		return len(batch.writes)
+	}
+	return 0
+}
+
+// waitPending waits until n writes are waiting to be sent to node.
+func waitPending(t *testing.T, b *writeBatcher, node storagerpc.Node, n int) {
+	for deadline := time.Now().Add(5 * time.Second); b.pending(node) != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d writes pending, want %d", b.pending(node), n)
+		}
+	}
+}
+
+func TestBatchStatusesPerWrite(t *testing.T) {
+	s := &fakeBatchSender{}
+	b := newTestBatcher(s, time.Hour, 3)
+	node := storagerpc.Node{HostPort: "a"}
+
+	values := []string{"ok", "exists", "ok"}
+	statuses := make([]storagerpc.Status, len(values))
+	var wg sync.WaitGroup
+	for i, value := range values {
+		wg.Add(1)
+		go func(i int, value string) {
+			defer wg.Done()
+			statuses[i], _ = b.do(node, storagerpc.Write{Op: storagerpc.PutOp, Key: "k", Value: value})
+		}(i, value)
+		if i < len(values)-1 {
+			waitPending(t, b, node, i+1)
+		}
+	}
+	wg.Wait()
+
+	if sizes := s.sent(); len(sizes) != 1 || sizes[0] != 3 {
+		t.Fatalf("sent batches of %v writes, want one of 3", sizes)
+	}
+	for i, want := range []storagerpc.Status{storagerpc.OK, storagerpc.ItemExists, storagerpc.OK} {
+		if statuses[i] != want {
+			t.Errorf("write %d: status %v, want %v", i, statuses[i], want)
+		}
+	}
+}
+
+func TestBatchErrorReachesEveryWrite(t *testing.T) {
+	s := &fakeBatchSender{err: errors.New("unreachable")}
+	b := newTestBatcher(s, time.Hour, 2)
+	node := storagerpc.Node{HostPort: "a"}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := b.do(node, storagerpc.Write{Op: storagerpc.PutOp, Key: "k", Value: "ok"})
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != s.err {
+			t.Errorf("write returned %v, want %v", err, s.err)
+		}
+	}
+}
+
+func TestBatchSentAtWindowOrMaxBatch(t *testing.T) {
+	s := &fakeBatchSender{}
+	b := newTestBatcher(s, 50*time.Millisecond, 2)
+	a, c := storagerpc.Node{HostPort: "a"}, storagerpc.Node{HostPort: "c"}
+
+	// A lone write waits for the window to close.
+	start := time.Now()
+	if status, err := b.do(a, storagerpc.Write{Op: storagerpc.PutOp, Key: "k", Value: "ok"}); err != nil || status != storagerpc.OK {
+		t.Fatalf("write = %v, %v; want OK", status, err)
+	}
+	if elapsed := time.Since(start); elapsed < b.window {
+		t.Errorf("lone write sent after %v, want at least the %v window", elapsed, b.window)
+	}
+
+	// With a long window, a batch is sent as soon as it is full.
+	b.window = time.Hour
+	done := make(chan struct{})
+	go func() {
+		b.do(c, storagerpc.Write{Op: storagerpc.PutOp, Key: "k", Value: "ok"})
+		close(done)
+	}()
+	waitPending(t, b, c, 1)
+	b.do(c, storagerpc.Write{Op: storagerpc.PutOp, Key: "k", Value: "ok"})
+	<-done
+	if sizes := s.sent(); len(sizes) != 2 || sizes[1] != 2 {
+		t.Errorf("sent batches of %v writes, want 1 then 2", sizes)
+	}
+}
+
+func TestBatchFlushAll(t *testing.T) {
+	s := &fakeBatchSender{hold: "a", release: make(chan struct{})}
+	b := newTestBatcher(s, time.Hour, 2)
+	a, c := storagerpc.Node{HostPort: "a"}, storagerpc.Node{HostPort: "c"}
+	write := storagerpc.Write{Op: storagerpc.PutOp, Key: "k", Value: "ok"}
+
+	// One batch is full and being sent; the other waits for its window.
+	for i := 0; i < 2; i++ {
+		go b.do(a, write)
+	}
+	for len(s.sent()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go b.do(c, write)
+	waitPending(t, b, c, 1)
+
+	flushed := make(chan struct{})
+	go func() {
+		b.flushAll()
+		close(flushed)
+	}()
+	for len(s.sent()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-flushed:
+		t.Fatal("flushAll returned while batches were still being sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(s.release)
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flushAll never returned")
+	}
+	if sizes := s.sent(); len(sizes) != 2 || sizes[0] != 2 || sizes[1] != 1 {
+		t.Errorf("sent batches of %v writes, want 2 then 1", sizes)
+	}
+}
@@ -0,0 +1,168 @@
+package libstore
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// Default circuit breaker settings.
+const (
+	defaultBreakerThreshold = 5               // Consecutive failures before a breaker trips.
+	defaultBreakerCooldown  = 2 * time.Second // Time a breaker stays open before probing.
+)
+
+// BreakerState is the state of the circuit breaker guarding a storage node.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Requests flow normally.
+	BreakerOpen                         // Requests fail fast with a NodeUnavailableError.
+	BreakerHalfOpen                     // A single probe request is allowed through.
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "Closed"
+	case BreakerOpen:
+		return "Open"
+	case BreakerHalfOpen:
+		return "HalfOpen"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// NodeUnavailableError is returned, without contacting the node, by requests
+// routed to a storage node whose circuit breaker is open.
+type NodeUnavailableError struct {
+	Node  storagerpc.Node
+	Until time.Time // When the breaker will next let a probe through.
+}
+
+func (e *NodeUnavailableError) Error() string {
+	return fmt.Sprintf("storage node %s (%d) unavailable until %s",
+		e.Node.HostPort, e.Node.NodeID, e.Until.Format(time.RFC3339Nano))
+}
+
+// NodeStats describes the health of a single storage node as seen by a
+// Libstore.
+type NodeStats struct {
+	Node     storagerpc.Node
+	State    BreakerState
+	Failures int // Consecutive failed requests.
+}
+
+// WithCircuitBreaker configures the per-node circuit breakers. A breaker
+// trips after threshold consecutive failed RPCs to its node, fails requests
+// fast for cooldown, and then lets a single probe through to decide whether
+// to close again. A threshold of zero disables the breakers.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(ls *libstore) {
+		ls.breakers = newBreakerSet(threshold, cooldown)
+	}
+}
+
+type breaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// breakerSet holds a circuit breaker for each storage node.
+type breakerSet struct {
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	nodes     map[storagerpc.Node]*breaker
+}
+
+func newBreakerSet(threshold int, cooldown time.Duration) *breakerSet {
+	return &breakerSet{
+		threshold: threshold,
+		cooldown:  cooldown,
+		nodes:     make(map[storagerpc.Node]*breaker),
+	}
+}
+
+// allow returns a *NodeUnavailableError if a request to node should not be
+// sent at time now. Every nil return must be followed by a call to report.
+func (bs *breakerSet) allow(node storagerpc.Node, now time.Time) error {
+	if bs.threshold <= 0 {
+		return nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.breaker(node)
+	switch b.state {
+	case BreakerOpen:
+		until := b.openedAt.Add(bs.cooldown)
+		if now.Before(until) {
+			return &NodeUnavailableError{Node: node, Until: until}
+		}
+		b.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		// Only the first request after the cooldown probes the node.
+		return &NodeUnavailableError{Node: node, Until: now.Add(bs.cooldown)}
+	default:
+		return nil
+	}
+}
+
+// report records the outcome of a request to node that allow let through.
+// Errors returned by the remote method itself (rpc.ServerError) show that the
+// node is reachable, and so don't count as failures.
+func (bs *breakerSet) report(node storagerpc.Node, err error, now time.Time) {
+	if bs.threshold <= 0 {
+		return
+	}
+	if _, ok := err.(rpc.ServerError); ok {
+		err = nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.breaker(node)
+	if err == nil {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= bs.threshold {
+		b.state = BreakerOpen
+		b.openedAt = now
+	}
+}
+
+// stats returns the state of every node the Libstore has sent requests to,
+// and of every other node in ring, whose breakers are still closed.
+func (bs *breakerSet) stats(ring []storagerpc.Node) []NodeStats {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	stats := make([]NodeStats, 0, len(bs.nodes)+len(ring))
+	for node, b := range bs.nodes {
+		stats = append(stats, NodeStats{Node: node, State: b.state, Failures: b.failures})
+	}
+	for _, node := range ring {
+		if _, ok := bs.nodes[node]; !ok {
+			stats = append(stats, NodeStats{Node: node, State: BreakerClosed})
+		}
+	}
+	return stats
+}
+
+// breaker returns the breaker for node, creating it if necessary. bs.mu must
+// be held.
+func (bs *breakerSet) breaker(node storagerpc.Node) *breaker {
+	b, ok := bs.nodes[node]
+	if !ok {
+		b = new(breaker)
+		bs.nodes[node] = b
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package libstore
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestBreakerStates(t *testing.T) {
+	const cooldown = time.Second
+	node := storagerpc.Node{HostPort: "localhost:1", NodeID: 1}
+	failure := errors.New("connection refused")
+	start := time.Now()
+
+	type step struct {
+		at        time.Duration // Since start.
+		wantAllow bool
+		err       error // Reported if the request was allowed.
+		wantState BreakerState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"trips after threshold failures", []step{
+			{at: 0, wantAllow: true, err: failure, wantState: BreakerClosed},
+			{at: 0, wantAllow: true, err: failure, wantState: BreakerClosed},
+			{at: 0, wantAllow: true, err: failure, wantState: BreakerOpen},
+			{at: cooldown / 2, wantAllow: false, wantState: BreakerOpen},
+		}},
+		{"a success resets the count", []step{
+			{at: 0, wantAllow: true, err: failure, wantState: BreakerClosed},
+			{at: 0, wantAllow: true, err: failure, wantState: BreakerClosed},
+			{at: 0, wantAllow: true, wantState: BreakerClosed},
+			{at: 0, wantAllow: true, err: failure, wantState: BreakerClosed},
+		}},
+		{"server errors don't count", []step{
+			{at: 0, wantAllow: true, err: rpc.ServerError("no such key"), wantState: BreakerClosed},
+			{at: 0, wantAllow: true, err: rpc.ServerError("no such key"), wantState: BreakerClosed},
+			{at: 0, wantAllow: true, err: rpc.ServerError("no such key"), wantState: BreakerClosed},
+		}},
+		{"a successful probe closes", []step{
+			{at: 0, wantAllow: true, err: failure},
+			{at: 0, wantAllow: true, err: failure},
+			{at: 0, wantAllow: true, err: failure, wantState: BreakerOpen},
+			{at: cooldown, wantAllow: true, wantState: BreakerClosed},
+			{at: cooldown, wantAllow: true, wantState: BreakerClosed},
+		}},
+		{"a failed probe reopens", []step{
+			{at: 0, wantAllow: true, err: failure},
+			{at: 0, wantAllow: true, err: failure},
+			{at: 0, wantAllow: true, err: failure, wantState: BreakerOpen},
+			{at: cooldown, wantAllow: true, err: failure, wantState: BreakerOpen},
+			{at: cooldown * 3 / 2, wantAllow: false, wantState: BreakerOpen},
+			{at: cooldown * 2, wantAllow: true, wantState: BreakerClosed},
+		}},
+	}
+	for _, tt := range tests {
+		bs := newBreakerSet(3, cooldown)
+		for i, s := range tt.steps {
+			now := start.Add(s.at)
+			err := bs.allow(node, now)
+			if allowed := err == nil; allowed != s.wantAllow {
+				t.Errorf("%s: step %d: allow = %v, want allowed %v", tt.name, i, err, s.wantAllow)
+				break
+			}
+			if err == nil {
+				bs.report(node, s.err, now)
+			} else if _, ok := err.(*NodeUnavailableError); !ok {
+				t.Errorf("%s: step %d: allow = %T, want *NodeUnavailableError", tt.name, i, err)
+			}
+			if got := bs.stats(nil)[0].State; got != s.wantState {
+				t.Errorf("%s: step %d: state = %v, want %v", tt.name, i, got, s.wantState)
+				break
+			}
+		}
+	}
+}
+
+func TestBreakerHalfOpenAllowsOneProbe(t *testing.T) {
+	node := storagerpc.Node{HostPort: "localhost:1"}
+	bs := newBreakerSet(1, time.Second)
+	now := time.Now()
+	bs.report(node, errors.New("timeout"), now)
+
+	now = now.Add(time.Second)
+	if err := bs.allow(node, now); err != nil {
+		t.Fatalf("probe after the cooldown was refused: %v", err)
+	}
+	if err := bs.allow(node, now); err == nil {
+		t.Error("a second request was let through while the probe was outstanding")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	node := storagerpc.Node{HostPort: "localhost:1"}
+	bs := newBreakerSet(0, time.Second)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := bs.allow(node, now); err != nil {
+			t.Fatalf("request %d refused with breakers disabled: %v", i, err)
+		}
+		bs.report(node, errors.New("timeout"), now)
+	}
+}
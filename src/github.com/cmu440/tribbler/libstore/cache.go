@@ -0,0 +1,216 @@
+package libstore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// sweepInterval is how often a Libstore drops entries whose lease and grace
+// period have run out, so that keys which are never read again don't stay
+// in its cache forever.
+const sweepInterval = storagerpc.LeaseSeconds * time.Second
+
+// cacheEntry is a value or list cached under a lease.
+type cacheEntry struct {
+	value   string
+	list    []string
+	expires time.Time
+}
+
+// keyState tracks everything the cache knows about a single key.
+type keyState struct {
+	entry   *cacheEntry // nil if the key is not cached.
+	gen     uint64      // Bumped by every local write and revocation.
+	readers int         // Number of reads of the key in flight.
+}
+
+// leaseCache holds the values and lists the Libstore has leases on.
+//
+// Local writes (Put, Delete, AppendToList and RemoveFromList) invalidate the
+// key instead of updating it in place. By the time the storage server has
+// applied the write it has revoked every lease on the key, ours included, so
+// an updated entry would no longer be covered by a lease and would miss any
+// later write made by another TribServer.
+//
+// Each key carries a generation number so that a read which started before a
+// local write completed cannot repopulate the cache with the old value.
+//
+// Entries whose lease has expired are kept for a further grace period, during
+// which they may be served as stale values if the storage node that owns them
+// cannot be reached (see lookupStale).
+type leaseCache struct {
+	grace time.Duration
+
+	mu     sync.Mutex
+	keys   map[string]*keyState
+	hits   uint64 // Lookups answered from the cache.
+	misses uint64 // Lookups that had to go to a storage server.
+}
+
+func newLeaseCache(grace time.Duration) *leaseCache {
+	return &leaseCache{
+		grace: grace,
+		keys:  make(map[string]*keyState),
+	}
+}
+
+// lookup returns the entry cached for key, if any, whose lease is still valid
+// at time now, and counts the lookup as a hit or a miss. The returned entry
+// must not be modified.
+func (c *leaseCache) lookup(key string, now time.Time) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.valid(key, now)
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return entry, ok
+}
+
+// cached reports whether key has an entry whose lease is still valid at time
+// now. Unlike lookup, it doesn't count towards the hit ratio.
+func (c *leaseCache) cached(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.valid(key, now)
+	return ok
+}
+
+// valid returns the entry cached for key, if its lease is still valid at time
+// now. c.mu must be held.
+func (c *leaseCache) valid(key string, now time.Time) (*cacheEntry, bool) {
+	st, ok := c.keys[key]
+	if !ok || st.entry == nil {
+		return nil, false
+	}
+	if !now.Before(st.entry.expires) {
+		c.expire(key, st, now)
+		return nil, false
+	}
+	return st.entry, true
+}
+
+// stats returns the number of lookups that found a valid entry and the
+// number that didn't.
+func (c *leaseCache) stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// lookupStale returns the entry cached for key, if any, whose lease expired
+// less than the grace period before time now.
+func (c *leaseCache) lookupStale(key string, now time.Time) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.keys[key]
+	if !ok || st.entry == nil || now.Before(st.entry.expires) {
+		return nil, false
+	}
+	if c.expire(key, st, now) {
+		return nil, false
+	}
+	return st.entry, true
+}
+
+// beginRead registers a read of key that is about to be sent to the storage
+// server, and returns the key's current generation. Every call must be
+// followed by a call to endRead.
+func (c *leaseCache) beginRead(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.state(key)
+	st.readers++
+	return st.gen
+}
+
+// endRead completes a read started at generation gen. If entry is non-nil
+// (i.e. the storage server granted a lease) it is cached, unless the key was
+// written or revoked while the read was in flight.
+func (c *leaseCache) endRead(key string, gen uint64, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.state(key)
+	st.readers--
+	if entry != nil && gen == st.gen {
+		st.entry = entry
+	}
+	c.cleanup(key, st)
+}
+
+// invalidate drops key from the cache. It is called after every local write
+// and when a storage server revokes the key's lease, and reports whether the
+// key was cached.
+func (c *leaseCache) invalidate(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.keys[key]
+	if !ok {
+		return false
+	}
+	cached := st.entry != nil
+	st.entry = nil
+	st.gen++
+	c.cleanup(key, st)
+	return cached
+}
+
+// sweep drops every entry whose lease and grace period have expired by time
+// now.
+func (c *leaseCache) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, st := range c.keys {
+		if st.entry != nil {
+			c.expire(key, st, now)
+		}
+	}
+}
+
+// sweepCache sweeps ls.cache every sweepInterval until stop is closed.
+func (ls *libstore) sweepCache(stop <-chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ls.cache.sweep(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// expire drops the entry for key if its grace period has run out by time
+// now, and reports whether it did so. c.mu must be held.
+func (c *leaseCache) expire(key string, st *keyState, now time.Time) bool {
+	if now.Before(st.entry.expires.Add(c.grace)) {
+		return false
+	}
+	st.entry = nil
+	c.cleanup(key, st)
+	return true
+}
+
+// state returns the state for key, creating it if necessary. c.mu must be held.
+func (c *leaseCache) state(key string) *keyState {
+	st, ok := c.keys[key]
+	if !ok {
+		st = new(keyState)
+		c.keys[key] = st
+	}
+	return st
+}
+
+// cleanup forgets key once nothing refers to it. A key's generation only
+// matters while reads of it are in flight, so it is safe to reset it to zero
+// at that point. c.mu must be held.
+func (c *leaseCache) cleanup(key string, st *keyState) {
+	if st.entry == nil && st.readers == 0 {
+		delete(c.keys, key)
+	}
+}
@@ -0,0 +1,48 @@
+package libstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaseCacheSweep(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := newLeaseCache(time.Second)
+	for i, key := range []string{"a", "b", "c"} {
+		gen := c.beginRead(key)
+		c.endRead(key, gen, &cacheEntry{value: key, expires: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	// "a" is past its grace period, "b" is stale and "c" is still leased.
+	c.sweep(start.Add(1500 * time.Millisecond))
+	if _, ok := c.keys["a"]; ok {
+		t.Errorf("sweep kept an entry past its grace period")
+	}
+	if _, ok := c.lookupStale("b", start.Add(1500*time.Millisecond)); !ok {
+		t.Errorf("sweep dropped an entry within its grace period")
+	}
+	if _, ok := c.lookup("c", start.Add(1500*time.Millisecond)); !ok {
+		t.Errorf("sweep dropped an entry whose lease is still valid")
+	}
+}
+
+func TestLeaseCacheWriteDuringRead(t *testing.T) {
+	c := newLeaseCache(0)
+	expires := time.Now().Add(time.Minute)
+
+	// A read that was in flight when the key was written must not cache
+	// the value it read, which may predate the write.
+	gen := c.beginRead("k")
+	c.invalidate("k")
+	c.endRead("k", gen, &cacheEntry{value: "old", expires: expires})
+	if _, ok := c.lookup("k", time.Now()); ok {
+		t.Error("a read that overlapped a write was cached")
+	}
+
+	// A read that starts after the write is cached.
+	gen = c.beginRead("k")
+	c.endRead("k", gen, &cacheEntry{value: "new", expires: expires})
+	if entry, ok := c.lookup("k", time.Now()); !ok || entry.value != "new" {
+		t.Errorf("lookup = %+v, %v; want the value read after the write", entry, ok)
+	}
+}
@@ -0,0 +1,63 @@
+package libstore
+
+import (
+	"errors"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// ErrClosed is returned by operations on a Libstore that has been closed.
+var ErrClosed = errors.New("libstore: closed")
+
+func (ls *libstore) Close() error {
+	ls.mu.Lock()
+	if ls.closed {
+		ls.mu.Unlock()
+		return nil
+	}
+	ls.mu.Unlock()
+
+	if ls.batcher != nil {
+		ls.batcher.flushAll()
+	}
+
+	// Tell the storage servers to forget our leases, so that writes don't
+	// wait on revocations that can no longer be delivered.
+	var err error
+	if ls.mode != Never {
+		args := &storagerpc.ReleaseLeasesArgs{HostPort: ls.myHostPort}
+		for _, node := range ls.ring() {
+			var reply storagerpc.ReleaseLeasesReply
+			if cerr := ls.call(node, "ReleaseLeases", args, &reply); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if !ls.closed {
+		// Not closed concurrently while we were releasing leases.
+		ls.closed = true
+		close(ls.stopSweep)
+	}
+	for hostPort, cli := range ls.clients {
+		cli.Close()
+		delete(ls.clients, hostPort)
+	}
+	return err
+}
+
+// Close releases every lease ls holds. ls must not be used afterwards.
+func (ls *memLibstore) Close() error {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, holders := range s.leases {
+		delete(holders, ls)
+		if len(holders) == 0 {
+			delete(s.leases, key)
+		}
+	}
+	return nil
+}
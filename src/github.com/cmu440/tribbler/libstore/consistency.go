@@ -0,0 +1,65 @@
+package libstore
+
+import "github.com/cmu440/tribbler/rpc/storagerpc"
+
+// WithConsistency sets the consistency at which the Libstore reads (Get,
+// GetList and their asynchronous variants) and writes by default. Both
+// default to storagerpc.ConsistencyOne. AtConsistency overrides them for
+// individual operations.
+//
+// Reads answered from a leased cache entry satisfy any consistency, since a
+// key's primary revokes every lease on it before applying a write.
+func WithConsistency(read, write storagerpc.Consistency) Option {
+	return func(ls *libstore) {
+		ls.readLevel = read
+		ls.writeLevel = write
+	}
+}
+
+// consistencyView is a Libstore that shares its connections, cache and
+// leases with an underlying libstore, but performs every read and write at
+// consistency c.
+type consistencyView struct {
+	*libstore
+	c storagerpc.Consistency
+}
+
+func (ls *libstore) AtConsistency(c storagerpc.Consistency) Libstore {
+	return consistencyView{ls, c}
+}
+
+func (v consistencyView) AtConsistency(c storagerpc.Consistency) Libstore {
+	return consistencyView{v.libstore, c}
+}
+
+func (v consistencyView) Get(key string) (string, error) {
+	return v.get(key, v.c)
+}
+
+func (v consistencyView) GetList(key string) ([]string, error) {
+	return v.getList(key, v.c)
+}
+
+func (v consistencyView) GetAsync(key string) *GetFuture {
+	return &GetFuture{v.readAsync(key, false, v.c)}
+}
+
+func (v consistencyView) GetListAsync(key string) *GetListFuture {
+	return &GetListFuture{v.readAsync(key, true, v.c)}
+}
+
+func (v consistencyView) Put(key, value string) error {
+	return v.update(storagerpc.PutOp, key, value, v.c)
+}
+
+func (v consistencyView) Delete(key string) error {
+	return v.update(storagerpc.DeleteOp, key, "", v.c)
+}
+
+func (v consistencyView) AppendToList(key, newItem string) error {
+	return v.update(storagerpc.AppendToListOp, key, newItem, v.c)
+}
+
+func (v consistencyView) RemoveFromList(key, removeItem string) error {
+	return v.update(storagerpc.RemoveFromListOp, key, removeItem, v.c)
+}
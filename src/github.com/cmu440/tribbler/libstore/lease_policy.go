@@ -0,0 +1,170 @@
+package libstore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// LeasePolicy decides whether a Get or GetList should set GetArgs.WantLease
+// when the Libstore is running in Normal mode. Implementations must be safe
+// for concurrent use.
+type LeasePolicy interface {
+
+	// WantLease records a query for key made at time now and reports
+	// whether the query should request a lease from the storage server.
+	WantLease(key string, now time.Time) bool
+
+	// Record records a query for key made at time now that was served
+	// from the cache, and so made no request to the storage server.
+	Record(key string, now time.Time)
+
+	// LeaseRevoked records that a storage server revoked the lease held
+	// on key at time now. Revocations are the only write signal a Libstore
+	// sees for keys written by other TribServers.
+	LeaseRevoked(key string, now time.Time)
+}
+
+// NewThresholdPolicy returns the policy described in the project handout: a
+// lease is requested once thresh or more queries for the same key (including
+// the current one) have been made in the last window.
+func NewThresholdPolicy(thresh int, window time.Duration) LeasePolicy {
+	return newThresholdPolicy(thresh, window)
+}
+
+// NewDefaultLeasePolicy returns the threshold policy configured with
+// QueryCacheThresh and QueryCacheSeconds.
+func NewDefaultLeasePolicy() LeasePolicy {
+	return NewThresholdPolicy(storagerpc.QueryCacheThresh, storagerpc.QueryCacheSeconds*time.Second)
+}
+
+// NewAdaptivePolicy returns a policy that behaves like the default threshold
+// policy, but additionally tracks how often each key's lease is revoked
+// relative to how often the key is read. Once a key has seen at least
+// minRevocations revocations in the last window and the ratio of revocations
+// to reads in the last window exceeds maxRevokeRatio, the key is considered
+// write-heavy and no further leases are requested for it until the ratio
+// drops again.
+func NewAdaptivePolicy(window time.Duration, minRevocations int, maxRevokeRatio float64) LeasePolicy {
+	return &adaptivePolicy{
+		threshold:      newThresholdPolicy(storagerpc.QueryCacheThresh, storagerpc.QueryCacheSeconds*time.Second),
+		reads:          newThresholdPolicy(0, window),
+		window:         window,
+		minRevocations: minRevocations,
+		maxRevokeRatio: maxRevokeRatio,
+		revocations:    make(map[string]events),
+	}
+}
+
+// events is a list of timestamps in ascending order.
+type events []time.Time
+
+// prune drops all timestamps that are older than cutoff.
+func (e events) prune(cutoff time.Time) events {
+	i := 0
+	for i < len(e) && e[i].Before(cutoff) {
+		i++
+	}
+	return e[i:]
+}
+
+// sweepEvents drops the keys of m whose timestamps are all older than cutoff.
+func sweepEvents(m map[string]events, cutoff time.Time) {
+	for key, e := range m {
+		if len(e) == 0 || e[len(e)-1].Before(cutoff) {
+			delete(m, key)
+		}
+	}
+}
+
+type thresholdPolicy struct {
+	thresh    int
+	window    time.Duration
+	mu        sync.Mutex
+	queries   map[string]events
+	lastSweep time.Time // When keys with no recent queries were last dropped.
+}
+
+func newThresholdPolicy(thresh int, window time.Duration) *thresholdPolicy {
+	return &thresholdPolicy{
+		thresh:  thresh,
+		window:  window,
+		queries: make(map[string]events),
+	}
+}
+
+func (p *thresholdPolicy) WantLease(key string, now time.Time) bool {
+	return p.record(key, now) >= p.thresh
+}
+
+func (p *thresholdPolicy) Record(key string, now time.Time) {
+	p.record(key, now)
+}
+
+func (p *thresholdPolicy) LeaseRevoked(key string, now time.Time) {
+	// Revocations don't affect the handout's policy.
+}
+
+// record adds a query for key at time now and returns the number of queries
+// made for key within the window. Once per window, keys that haven't been
+// queried within it are forgotten.
+func (p *thresholdPolicy) record(key string, now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cutoff := now.Add(-p.window)
+	if p.lastSweep.Before(cutoff) {
+		sweepEvents(p.queries, cutoff)
+		p.lastSweep = now
+	}
+	q := append(p.queries[key].prune(cutoff), now)
+	p.queries[key] = q
+	return len(q)
+}
+
+type adaptivePolicy struct {
+	threshold      *thresholdPolicy // Queries in the last QueryCacheSeconds.
+	reads          *thresholdPolicy // Queries in the last window.
+	window         time.Duration
+	minRevocations int
+	maxRevokeRatio float64
+	mu             sync.Mutex
+	revocations    map[string]events
+	lastSweep      time.Time
+}
+
+func (p *adaptivePolicy) WantLease(key string, now time.Time) bool {
+	reads := p.reads.record(key, now)
+	if p.threshold.record(key, now) < p.threshold.thresh {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	revokes := p.revocations[key].prune(now.Add(-p.window))
+	if len(revokes) == 0 {
+		delete(p.revocations, key)
+		return true
+	}
+	p.revocations[key] = revokes
+	if len(revokes) < p.minRevocations {
+		return true
+	}
+	return float64(len(revokes))/float64(reads) <= p.maxRevokeRatio
+}
+
+func (p *adaptivePolicy) Record(key string, now time.Time) {
+	p.reads.record(key, now)
+	p.threshold.record(key, now)
+}
+
+func (p *adaptivePolicy) LeaseRevoked(key string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cutoff := now.Add(-p.window)
+	if p.lastSweep.Before(cutoff) {
+		sweepEvents(p.revocations, cutoff)
+		p.lastSweep = now
+	}
+	p.revocations[key] = append(p.revocations[key].prune(cutoff), now)
+}
@@ -0,0 +1,198 @@
+package libstore
+
+import (
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// policyEvent is a query (revoke and hit false), a query served from the
+// cache (hit) or a revocation of key at offset at from the start of a test,
+// with the lease decision expected for queries.
+type policyEvent struct {
+	at     time.Duration
+	key    string
+	revoke bool
+	hit    bool
+	want   bool
+}
+
+func runPolicy(t *testing.T, name string, p LeasePolicy, events []policyEvent) {
+	start := time.Unix(1000, 0)
+	for i, e := range events {
+		now := start.Add(e.at)
+		if e.revoke {
+			p.LeaseRevoked(e.key, now)
+			continue
+		}
+		if e.hit {
+			p.Record(e.key, now)
+			continue
+		}
+		if got := p.WantLease(e.key, now); got != e.want {
+			t.Errorf("%s: event %d: WantLease(%q) at %v = %v, want %v", name, i, e.key, e.at, got, e.want)
+		}
+	}
+}
+
+func TestThresholdPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []policyEvent
+	}{
+		{"third query in window", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: time.Second, key: "a", want: false},
+			{at: 2 * time.Second, key: "a", want: true},
+			{at: 3 * time.Second, key: "a", want: true},
+		}},
+		{"keys counted separately", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "b", want: false},
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "b", want: false},
+			{at: 0, key: "c", want: false},
+		}},
+		{"old queries expire", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: time.Second, key: "a", want: false},
+			{at: 12 * time.Second, key: "a", want: false},
+			{at: 13 * time.Second, key: "a", want: false},
+			{at: 14 * time.Second, key: "a", want: true},
+		}},
+		{"cache hits count as queries", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: time.Second, key: "a", hit: true},
+			{at: 2 * time.Second, key: "a", want: true},
+		}},
+		{"revocations ignored", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", revoke: true},
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", revoke: true},
+			{at: 0, key: "a", want: true},
+		}},
+	}
+	for _, test := range tests {
+		runPolicy(t, test.name, NewDefaultLeasePolicy(), test.events)
+	}
+}
+
+func TestAdaptivePolicy(t *testing.T) {
+	window := time.Minute
+	tests := []struct {
+		name   string
+		events []policyEvent
+	}{
+		{"like the threshold policy without revocations", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: time.Second, key: "a", want: false},
+			{at: 2 * time.Second, key: "a", want: true},
+		}},
+		{"threshold uses QueryCacheSeconds, not window", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: 20 * time.Second, key: "a", want: false},
+			{at: 40 * time.Second, key: "a", want: false},
+			{at: 41 * time.Second, key: "a", want: false},
+			{at: 42 * time.Second, key: "a", want: true},
+		}},
+		{"write-heavy key", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", want: true},
+			{at: time.Second, key: "a", revoke: true},
+			{at: 2 * time.Second, key: "a", revoke: true},
+			{at: 3 * time.Second, key: "a", want: false}, // 2 revocations in 4 reads.
+			{at: 3 * time.Second, key: "b", want: false},
+		}},
+		{"cache hits count as reads", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", want: true},
+			{at: time.Second, key: "a", hit: true},
+			{at: time.Second, key: "a", hit: true},
+			{at: time.Second, key: "a", hit: true},
+			{at: time.Second, key: "a", hit: true},
+			{at: 2 * time.Second, key: "a", revoke: true},
+			{at: 2 * time.Second, key: "a", revoke: true},
+			{at: 3 * time.Second, key: "a", want: true}, // 2 revocations in 8 reads.
+		}},
+		{"too few revocations", []policyEvent{
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", revoke: true},
+			{at: 0, key: "a", want: true},
+		}},
+		{"revocations expire", []policyEvent{
+			{at: 0, key: "a", revoke: true},
+			{at: 0, key: "a", revoke: true},
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", want: false},
+			{at: 0, key: "a", want: false},
+			{at: 2 * time.Minute, key: "a", want: false},
+			{at: 2 * time.Minute, key: "a", want: false},
+			{at: 2 * time.Minute, key: "a", want: true},
+		}},
+	}
+	for _, test := range tests {
+		runPolicy(t, test.name, NewAdaptivePolicy(window, 2, 0.25), test.events)
+	}
+}
+
+func TestThresholdPolicyForgetsIdleKeys(t *testing.T) {
+	p := newThresholdPolicy(storagerpc.QueryCacheThresh, 10*time.Second)
+	start := time.Unix(1000, 0)
+	p.record("a", start)
+	p.record("b", start)
+	p.record("c", start.Add(15*time.Second))
+	if _, ok := p.queries["a"]; ok || len(p.queries) != 1 {
+		t.Errorf("queries = %v, want only key c", p.queries)
+	}
+}
+
+// countingPolicy is a LeasePolicy that always wants a lease, counting the
+// queries it is asked about and the cache hits recorded with it.
+type countingPolicy struct {
+	mu      sync.Mutex
+	queries int
+	hits    int
+}
+
+func (p *countingPolicy) WantLease(key string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queries++
+	return true
+}
+
+func (p *countingPolicy) Record(key string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hits++
+}
+
+func (p *countingPolicy) LeaseRevoked(key string, now time.Time) {}
+
+func TestCacheHitsRecorded(t *testing.T) {
+	f := startFakeStorage(t)
+	f.values["k"] = "v"
+	p := &countingPolicy{}
+	ls, err := NewLibstore(f.hostPort, "", Normal, WithLeasePolicy(p), WithCallbackServer(rpc.NewServer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	for i := 0; i < 3; i++ {
+		if value, err := ls.Get("k"); err != nil || value != "v" {
+			t.Fatalf("Get = %q, %v; want %q, nil", value, err, "v")
+		}
+	}
+	ls.GetAsync("k").Wait()
+	if f.readCount() != 1 || p.queries != 1 || p.hits != 3 {
+		t.Errorf("%d reads, %d queries and %d cache hits; want 1, 1 and 3", f.readCount(), p.queries, p.hits)
+	}
+}
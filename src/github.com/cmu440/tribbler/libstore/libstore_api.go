@@ -3,8 +3,7 @@
 package libstore
 
 import (
-	"hash/fnv"
-	"strings"
+	"fmt"
 
 	"github.com/cmu440/tribbler/rpc/storagerpc"
 )
@@ -28,6 +27,60 @@ type Libstore interface {
 	GetList(key string) ([]string, error)
 	AppendToList(key, newItem string) error
 	RemoveFromList(key, removeItem string) error
+
+	// GetAsync starts a Get of key and returns without waiting for it.
+	GetAsync(key string) *GetFuture
+
+	// GetListAsync starts a GetList of key and returns without waiting
+	// for it.
+	GetListAsync(key string) *GetListFuture
+
+	// Prefetch asynchronously loads the values of keys into the cache,
+	// requesting leases on them regardless of the lease policy. It returns
+	// immediately, and errors are ignored.
+	Prefetch(keys ...string)
+
+	// PrefetchList is like Prefetch, but for keys whose values are lists.
+	PrefetchList(keys ...string)
+
+	// Stats returns a snapshot of the Libstore's counters.
+	Stats() Stats
+
+	// AtConsistency returns a Libstore that shares this one's connections,
+	// cache and leases, but performs every read and write at consistency
+	// c (e.g. storagerpc.ConsistencyAll for creating users).
+	AtConsistency(c storagerpc.Consistency) Libstore
+
+	// Close flushes any batched writes, asks every storage server to drop
+	// the leases this Libstore holds (so that writes stop revoking them),
+	// and closes its connections. Operations after Close fail with
+	// ErrClosed. The caller should stop serving RevokeLease RPCs first.
+	Close() error
+}
+
+// Stats describes the work a Libstore has done since it was created.
+type Stats struct {
+	ReadRPCs       uint64 // Get/GetList RPCs sent to the storage servers.
+	CoalescedReads uint64 // Get/GetList calls that shared another call's in-flight RPC.
+	StaleReads     uint64 // Get/GetList calls answered with an expired value.
+	CacheHits      uint64 // Get/GetList calls answered from the lease cache.
+	CacheMisses    uint64 // Get/GetList calls that found nothing valid in the lease cache.
+
+	// Nodes describes the circuit breaker guarding each storage node in the
+	// ring, and any other the Libstore has sent requests to.
+	Nodes []NodeStats
+}
+
+// StatusError is returned by a Libstore operation when the storage server
+// replies with a status other than OK (e.g. KeyNotFound or ItemExists).
+type StatusError struct {
+	Op     string // The Libstore method, e.g. "Get".
+	Key    string
+	Status storagerpc.Status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s(%q) failed with status %v", e.Op, e.Key, e.Status)
 }
 
 // LeaseCallbacks defines the set of methods that a StorageServer can call
@@ -41,12 +94,11 @@ type LeaseCallbacks interface {
 	RevokeLease(*storagerpc.RevokeLeaseArgs, *storagerpc.RevokeLeaseReply) error
 }
 
-// StoreHash hashes a string key and returns a 32-bit integer. This function
-// is provided here so that all implementations use the same hashing mechanism
-// (both the Libstore and StorageServer should use this function to hash keys).
+// StoreHash hashes a string key and returns a 32-bit integer, using the
+// default PrefixPartitioner.
+//
+// Deprecated: the Libstore and StorageServer should hash keys with the
+// cluster's Partitioner instead.
 func StoreHash(key string) uint32 {
-	prefix := strings.Split(key, ":")[0]
-	hasher := fnv.New32()
-	hasher.Write([]byte(prefix))
-	return hasher.Sum32()
+	return PrefixPartitioner.Hash(key)
 }
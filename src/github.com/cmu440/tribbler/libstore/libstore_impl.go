@@ -2,12 +2,57 @@ package libstore
 
 import (
 	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
 
+	"github.com/cmu440/tribbler/rpc/librpc"
 	"github.com/cmu440/tribbler/rpc/storagerpc"
 )
 
+// NewLibstore asks the master for the list of storage servers up to
+// maxGetServersAttempts times, getServersRetryInterval apart, while the ring
+// is still being formed.
+const (
+	maxGetServersAttempts   = 5
+	getServersRetryInterval = time.Second
+)
+
 type libstore struct {
-	// TODO: implement this!
+	myHostPort  string // Callback address sent with lease requests.
+	mode        LeaseMode
+	partitioner Partitioner   // The ring's partitioner, as reported by GetServers.
+	leasePolicy LeasePolicy   // Consulted in Normal mode only.
+	flights     *flightGroup  // Coalesces concurrent Get/GetList RPCs per key.
+	cache       *leaseCache   // Values and lists held under a lease.
+	prefetches  chan struct{} // Limits outstanding prefetches to maxPrefetches.
+	callbacks   *rpc.Server   // Where the LeaseCallbacks service is registered.
+
+	// Retry and hedging policies for Get and GetList.
+	getPolicy     ReadPolicy
+	getListPolicy ReadPolicy
+
+	batcher    *writeBatcher // Groups writes per node; nil unless batching is enabled.
+	breakers   *breakerSet   // Circuit breakers guarding each storage node.
+	staleGrace time.Duration // How long expired entries may be served as stale.
+
+	// Default consistency levels; see WithConsistency.
+	readLevel  storagerpc.Consistency
+	writeLevel storagerpc.Consistency
+
+	masterHostPort string     // Asked for the ring again by refreshRoutes.
+	refreshing     sync.Mutex // Held by refreshRoutes.
+
+	mu         sync.Mutex
+	servers    []storagerpc.Node            // All storage nodes in the ring.
+	assigned   []storagerpc.RangeAssignment // Ranges moved off their ring owners, oldest first.
+	routed     time.Time                    // When servers and assigned were fetched.
+	clients    map[string]*rpc.Client       // Connections to storage nodes, by host:port.
+	leaders    map[string]storagerpc.Node   // Leader of each owner's range, by owner host:port.
+	closed     bool                         // Set by Close; no new connections are made.
+	stopSweep  chan struct{}                // Closed by Close to stop sweepCache.
+	staleReads uint64                       // Reads answered by staleFallback.
 }
 
 // NewLibstore creates a new instance of a TribServer's libstore. masterServerHostPort
@@ -24,44 +69,544 @@ type libstore struct {
 // decisions on whether or not a lease should be requested from the storage server,
 // based on the requirements specified in the project PDF handout.  Note that the
 // value of the mode flag may also determine whether or not the Libstore should
-// register to receive RPCs from the storage servers.
+// register to receive RPCs from the storage servers. In Normal mode, the
+// decision is delegated to a LeasePolicy (see WithLeasePolicy), which is also
+// told about every lease revoked through RevokeLease.
+//
+// Keys are routed using the partitioner named in the master's GetServers
+// reply (see LookupPartitioner); NewLibstore fails if it doesn't recognize it.
+//
+// Concurrent Get (or GetList) calls on the same key are coalesced so that only
+// one RPC is sent; all callers share its reply and any lease it carries.
+// Put, Delete, AppendToList and RemoveFromList invalidate the written key in
+// the local cache before returning, so a TribServer always reads its own
+// writes.
 //
-// To register the Libstore to receive RPCs from the storage servers, the following
-// line of code should suffice:
+// Get and GetList RPCs that fail are retried, and may be hedged against a
+// replica of the key's node, according to the policies set by WithGetPolicy
+// and WithGetListPolicy. By default each read is attempted exactly once.
 //
-//     rpc.RegisterName("LeaseCallbacks", librpc.Wrap(libstore))
+// Each storage node is guarded by a circuit breaker: once a node has failed
+// defaultBreakerThreshold requests in a row, requests for keys it owns fail
+// immediately with a *NodeUnavailableError until it recovers. The breakers can
+// be tuned or disabled with WithCircuitBreaker.
 //
-// Note that unlike in the NewTribServer and NewStorageServer functions, there is no
-// need to create a brand new HTTP handler to serve the requests (the Libstore may
-// simply reuse the TribServer's HTTP handler since the two run in the same process).
-func NewLibstore(masterServerHostPort, myHostPort string, mode LeaseMode) (Libstore, error) {
-	return nil, errors.New("not implemented")
+// Reads and writes are performed at the consistency levels set with
+// WithConsistency, or at the level given to AtConsistency. Reads above
+// ConsistencyOne always go to the key's primary, which consults the replicas,
+// and are never hedged.
+//
+// When a storage node replies with status WrongServer, because the key's
+// range has been moved to another node with TransferRange, the Libstore asks
+// the master for the ring and its range assignments again and resends the
+// request once. It does the same when a node replies NotReady or can't be
+// reached (as when it is leaving the ring), resending the request if the key
+// has a new owner.
+//
+// When a storage node replies with status NotLeader (because the key's range
+// is replicated by a Raft group that the node doesn't lead), the Libstore
+// resends the request to the leader named in the reply, up to maxRedirects
+// times, and sends later requests for the range straight to that leader.
+//
+// If WithStaleGrace is given, a Get or GetList whose storage node cannot be
+// reached may return a recently expired cached value along with a
+// *StaleError, rather than failing outright.
+//
+// If WithWriteBatching is given, writes to the same storage node are grouped
+// into Batch RPCs.
+//
+// GetAsync and GetListAsync send their RPCs immediately and return futures, so
+// that many independent reads can be overlapped. They share the cache, circuit
+// breakers and stale fallback with Get and GetList, but are neither coalesced
+// nor retried.
+//
+// Unless mode is Never, the Libstore registers its "LeaseCallbacks" service
+// with rpc.DefaultServer, or with the server given to WithCallbackServer; it
+// is served by whichever HTTP handler serves that rpc.Server.
+func NewLibstore(masterServerHostPort, myHostPort string, mode LeaseMode, opts ...Option) (Libstore, error) {
+	ls := &libstore{
+		myHostPort:     myHostPort,
+		masterHostPort: masterServerHostPort,
+		mode:           mode,
+		leasePolicy:    NewDefaultLeasePolicy(),
+		flights:        newFlightGroup(),
+		prefetches:     make(chan struct{}, maxPrefetches),
+		breakers:       newBreakerSet(defaultBreakerThreshold, defaultBreakerCooldown),
+		callbacks:      rpc.DefaultServer,
+		clients:        make(map[string]*rpc.Client),
+		leaders:        make(map[string]storagerpc.Node),
+	}
+	for _, opt := range opts {
+		opt(ls)
+	}
+	ls.cache = newLeaseCache(ls.staleGrace)
+
+	master, err := rpc.DialHTTP("tcp", masterServerHostPort)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := getServers(master)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	p, ok := LookupPartitioner(reply.Partitioner)
+	if !ok {
+		master.Close()
+		return nil, fmt.Errorf("libstore: unknown partitioner %q", reply.Partitioner)
+	}
+	ls.partitioner = p
+	ls.setRoutes(reply, time.Now())
+	ls.clients[masterServerHostPort] = master
+
+	if mode != Never {
+		if err := ls.callbacks.RegisterName("LeaseCallbacks", librpc.Wrap(ls)); err != nil {
+			master.Close()
+			return nil, err
+		}
+	}
+	ls.stopSweep = make(chan struct{})
+	go ls.sweepCache(ls.stopSweep)
+	return ls, nil
+}
+
+// getServers asks master for the nodes in the ring, retrying while the ring
+// is not yet ready.
+func getServers(master *rpc.Client) (*storagerpc.GetServersReply, error) {
+	for i := 1; ; i++ {
+		var reply storagerpc.GetServersReply
+		if err := master.Call("StorageServer.GetServers", &storagerpc.GetServersArgs{}, &reply); err != nil {
+			return nil, err
+		}
+		if reply.Status == storagerpc.OK {
+			return &reply, nil
+		}
+		if i == maxGetServersAttempts {
+			return nil, errors.New("libstore: storage servers not ready")
+		}
+		time.Sleep(getServersRetryInterval)
+	}
+}
+
+// route returns the storage node to send requests for key to: the leader
+// last reported for the key's range, or else the key's owner.
+func (ls *libstore) route(key string) storagerpc.Node {
+	return ls.leaderOf(ls.owner(key))
+}
+
+// cached returns the cache entry for key if its lease is still valid at now,
+// recording the query with the lease policy.
+func (ls *libstore) cached(key string, now time.Time) (*cacheEntry, bool) {
+	entry, ok := ls.cache.lookup(key, now)
+	if ok && ls.mode == Normal {
+		ls.leasePolicy.Record(key, now)
+	}
+	return entry, ok
+}
+
+// wantLease reports whether a Get or GetList on key should request a lease,
+// according to the Libstore's lease mode and policy.
+func (ls *libstore) wantLease(key string) bool {
+	switch ls.mode {
+	case Always:
+		return true
+	case Normal:
+		return ls.leasePolicy.WantLease(key, time.Now())
+	default:
+		return false
+	}
+}
+
+// client returns a connection to node, dialing it if necessary.
+func (ls *libstore) client(node storagerpc.Node) (*rpc.Client, error) {
+	ls.mu.Lock()
+	cli, ok := ls.clients[node.HostPort]
+	closed := ls.closed
+	ls.mu.Unlock()
+	if ok {
+		return cli, nil
+	} else if closed {
+		return nil, ErrClosed
+	}
+
+	cli, err := rpc.DialHTTP("tcp", node.HostPort)
+	if err != nil {
+		return nil, err
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.closed {
+		cli.Close()
+		return nil, ErrClosed
+	}
+	if existing, ok := ls.clients[node.HostPort]; ok {
+		// Somebody else dialed the node first.
+		cli.Close()
+		return existing, nil
+	}
+	ls.clients[node.HostPort] = cli
+	return cli, nil
+}
+
+// pendingCall is a StorageServer RPC that has been sent but not waited on.
+type pendingCall struct {
+	node storagerpc.Node
+	cli  *rpc.Client // nil if the call could not be sent.
+	call *rpc.Call
+	err  error // Why the call could not be sent.
+}
+
+// send starts the named StorageServer method on node without waiting for it
+// to complete, failing fast if the node's circuit breaker is open. The
+// returned call must be passed to wait.
+func (ls *libstore) send(node storagerpc.Node, method string, args, reply interface{}) *pendingCall {
+	if err := ls.breakers.allow(node, time.Now()); err != nil {
+		return &pendingCall{node: node, err: err}
+	}
+	cli, err := ls.client(node)
+	if err != nil {
+		ls.breakers.report(node, err, time.Now())
+		return &pendingCall{node: node, err: err}
+	}
+	return &pendingCall{
+		node: node,
+		cli:  cli,
+		call: cli.Go("StorageServer."+method, args, reply, nil),
+	}
+}
+
+// wait waits for p to complete and returns its error. A connection that has
+// been shut down is discarded so that the next call redials the node.
+func (ls *libstore) wait(p *pendingCall) error {
+	if p.call == nil {
+		return p.err
+	}
+	<-p.call.Done
+	err := p.call.Error
+	if err == rpc.ErrShutdown {
+		ls.mu.Lock()
+		if ls.clients[p.node.HostPort] == p.cli {
+			delete(ls.clients, p.node.HostPort)
+		}
+		ls.mu.Unlock()
+	}
+	ls.breakers.report(p.node, err, time.Now())
+	return err
+}
+
+// call invokes the named StorageServer method on node and waits for it to
+// complete.
+func (ls *libstore) call(node storagerpc.Node, method string, args, reply interface{}) error {
+	return ls.wait(ls.send(node, method, args, reply))
+}
+
+// leaseEntry returns the cache entry for a value or list read at time sent,
+// or nil if no lease was granted.
+func leaseEntry(value string, list []string, lease storagerpc.Lease, sent time.Time) *cacheEntry {
+	if !lease.Granted {
+		return nil
+	}
+	return &cacheEntry{
+		value:   value,
+		list:    list,
+		expires: sent.Add(time.Duration(lease.ValidSeconds) * time.Second),
+	}
+}
+
+// writeMethods maps each kind of write to the StorageServer method that
+// performs it on its own.
+var writeMethods = map[storagerpc.WriteOp]string{
+	storagerpc.PutOp:            "Put",
+	storagerpc.DeleteOp:         "Delete",
+	storagerpc.AppendToListOp:   "AppendToList",
+	storagerpc.RemoveFromListOp: "RemoveFromList",
+}
+
+// write applies w on the storage server responsible for its key, as part of
+// a batch if write batching is enabled, and then invalidates the key in the
+// local cache. NotLeader replies are followed to the reported leader, and the
+// write is sent again if the routes turn out to be out of date (see
+// reroute).
+func (ls *libstore) write(w storagerpc.Write) (storagerpc.Status, error) {
+	defer ls.cache.invalidate(w.Key)
+	start := time.Now()
+	owner := ls.owner(w.Key)
+	status, err := ls.writeOwner(w)
+	if ls.reroute(w.Key, owner, start, status, err) {
+		status, err = ls.writeOwner(w)
+	}
+	return status, err
+}
+
+// reroute reports whether a request for key, sent at time start to owner,
+// should be sent again after getting status or, if err is non-nil, failing.
+// A storage node replies WrongServer once key's range has been moved away;
+// a node that is leaving the ring replies NotReady while it drains, and
+// can't be reached once it has gone. In each case the routes are refreshed,
+// and the request is resent if it failed with WrongServer or if key now has
+// another owner.
+func (ls *libstore) reroute(key string, owner storagerpc.Node, start time.Time, status storagerpc.Status, err error) bool {
+	wrongServer := err == nil && status == storagerpc.WrongServer
+	if !wrongServer && err == nil && status != storagerpc.NotReady {
+		return false
+	}
+	if ls.refreshRoutes(start) != nil {
+		return false
+	}
+	return wrongServer || ls.owner(key) != owner
+}
+
+// writeOwner applies w on the storage server the routes say is responsible
+// for its key, following NotLeader replies to the reported leader.
+func (ls *libstore) writeOwner(w storagerpc.Write) (storagerpc.Status, error) {
+	owner := ls.owner(w.Key)
+	node := ls.leaderOf(owner)
+	if ls.batcher != nil {
+		status, err := ls.batcher.do(node, w)
+		if err != nil || status != storagerpc.NotLeader {
+			return status, err
+		}
+		// A batch reply doesn't say who leads each write's range, so
+		// resend the write on its own to find out.
+	}
+	for i := 0; ; i++ {
+		status, leader, err := ls.writeTo(node, w)
+		if err != nil && node != owner {
+			ls.forgetLeader(owner, node)
+		}
+		if err != nil || status != storagerpc.NotLeader || leader == "" || i == maxRedirects {
+			return status, err
+		}
+		node = ls.redirect(owner, leader)
+	}
+}
+
+// writeTo sends w to node as an individual RPC, returning the reply's status
+// and, with status NotLeader, the leader it names.
+func (ls *libstore) writeTo(node storagerpc.Node, w storagerpc.Write) (storagerpc.Status, string, error) {
+	if w.Op == storagerpc.DeleteOp {
+		var reply storagerpc.DeleteReply
+		err := ls.call(node, "Delete", &storagerpc.DeleteArgs{Key: w.Key, Consistency: w.Consistency}, &reply)
+		return reply.Status, reply.Leader, err
+	}
+	var reply storagerpc.PutReply
+	err := ls.call(node, writeMethods[w.Op], &storagerpc.PutArgs{Key: w.Key, Value: w.Value, Consistency: w.Consistency}, &reply)
+	return reply.Status, reply.Leader, err
+}
+
+// update applies a write at consistency c, turning a reply status other than
+// OK into a *StatusError.
+func (ls *libstore) update(op storagerpc.WriteOp, key, value string, c storagerpc.Consistency) error {
+	status, err := ls.write(storagerpc.Write{Op: op, Key: key, Value: value, Consistency: c})
+	if err != nil {
+		return err
+	}
+	if status != storagerpc.OK {
+		return &StatusError{Op: writeMethods[op], Key: key, Status: status}
+	}
+	return nil
+}
+
+// readReply holds the fields of a GetReply or GetListReply.
+type readReply struct {
+	status storagerpc.Status
+	value  string
+	list   []string
+	lease  storagerpc.Lease
+	leader string
+	sent   time.Time // When the read was sent, for the lease's expiry.
+}
+
+// readFrom sends args to node as a Get or, if isList is set, a GetList.
+func (ls *libstore) readFrom(node storagerpc.Node, args *storagerpc.GetArgs, isList bool) (*readReply, error) {
+	if isList {
+		var reply storagerpc.GetListReply
+		if err := ls.call(node, "GetList", args, &reply); err != nil {
+			return nil, err
+		}
+		return &readReply{status: reply.Status, list: reply.Value, lease: reply.Lease, leader: reply.Leader}, nil
+	}
+	var reply storagerpc.GetReply
+	if err := ls.call(node, "Get", args, &reply); err != nil {
+		return nil, err
+	}
+	return &readReply{status: reply.Status, value: reply.Value, lease: reply.Lease, leader: reply.Leader}, nil
+}
+
+// read sends args to the storage server responsible for its key, following
+// NotLeader replies to the reported leader and sending it again if the routes
+// turn out to be out of date (see reroute).
+func (ls *libstore) read(args *storagerpc.GetArgs, isList bool) (*readReply, error) {
+	start := time.Now()
+	owner := ls.owner(args.Key)
+	reply, err := ls.readOwner(args, isList)
+	var status storagerpc.Status
+	if err == nil {
+		status = reply.status
+	}
+	if ls.reroute(args.Key, owner, start, status, err) {
+		reply, err = ls.readOwner(args, isList)
+	}
+	return reply, err
+}
+
+// readOwner sends args to the storage server the routes say is responsible
+// for its key, following NotLeader replies to the reported leader.
+func (ls *libstore) readOwner(args *storagerpc.GetArgs, isList bool) (*readReply, error) {
+	owner := ls.owner(args.Key)
+	node := ls.leaderOf(owner)
+	for i := 0; ; i++ {
+		reply, err := ls.readFrom(node, args, isList)
+		if err != nil && node != owner {
+			ls.forgetLeader(owner, node)
+		}
+		if err != nil || reply.status != storagerpc.NotLeader || reply.leader == "" || i == maxRedirects {
+			return reply, err
+		}
+		node = ls.redirect(owner, reply.leader)
+	}
+}
+
+// fetchReply reads key at consistency c, requesting a lease if wantLease is
+// set, and caches the reply if a lease is granted. Concurrent calls for the
+// same key share a single RPC, which is retried and hedged according to the
+// Get or GetList policy.
+func (ls *libstore) fetchReply(key string, wantLease, isList bool, c storagerpc.Consistency) (*readReply, error) {
+	op := "Get"
+	policy := ls.getPolicy
+	if isList {
+		op, policy = "GetList", ls.getListPolicy
+	}
+	gen := ls.cache.beginRead(key)
+	value, err, _ := ls.flights.do(flightKey(op, key, c, wantLease, gen), func() (interface{}, error) {
+		args := &storagerpc.GetArgs{Key: key, WantLease: wantLease, HostPort: ls.myHostPort, Consistency: c}
+		nodes := ls.readNodes(key, c, policy)
+		sent := time.Now()
+		return policy.do(nodes, func(node storagerpc.Node) (interface{}, error) {
+			var reply *readReply
+			var err error
+			if node == nodes[0] {
+				reply, err = ls.read(args, isList)
+			} else {
+				reply, err = ls.readReplica(node, args, isList)
+			}
+			if err != nil {
+				return nil, err
+			}
+			reply.sent = sent
+			return reply, nil
+		})
+	})
+	reply, _ := value.(*readReply)
+	var entry *cacheEntry
+	if err == nil && reply.status == storagerpc.OK {
+		entry = leaseEntry(reply.value, reply.list, reply.lease, reply.sent)
+	}
+	ls.cache.endRead(key, gen, entry)
+	return reply, err
+}
+
+// fetch reads key at consistency c from the storage server responsible for
+// it, requesting a lease if wantLease is set, and caches the value if a lease
+// is granted.
+func (ls *libstore) fetch(key string, wantLease bool, c storagerpc.Consistency) (string, error) {
+	reply, err := ls.fetchReply(key, wantLease, false, c)
+	if err != nil {
+		return "", err
+	}
+	if reply.status != storagerpc.OK {
+		return "", &StatusError{Op: "Get", Key: key, Status: reply.status}
+	}
+	return reply.value, nil
+}
+
+// fetchList is like fetch, but reads a list.
+func (ls *libstore) fetchList(key string, wantLease bool, c storagerpc.Consistency) ([]string, error) {
+	reply, err := ls.fetchReply(key, wantLease, true, c)
+	if err != nil {
+		return nil, err
+	}
+	if reply.status != storagerpc.OK {
+		return nil, &StatusError{Op: "GetList", Key: key, Status: reply.status}
+	}
+	return reply.list, nil
+}
+
+// get reads key at consistency c, from the cache if possible. If the read
+// fails to reach the storage server, a stale value may be returned instead
+// (see WithStaleGrace).
+func (ls *libstore) get(key string, c storagerpc.Consistency) (string, error) {
+	if entry, ok := ls.cached(key, time.Now()); ok {
+		return entry.value, nil
+	}
+	value, err := ls.fetch(key, ls.wantLease(key), c)
+	if _, ok := err.(*StatusError); err != nil && !ok {
+		if entry, staleErr := ls.staleFallback(key, err); entry != nil {
+			return entry.value, staleErr
+		}
+	}
+	return value, err
+}
+
+// getList is like get, but reads a list.
+func (ls *libstore) getList(key string, c storagerpc.Consistency) ([]string, error) {
+	if entry, ok := ls.cached(key, time.Now()); ok {
+		return append([]string(nil), entry.list...), nil
+	}
+	list, err := ls.fetchList(key, ls.wantLease(key), c)
+	if _, ok := err.(*StatusError); err != nil && !ok {
+		if entry, staleErr := ls.staleFallback(key, err); entry != nil {
+			return append([]string(nil), entry.list...), staleErr
+		}
+	}
+	return append([]string(nil), list...), err
 }
 
 func (ls *libstore) Get(key string) (string, error) {
-	return "", errors.New("not implemented")
+	return ls.get(key, ls.readLevel)
 }
 
 func (ls *libstore) Put(key, value string) error {
-	return errors.New("not implemented")
+	return ls.update(storagerpc.PutOp, key, value, ls.writeLevel)
 }
 
 func (ls *libstore) Delete(key string) error {
-	return errors.New("not implemented")
+	return ls.update(storagerpc.DeleteOp, key, "", ls.writeLevel)
 }
 
 func (ls *libstore) GetList(key string) ([]string, error) {
-	return nil, errors.New("not implemented")
+	return ls.getList(key, ls.readLevel)
 }
 
 func (ls *libstore) RemoveFromList(key, removeItem string) error {
-	return errors.New("not implemented")
+	return ls.update(storagerpc.RemoveFromListOp, key, removeItem, ls.writeLevel)
 }
 
 func (ls *libstore) AppendToList(key, newItem string) error {
-	return errors.New("not implemented")
+	return ls.update(storagerpc.AppendToListOp, key, newItem, ls.writeLevel)
 }
 
 func (ls *libstore) RevokeLease(args *storagerpc.RevokeLeaseArgs, reply *storagerpc.RevokeLeaseReply) error {
-	return errors.New("not implemented")
+	ls.leasePolicy.LeaseRevoked(args.Key, time.Now())
+	if ls.cache.invalidate(args.Key) {
+		reply.Status = storagerpc.OK
+	} else {
+		reply.Status = storagerpc.KeyNotFound
+	}
+	return nil
+}
+
+func (ls *libstore) Stats() Stats {
+	issued, coalesced := ls.flights.stats()
+	hits, misses := ls.cache.stats()
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return Stats{
+		ReadRPCs:       issued,
+		CoalescedReads: coalesced,
+		StaleReads:     ls.staleReads,
+		CacheHits:      hits,
+		CacheMisses:    misses,
+		Nodes:          ls.breakers.stats(ls.servers),
+	}
 }
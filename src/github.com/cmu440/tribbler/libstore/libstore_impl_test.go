@@ -0,0 +1,269 @@
+package libstore
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// fakeStorage is a storage server that answers GetServers with a fixed ring
+// and range assignments, and serves Get, GetList and Put from memory,
+// counting the reads it receives. It never revokes leases.
+type fakeStorage struct {
+	hostPort string
+	listener net.Listener
+
+	mu      sync.Mutex
+	ring    []storagerpc.Node
+	values  map[string]string
+	lists   map[string][]string
+	reads   int
+	active  int           // Reads in progress.
+	busiest int           // Most reads ever in progress at once.
+	fail    int           // Number of reads still to fail.
+	delay   time.Duration // How long each read takes.
+	release chan struct{} // If non-nil, reads wait until it is closed.
+
+	assigned []storagerpc.RangeAssignment
+	moved    bool // Reads reply WrongServer.
+}
+
+// startFakeStorage starts a fakeStorage whose ring contains only itself.
+func startFakeStorage(t *testing.T) *fakeStorage {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeStorage{
+		hostPort: l.Addr().String(),
+		listener: l,
+		values:   make(map[string]string),
+		lists:    make(map[string][]string),
+	}
+	f.ring = []storagerpc.Node{{HostPort: f.hostPort}}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("StorageServer", f); err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, srv)
+	t.Cleanup(func() { l.Close() })
+	return f
+}
+
+func (f *fakeStorage) GetServers(args *storagerpc.GetServersArgs, reply *storagerpc.GetServersReply) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply.Status = storagerpc.OK
+	reply.Servers = f.ring
+	reply.Assignments = f.assigned
+	return nil
+}
+
+func (f *fakeStorage) Get(args *storagerpc.GetArgs, reply *storagerpc.GetReply) error {
+	f.mu.Lock()
+	f.reads++
+	f.active++
+	if f.active > f.busiest {
+		f.busiest = f.active
+	}
+	delay, release := f.delay, f.release
+	value, ok := f.values[args.Key]
+	fail := f.fail > 0
+	if fail {
+		f.fail--
+	}
+	moved := f.moved
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+	if release != nil {
+		<-release
+	}
+	f.mu.Lock()
+	f.active--
+	f.mu.Unlock()
+	if fail {
+		return errors.New("injected failure")
+	}
+	if moved {
+		reply.Status = storagerpc.WrongServer
+		return nil
+	}
+	if !ok {
+		reply.Status = storagerpc.KeyNotFound
+		return nil
+	}
+	reply.Status = storagerpc.OK
+	reply.Value = value
+	if args.WantLease {
+		reply.Lease = storagerpc.Lease{Granted: true, ValidSeconds: storagerpc.LeaseSeconds}
+	}
+	return nil
+}
+
+func (f *fakeStorage) GetList(args *storagerpc.GetArgs, reply *storagerpc.GetListReply) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads++
+	list, ok := f.lists[args.Key]
+	if !ok {
+		reply.Status = storagerpc.KeyNotFound
+		return nil
+	}
+	reply.Status = storagerpc.OK
+	reply.Value = append([]string(nil), list...)
+	if args.WantLease {
+		reply.Lease = storagerpc.Lease{Granted: true, ValidSeconds: storagerpc.LeaseSeconds}
+	}
+	return nil
+}
+
+func (f *fakeStorage) Put(args *storagerpc.PutArgs, reply *storagerpc.PutReply) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[args.Key] = args.Value
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+// readCount returns the number of reads f has received.
+func (f *fakeStorage) readCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads
+}
+
+func TestConcurrentGetsShareOneRPC(t *testing.T) {
+	const n = 10
+	f := startFakeStorage(t)
+	f.values["k"] = "v"
+	f.release = make(chan struct{})
+
+	ls, err := NewLibstore(f.hostPort, "", Never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	type result struct {
+		value string
+		err   error
+	}
+	results := make(chan result, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			value, err := ls.Get("k")
+			results <- result{value, err}
+		}()
+	}
+
+	// The first Get holds its RPC open until release is closed; wait for
+	// the others to join it.
+	deadline := time.Now().Add(5 * time.Second)
+	for ls.Stats().CoalescedReads < n-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d Gets were coalesced", ls.Stats().CoalescedReads, n-1)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(f.release)
+
+	for i := 0; i < n; i++ {
+		if r := <-results; r.err != nil || r.value != "v" {
+			t.Errorf("Get = %q, %v; want %q, nil", r.value, r.err, "v")
+		}
+	}
+	if got := f.readCount(); got != 1 {
+		t.Errorf("storage server received %d Gets, want 1", got)
+	}
+	if s := ls.Stats(); s.ReadRPCs != 1 {
+		t.Errorf("Stats().ReadRPCs = %d, want 1", s.ReadRPCs)
+	}
+}
+
+func TestLeaseReadDoesNotShareLeaselessRPC(t *testing.T) {
+	f := startFakeStorage(t)
+	f.values["k"] = "v"
+	f.release = make(chan struct{})
+	ls, err := NewLibstore(f.hostPort, "", Always, WithCallbackServer(rpc.NewServer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	l := ls.(*libstore)
+
+	errs := make(chan error, 2)
+	for _, wantLease := range []bool{false, true} {
+		go func(wantLease bool) {
+			_, err := l.fetch("k", wantLease, storagerpc.ConsistencyOne)
+			errs <- err
+		}(wantLease)
+		// Let the first read send its RPC before the second starts.
+		deadline := time.Now().Add(5 * time.Second)
+		for f.readCount() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("the first read never reached the storage server")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.readCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the read that wants a lease shared the RPC of one that doesn't")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(f.release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second read was granted a lease, so the value is cached.
+	if value, err := ls.Get("k"); err != nil || value != "v" {
+		t.Fatalf("Get = %q, %v; want %q, nil", value, err, "v")
+	}
+	if n := f.readCount(); n != 2 {
+		t.Errorf("%d reads, want 2 (the Get cached)", n)
+	}
+}
+
+func TestWriteInvalidatesOwnCache(t *testing.T) {
+	f := startFakeStorage(t)
+	f.values["k"] = "old"
+	ls, err := NewLibstore(f.hostPort, "", Always, WithCallbackServer(rpc.NewServer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	for i := 0; i < 2; i++ {
+		if value, err := ls.Get("k"); err != nil || value != "old" {
+			t.Fatalf("Get = %q, %v; want %q, nil", value, err, "old")
+		}
+	}
+	if n := f.readCount(); n != 1 {
+		t.Fatalf("%d reads before the write, want 1 (the second Get cached)", n)
+	}
+
+	// The fake server doesn't revoke the lease, so the new value is only
+	// read if the Put dropped the cached one.
+	if err := ls.Put("k", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := ls.Get("k"); err != nil || value != "new" {
+		t.Errorf("Get after Put = %q, %v; want %q, nil", value, err, "new")
+	}
+	if n := f.readCount(); n != 2 {
+		t.Errorf("%d reads, want 2", n)
+	}
+}
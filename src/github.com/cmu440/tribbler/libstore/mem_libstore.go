@@ -0,0 +1,275 @@
+package libstore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// MemStore is an in-process stand-in for a ring of storage servers, for use
+// in tests. Libstores created from it behave like Libstores talking to real
+// storage servers (returning the same statuses in *StatusErrors), but never
+// touch the network.
+type MemStore struct {
+	mu     sync.Mutex
+	values map[string]string
+	lists  map[string][]string
+	leases map[string]map[*memLibstore]time.Time // Lease holders and expiry times, by key.
+}
+
+// NewMemStore returns an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		values: make(map[string]string),
+		lists:  make(map[string][]string),
+		leases: make(map[string]map[*memLibstore]time.Time),
+	}
+}
+
+// NewMemLibstore returns a Libstore backed by its own private MemStore. It
+// never caches, so every operation sees the store's current contents.
+func NewMemLibstore() Libstore {
+	return NewMemStore().NewLibstore(Never)
+}
+
+// NewLibstore returns a Libstore backed by s. In Normal and Always modes the
+// Libstore simulates leases: reads are cached (in Normal mode, according to
+// the default lease policy or the one given with WithLeasePolicy), and a
+// write through any Libstore of s revokes the leases of all others before
+// returning. Options unrelated to leases are ignored.
+func (s *MemStore) NewLibstore(mode LeaseMode, opts ...Option) Libstore {
+	ls := &libstore{mode: mode, leasePolicy: NewDefaultLeasePolicy()}
+	for _, opt := range opts {
+		opt(ls)
+	}
+	return &memLibstore{
+		store:  s,
+		mode:   mode,
+		policy: ls.leasePolicy,
+		cache:  newLeaseCache(0),
+	}
+}
+
+type memLibstore struct {
+	store  *MemStore
+	mode   LeaseMode
+	policy LeasePolicy
+	cache  *leaseCache
+	reads  uint64 // Reads that reached the store (accessed atomically).
+}
+
+// cached is like libstore.cached.
+func (ls *memLibstore) cached(key string, now time.Time) (*cacheEntry, bool) {
+	entry, ok := ls.cache.lookup(key, now)
+	if ok && ls.mode == Normal {
+		ls.policy.Record(key, now)
+	}
+	return entry, ok
+}
+
+func (ls *memLibstore) wantLease(key string) bool {
+	switch ls.mode {
+	case Always:
+		return true
+	case Normal:
+		return ls.policy.WantLease(key, time.Now())
+	default:
+		return false
+	}
+}
+
+// grant records a lease on key held by ls, and returns the cache entry to
+// store under it. s.mu must be held.
+func (s *MemStore) grant(ls *memLibstore, key, value string, list []string, now time.Time) *cacheEntry {
+	holders, ok := s.leases[key]
+	if !ok {
+		holders = make(map[*memLibstore]time.Time)
+		s.leases[key] = holders
+	}
+	for holder, expires := range holders {
+		if !now.Before(expires) {
+			delete(holders, holder)
+		}
+	}
+	lease := storagerpc.Lease{Granted: true, ValidSeconds: storagerpc.LeaseSeconds}
+	entry := leaseEntry(value, list, lease, now)
+	holders[ls] = entry.expires
+	return entry
+}
+
+// revoke revokes every unexpired lease on key. s.mu must be held.
+func (s *MemStore) revoke(key string, now time.Time) {
+	for holder, expires := range s.leases[key] {
+		if now.Before(expires) {
+			holder.cache.invalidate(key)
+			holder.policy.LeaseRevoked(key, now)
+		}
+	}
+	delete(s.leases, key)
+}
+
+func (ls *memLibstore) Get(key string) (string, error) {
+	now := time.Now()
+	if entry, ok := ls.cached(key, now); ok {
+		return entry.value, nil
+	}
+	wantLease := ls.wantLease(key)
+	atomic.AddUint64(&ls.reads, 1)
+
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return "", &StatusError{Op: "Get", Key: key, Status: storagerpc.KeyNotFound}
+	}
+	if wantLease {
+		gen := ls.cache.beginRead(key)
+		ls.cache.endRead(key, gen, s.grant(ls, key, value, nil, now))
+	}
+	return value, nil
+}
+
+func (ls *memLibstore) GetList(key string) ([]string, error) {
+	now := time.Now()
+	if entry, ok := ls.cached(key, now); ok {
+		return append([]string(nil), entry.list...), nil
+	}
+	wantLease := ls.wantLease(key)
+	atomic.AddUint64(&ls.reads, 1)
+
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, ok := s.lists[key]
+	if !ok {
+		return nil, &StatusError{Op: "GetList", Key: key, Status: storagerpc.KeyNotFound}
+	}
+	list = append([]string(nil), list...)
+	if wantLease {
+		gen := ls.cache.beginRead(key)
+		ls.cache.endRead(key, gen, s.grant(ls, key, "", list, now))
+	}
+	return append([]string(nil), list...), nil
+}
+
+func (ls *memLibstore) Put(key, value string) error {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoke(key, time.Now())
+	s.values[key] = value
+	delete(s.lists, key) // As on a storage server, a key holds a value or a list.
+	return nil
+}
+
+func (ls *memLibstore) Delete(key string) error {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, isValue := s.values[key]
+	_, isList := s.lists[key]
+	if !isValue && !isList {
+		return &StatusError{Op: "Delete", Key: key, Status: storagerpc.KeyNotFound}
+	}
+	s.revoke(key, time.Now())
+	delete(s.values, key)
+	delete(s.lists, key)
+	return nil
+}
+
+func (ls *memLibstore) AppendToList(key, newItem string) error {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.lists[key] {
+		if item == newItem {
+			return &StatusError{Op: "AppendToList", Key: key, Status: storagerpc.ItemExists}
+		}
+	}
+	s.revoke(key, time.Now())
+	s.lists[key] = append(s.lists[key], newItem)
+	delete(s.values, key)
+	return nil
+}
+
+func (ls *memLibstore) RemoveFromList(key, removeItem string) error {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.lists[key]
+	for i, item := range list {
+		if item == removeItem {
+			s.revoke(key, time.Now())
+			s.lists[key] = append(list[:i:i], list[i+1:]...)
+			return nil
+		}
+	}
+	return &StatusError{Op: "RemoveFromList", Key: key, Status: storagerpc.ItemNotFound}
+}
+
+func (ls *memLibstore) GetAsync(key string) *GetFuture {
+	value, err := ls.Get(key)
+	return &GetFuture{doneFuture(&cacheEntry{value: value}, err)}
+}
+
+func (ls *memLibstore) GetListAsync(key string) *GetListFuture {
+	list, err := ls.GetList(key)
+	return &GetListFuture{doneFuture(&cacheEntry{list: list}, err)}
+}
+
+func (ls *memLibstore) Prefetch(keys ...string) {
+	if ls.mode == Never {
+		return
+	}
+	for _, key := range keys {
+		ls.fetch(key, false)
+	}
+}
+
+func (ls *memLibstore) PrefetchList(keys ...string) {
+	if ls.mode == Never {
+		return
+	}
+	for _, key := range keys {
+		ls.fetch(key, true)
+	}
+}
+
+// fetch caches key under a lease, regardless of the lease policy.
+func (ls *memLibstore) fetch(key string, isList bool) {
+	now := time.Now()
+	if ls.cache.cached(key, now) {
+		return
+	}
+	atomic.AddUint64(&ls.reads, 1)
+
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entry *cacheEntry
+	if isList {
+		if list, ok := s.lists[key]; ok {
+			entry = s.grant(ls, key, "", append([]string(nil), list...), now)
+		}
+	} else if value, ok := s.values[key]; ok {
+		entry = s.grant(ls, key, value, nil, now)
+	}
+	if entry != nil {
+		gen := ls.cache.beginRead(key)
+		ls.cache.endRead(key, gen, entry)
+	}
+}
+
+func (ls *memLibstore) Stats() Stats {
+	hits, misses := ls.cache.stats()
+	return Stats{ReadRPCs: atomic.LoadUint64(&ls.reads), CacheHits: hits, CacheMisses: misses}
+}
+
+// AtConsistency returns ls itself: a MemStore holds a single copy of each key,
+// so every operation is as consistent as it can be.
+func (ls *memLibstore) AtConsistency(c storagerpc.Consistency) Libstore {
+	return ls
+}
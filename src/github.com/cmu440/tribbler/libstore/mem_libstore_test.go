@@ -0,0 +1,124 @@
+package libstore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// statusOf returns the status carried by err: OK for nil, or 0 if err isn't
+// a *StatusError.
+func statusOf(err error) storagerpc.Status {
+	if err == nil {
+		return storagerpc.OK
+	}
+	if serr, ok := err.(*StatusError); ok {
+		return serr.Status
+	}
+	return 0
+}
+
+func TestMemLibstoreStatuses(t *testing.T) {
+	type step struct {
+		op         string
+		key, arg   string
+		wantStatus storagerpc.Status
+		want       interface{} // For Get, a string; for GetList, a []string.
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"values", []step{
+			{op: "Get", key: "k", wantStatus: storagerpc.KeyNotFound},
+			{op: "Put", key: "k", arg: "v1", wantStatus: storagerpc.OK},
+			{op: "Get", key: "k", wantStatus: storagerpc.OK, want: "v1"},
+			{op: "Put", key: "k", arg: "v2", wantStatus: storagerpc.OK},
+			{op: "Get", key: "k", wantStatus: storagerpc.OK, want: "v2"},
+			{op: "Delete", key: "k", wantStatus: storagerpc.OK},
+			{op: "Get", key: "k", wantStatus: storagerpc.KeyNotFound},
+			{op: "Delete", key: "k", wantStatus: storagerpc.KeyNotFound},
+		}},
+		{"lists", []step{
+			{op: "GetList", key: "l", wantStatus: storagerpc.KeyNotFound},
+			{op: "RemoveFromList", key: "l", arg: "a", wantStatus: storagerpc.ItemNotFound},
+			{op: "AppendToList", key: "l", arg: "a", wantStatus: storagerpc.OK},
+			{op: "AppendToList", key: "l", arg: "b", wantStatus: storagerpc.OK},
+			{op: "AppendToList", key: "l", arg: "a", wantStatus: storagerpc.ItemExists},
+			{op: "GetList", key: "l", wantStatus: storagerpc.OK, want: []string{"a", "b"}},
+			{op: "RemoveFromList", key: "l", arg: "c", wantStatus: storagerpc.ItemNotFound},
+			{op: "RemoveFromList", key: "l", arg: "a", wantStatus: storagerpc.OK},
+			{op: "GetList", key: "l", wantStatus: storagerpc.OK, want: []string{"b"}},
+			{op: "RemoveFromList", key: "l", arg: "b", wantStatus: storagerpc.OK},
+			{op: "GetList", key: "l", wantStatus: storagerpc.OK, want: []string{}},
+			{op: "Delete", key: "l", wantStatus: storagerpc.OK},
+			{op: "GetList", key: "l", wantStatus: storagerpc.KeyNotFound},
+		}},
+		{"a key holds a value or a list", []step{
+			{op: "AppendToList", key: "k", arg: "a", wantStatus: storagerpc.OK},
+			{op: "Get", key: "k", wantStatus: storagerpc.KeyNotFound},
+			{op: "Put", key: "k", arg: "v", wantStatus: storagerpc.OK},
+			{op: "GetList", key: "k", wantStatus: storagerpc.KeyNotFound},
+			{op: "RemoveFromList", key: "k", arg: "v", wantStatus: storagerpc.ItemNotFound},
+			{op: "AppendToList", key: "k", arg: "b", wantStatus: storagerpc.OK},
+			{op: "GetList", key: "k", wantStatus: storagerpc.OK, want: []string{"b"}},
+			{op: "Get", key: "k", wantStatus: storagerpc.KeyNotFound},
+		}},
+	}
+	for _, tt := range tests {
+		ls := NewMemLibstore()
+		for i, s := range tt.steps {
+			var got interface{}
+			var err error
+			switch s.op {
+			case "Get":
+				got, err = ls.Get(s.key)
+			case "GetList":
+				var list []string
+				list, err = ls.GetList(s.key)
+				got = append([]string{}, list...)
+			case "Put":
+				err = ls.Put(s.key, s.arg)
+			case "Delete":
+				err = ls.Delete(s.key)
+			case "AppendToList":
+				err = ls.AppendToList(s.key, s.arg)
+			case "RemoveFromList":
+				err = ls.RemoveFromList(s.key, s.arg)
+			}
+			if status := statusOf(err); status != s.wantStatus {
+				t.Errorf("%s: step %d: %s(%q, %q) = %v, want status %v", tt.name, i, s.op, s.key, s.arg, err, s.wantStatus)
+				break
+			}
+			if s.want != nil && !reflect.DeepEqual(got, s.want) {
+				t.Errorf("%s: step %d: %s(%q) = %q, want %q", tt.name, i, s.op, s.key, got, s.want)
+			}
+			if serr, ok := err.(*StatusError); ok && (serr.Op != s.op || serr.Key != s.key) {
+				t.Errorf("%s: step %d: error names %s(%q), want %s(%q)", tt.name, i, serr.Op, serr.Key, s.op, s.key)
+			}
+		}
+	}
+}
+
+func TestMemLibstoreRevokesLeases(t *testing.T) {
+	s := NewMemStore()
+	reader, writer := s.NewLibstore(Always), s.NewLibstore(Never)
+	if err := writer.Put("k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if value, err := reader.Get("k"); err != nil || value != "v1" {
+			t.Fatalf("Get = %q, %v; want %q", value, err, "v1")
+		}
+	}
+	if hits := reader.Stats().CacheHits; hits != 1 {
+		t.Errorf("%d cache hits, want the second Get answered under the lease", hits)
+	}
+	if err := writer.Put("k", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := reader.Get("k"); err != nil || value != "v2" {
+		t.Errorf("Get after a write by another Libstore = %q, %v; want %q", value, err, "v2")
+	}
+}
@@ -0,0 +1,24 @@
+package libstore
+
+import "github.com/cmu440/tribbler/metrics"
+
+// WriteMetrics writes the counters in s, taken from a Libstore's Stats, as
+// metrics for the server that owns the Libstore to serve at metrics.Path.
+func WriteMetrics(w *metrics.Writer, s Stats) {
+	w.Counter("libstore_read_rpcs_total", "Get/GetList RPCs sent to the storage servers.", float64(s.ReadRPCs))
+	w.Counter("libstore_coalesced_reads_total", "Get/GetList calls that shared another call's RPC.", float64(s.CoalescedReads))
+	w.Counter("libstore_stale_reads_total", "Get/GetList calls answered with an expired value.", float64(s.StaleReads))
+	w.Counter("libstore_cache_lookups_total", "Get/GetList calls that consulted the lease cache, by result.",
+		float64(s.CacheHits), "result", "hit")
+	w.Counter("libstore_cache_lookups_total", "Get/GetList calls that consulted the lease cache, by result.",
+		float64(s.CacheMisses), "result", "miss")
+	ratio := 0.0
+	if lookups := s.CacheHits + s.CacheMisses; lookups > 0 {
+		ratio = float64(s.CacheHits) / float64(lookups)
+	}
+	w.Gauge("libstore_cache_hit_ratio", "Fraction of lease cache lookups that were hits.", ratio)
+	for _, n := range s.Nodes {
+		w.Gauge("libstore_breaker_state", "State of the circuit breaker guarding each storage node: 0 closed, 1 open, 2 half-open.",
+			float64(n.State), "node", n.Node.HostPort)
+	}
+}
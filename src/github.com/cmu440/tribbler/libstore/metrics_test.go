@@ -0,0 +1,36 @@
+package libstore
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/metrics"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestWriteMetricsBreakerStates(t *testing.T) {
+	down := storagerpc.Node{HostPort: "localhost:1", NodeID: 1}
+	up := storagerpc.Node{HostPort: "localhost:2", NodeID: 2}
+	now := time.Now()
+	bs := newBreakerSet(1, time.Minute)
+	if err := bs.allow(down, now); err != nil {
+		t.Fatal(err)
+	}
+	bs.report(down, errors.New("connection refused"), now)
+
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	WriteMetrics(w, Stats{Nodes: bs.stats([]storagerpc.Node{down, up})})
+	w.Flush()
+	for _, want := range []string{
+		`libstore_breaker_state{node="localhost:1"} 1`,
+		`libstore_breaker_state{node="localhost:2"} 0`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("metrics don't contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package libstore
+
+import "net/rpc"
+
+// Option configures optional Libstore behavior. Options are applied in order
+// by NewLibstore.
+type Option func(*libstore)
+
+// WithLeasePolicy sets the policy used to decide whether to request leases
+// when the Libstore is running in Normal mode. If no policy is given, the
+// Libstore uses NewDefaultLeasePolicy. The policy is ignored in the Never and
+// Always modes.
+func WithLeasePolicy(policy LeasePolicy) Option {
+	return func(ls *libstore) {
+		ls.leasePolicy = policy
+	}
+}
+
+// WithCallbackServer makes the Libstore register its "LeaseCallbacks" service
+// with srv, rather than with rpc.DefaultServer, so that a process can serve
+// several Libstores or take their services down with the server.
+func WithCallbackServer(srv *rpc.Server) Option {
+	return func(ls *libstore) {
+		ls.callbacks = srv
+	}
+}
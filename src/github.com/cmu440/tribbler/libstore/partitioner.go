@@ -0,0 +1,234 @@
+package libstore
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// Partitioner determines which storage node is responsible for a key. Every
+// Libstore and StorageServer in a cluster must use the same Partitioner;
+// storage servers refuse to join a ring whose master uses a different one,
+// and a Libstore adopts whichever Partitioner the ring reports.
+type Partitioner interface {
+
+	// Name identifies the partitioning scheme across processes.
+	Name() string
+
+	// Hash hashes key onto the ring.
+	Hash(key string) uint32
+
+	// Owner returns the node among nodes that is responsible for key.
+	// nodes must not be empty and may be given in any order.
+	Owner(key string, nodes []storagerpc.Node) storagerpc.Node
+}
+
+// A RangePartitioner is a Partitioner under which each node owns a single,
+// contiguous range of hashes. Moving ranges between nodes, repairing
+// replicas range by range and replicating ranges with Raft all need one.
+type RangePartitioner interface {
+	Partitioner
+
+	// Range returns the hashes owned by the node with the given ID on a
+	// ring made up of nodes.
+	Range(nodeID uint32, nodes []storagerpc.Node) storagerpc.HashRange
+}
+
+// Names of the built-in partitioners.
+const (
+	PrefixPartitionerName  = "fnv32-prefix"
+	FullKeyPartitionerName = "fnv32-key"
+	JumpPartitionerName    = "jump-prefix"
+
+	// SplitPartitionerPrefix begins the name of every partitioner created
+	// by NewSplitPartitioner. The rest of the name lists its hot users.
+	SplitPartitionerPrefix = "fnv32-split:"
+)
+
+var (
+	// PrefixPartitioner is the default partitioner. It hashes the part of
+	// the key before the first ':' (i.e. the user ID) with FNV-32, and maps
+	// the hash to the node with the smallest NodeID greater than or equal
+	// to it, wrapping around the ring. All of a user's keys are co-located.
+	PrefixPartitioner Partitioner = ringPartitioner{name: PrefixPartitionerName, hash: prefixHash}
+
+	// FullKeyPartitioner is like PrefixPartitioner, but hashes the whole
+	// key. This balances load better, at the cost of spreading a user's
+	// keys across nodes.
+	FullKeyPartitioner Partitioner = ringPartitioner{name: FullKeyPartitionerName, hash: fnvHash}
+
+	// JumpPartitioner hashes key prefixes like PrefixPartitioner, but maps
+	// hashes to nodes using jump consistent hashing (Lamping and Veach,
+	// 2014), which spreads keys evenly regardless of the nodes' IDs. Nodes
+	// are ordered by NodeID. A node's keys don't form one range of hashes,
+	// so JumpPartitioner isn't a RangePartitioner.
+	JumpPartitioner Partitioner = jumpPartitioner{}
+)
+
+// NewSplitPartitioner returns a partitioner that behaves like
+// PrefixPartitioner, except that the posts of the given (hot) users are
+// hashed by their full key, spreading them across the ring. The users' other
+// keys (their usrid, sublist and triblist) stay co-located on a single node.
+//
+// The set of hot users is part of the partitioner's name, so all servers in a
+// ring must be configured with the same set.
+func NewSplitPartitioner(hotUserIDs []string) Partitioner {
+	hot := make(map[string]bool)
+	for _, userID := range hotUserIDs {
+		hot[userID] = true
+	}
+	names := make([]string, 0, len(hot))
+	for userID := range hot {
+		names = append(names, userID)
+	}
+	sort.Strings(names)
+
+	hash := func(key string) uint32 {
+		parts := strings.SplitN(key, ":", 2)
+		if len(parts) == 2 && hot[parts[0]] && strings.HasPrefix(parts[1], "post_") {
+			return fnvHash(key)
+		}
+		return fnvHash(parts[0])
+	}
+	return ringPartitioner{name: SplitPartitionerPrefix + strings.Join(names, ","), hash: hash}
+}
+
+// LookupPartitioner returns the built-in partitioner with the given name. The
+// empty name refers to PrefixPartitioner, so that nodes which don't report a
+// partitioner are assumed to use the default.
+func LookupPartitioner(name string) (Partitioner, bool) {
+	if strings.HasPrefix(name, SplitPartitionerPrefix) {
+		hot := strings.TrimPrefix(name, SplitPartitionerPrefix)
+		if hot == "" {
+			return NewSplitPartitioner(nil), true
+		}
+		return NewSplitPartitioner(strings.Split(hot, ",")), true
+	}
+	switch name {
+	case "", PrefixPartitionerName:
+		return PrefixPartitioner, true
+	case FullKeyPartitionerName:
+		return FullKeyPartitioner, true
+	case JumpPartitionerName:
+		return JumpPartitioner, true
+	default:
+		return nil, false
+	}
+}
+
+// Replicas returns the n nodes that store key: its owner according to p,
+// followed by the nodes after the owner in order of NodeID, wrapping around.
+// Fewer than n nodes are returned if there are fewer than n nodes in all.
+func Replicas(p Partitioner, key string, nodes []storagerpc.Node, n int) []storagerpc.Node {
+	sorted := append([]storagerpc.Node(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].NodeID < sorted[j].NodeID
+	})
+	owner := p.Owner(key, nodes)
+	start := sort.Search(len(sorted), func(i int) bool {
+		return sorted[i].NodeID >= owner.NodeID
+	})
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+	replicas := make([]storagerpc.Node, n)
+	for i := range replicas {
+		replicas[i] = sorted[(start+i)%len(sorted)]
+	}
+	return replicas
+}
+
+func fnvHash(s string) uint32 {
+	hasher := fnv.New32()
+	hasher.Write([]byte(s))
+	return hasher.Sum32()
+}
+
+func prefixHash(key string) uint32 {
+	return fnvHash(strings.Split(key, ":")[0])
+}
+
+// ringPartitioner places nodes on a ring at their NodeIDs. Each node is
+// responsible for the hashes between its predecessor's ID (exclusive) and
+// its own ID (inclusive).
+type ringPartitioner struct {
+	name string
+	hash func(string) uint32
+}
+
+func (p ringPartitioner) Name() string {
+	return p.name
+}
+
+func (p ringPartitioner) Hash(key string) uint32 {
+	return p.hash(key)
+}
+
+func (p ringPartitioner) Owner(key string, nodes []storagerpc.Node) storagerpc.Node {
+	h := p.hash(key)
+	owner, first := -1, 0
+	for i, node := range nodes {
+		if node.NodeID < nodes[first].NodeID {
+			first = i
+		}
+		if node.NodeID >= h && (owner < 0 || node.NodeID < nodes[owner].NodeID) {
+			owner = i
+		}
+	}
+	if owner < 0 {
+		// Past the largest ID: wrap around to the smallest.
+		return nodes[first]
+	}
+	return nodes[owner]
+}
+
+// Range returns the hashes from the predecessor of the node with the given
+// ID (exclusive) to its ID (inclusive). A lone node owns the whole ring.
+func (p ringPartitioner) Range(nodeID uint32, nodes []storagerpc.Node) storagerpc.HashRange {
+	pred, found := nodeID, false
+	largest := nodeID
+	for _, node := range nodes {
+		if node.NodeID < nodeID && (!found || node.NodeID > pred) {
+			pred, found = node.NodeID, true
+		}
+		if node.NodeID > largest {
+			largest = node.NodeID
+		}
+	}
+	if !found {
+		// The smallest ID wraps around from the largest.
+		pred = largest
+	}
+	return storagerpc.HashRange{Start: pred, End: nodeID}
+}
+
+type jumpPartitioner struct{}
+
+func (jumpPartitioner) Name() string {
+	return JumpPartitionerName
+}
+
+func (jumpPartitioner) Hash(key string) uint32 {
+	return prefixHash(key)
+}
+
+func (jumpPartitioner) Owner(key string, nodes []storagerpc.Node) storagerpc.Node {
+	sorted := append([]storagerpc.Node(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].NodeID < sorted[j].NodeID
+	})
+	return sorted[jumpHash(uint64(prefixHash(key)), len(sorted))]
+}
+
+// jumpHash maps key to a bucket in [0, buckets).
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,170 @@
+package libstore
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// numberPartitioner hashes a key that is a decimal number to that number, so
+// that tests can place keys on the ring exactly.
+var numberPartitioner = ringPartitioner{name: "number", hash: func(key string) uint32 {
+	n, _ := strconv.ParseUint(key, 10, 32)
+	return uint32(n)
+}}
+
+var testRing = []storagerpc.Node{
+	{HostPort: "b", NodeID: 200},
+	{HostPort: "c", NodeID: 300},
+	{HostPort: "a", NodeID: 100},
+}
+
+func TestRingOwner(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"0", "a"},
+		{"50", "a"},
+		{"100", "a"}, // A node owns its own ID.
+		{"101", "b"},
+		{"200", "b"},
+		{"250", "c"},
+		{"300", "c"},
+		{"301", "a"}, // Past the largest ID, wrap around.
+		{"4294967295", "a"},
+	}
+	for _, tt := range tests {
+		if got := numberPartitioner.Owner(tt.key, testRing); got.HostPort != tt.want {
+			t.Errorf("Owner(%s) = %s, want %s", tt.key, got.HostPort, tt.want)
+		}
+	}
+}
+
+func TestRingRange(t *testing.T) {
+	tests := []struct {
+		nodeID uint32
+		nodes  []storagerpc.Node
+		want   storagerpc.HashRange
+	}{
+		{100, testRing, storagerpc.HashRange{Start: 300, End: 100}}, // Wraps around.
+		{200, testRing, storagerpc.HashRange{Start: 100, End: 200}},
+		{300, testRing, storagerpc.HashRange{Start: 200, End: 300}},
+		{100, testRing[2:], storagerpc.HashRange{Start: 100, End: 100}}, // The whole ring.
+	}
+	for _, tt := range tests {
+		r := numberPartitioner.Range(tt.nodeID, tt.nodes)
+		if r != tt.want {
+			t.Errorf("Range(%d) = %+v, want %+v", tt.nodeID, r, tt.want)
+		}
+		// Every hash in the range is owned by the node.
+		for _, h := range []uint32{r.Start + 1, r.End} {
+			if owner := numberPartitioner.Owner(fmt.Sprint(h), tt.nodes); owner.NodeID != tt.nodeID {
+				t.Errorf("Range(%d) holds %d, which is owned by %d", tt.nodeID, h, owner.NodeID)
+			}
+		}
+	}
+	if _, ok := JumpPartitioner.(RangePartitioner); ok {
+		t.Error("JumpPartitioner is a RangePartitioner")
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	tests := []struct {
+		key  string
+		n    int
+		want []string
+	}{
+		{"50", 1, []string{"a"}},
+		{"150", 2, []string{"b", "c"}},
+		{"250", 2, []string{"c", "a"}},
+		{"301", 3, []string{"a", "b", "c"}},
+		{"150", 5, []string{"b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, node := range Replicas(numberPartitioner, tt.key, testRing, tt.n) {
+			got = append(got, node.HostPort)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Replicas(%s, %d) = %v, want %v", tt.key, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPartitionerHashes(t *testing.T) {
+	split := NewSplitPartitioner([]string{"celebrity"})
+	tests := []struct {
+		p        Partitioner
+		a, b     string
+		wantSame bool
+	}{
+		{PrefixPartitioner, "alice:usrid", "alice:post_1_0", true},
+		{PrefixPartitioner, "alice:usrid", "bob:usrid", false},
+		{FullKeyPartitioner, "alice:usrid", "alice:sublist", false},
+		{JumpPartitioner, "alice:usrid", "alice:triblist", true},
+		{split, "alice:post_1_0", "alice:post_2_0", true},
+		{split, "celebrity:post_1_0", "celebrity:post_2_0", false},
+		{split, "celebrity:usrid", "celebrity:triblist", true},
+	}
+	for _, tt := range tests {
+		if same := tt.p.Hash(tt.a) == tt.p.Hash(tt.b); same != tt.wantSame {
+			t.Errorf("%s: Hash(%q) == Hash(%q) is %v, want %v", tt.p.Name(), tt.a, tt.b, same, tt.wantSame)
+		}
+	}
+}
+
+func TestLookupPartitioner(t *testing.T) {
+	split := NewSplitPartitioner([]string{"b", "a", "b"})
+	if want := SplitPartitionerPrefix + "a,b"; split.Name() != want {
+		t.Errorf("split partitioner name = %q, want %q", split.Name(), want)
+	}
+	for _, p := range []Partitioner{PrefixPartitioner, FullKeyPartitioner, JumpPartitioner, split, NewSplitPartitioner(nil)} {
+		got, ok := LookupPartitioner(p.Name())
+		if !ok || got.Name() != p.Name() {
+			t.Errorf("LookupPartitioner(%q) = %v, %v; want %q", p.Name(), got, ok, p.Name())
+		}
+	}
+	if got, ok := LookupPartitioner(""); !ok || got.Name() != PrefixPartitionerName {
+		t.Errorf("LookupPartitioner(\"\") = %v, %v; want the prefix partitioner", got, ok)
+	}
+	if _, ok := LookupPartitioner("md5"); ok {
+		t.Error("LookupPartitioner found an unknown partitioner")
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	const keys = 10000
+	for buckets := 1; buckets <= 10; buckets++ {
+		counts := make([]int, buckets)
+		for key := uint64(0); key < keys; key++ {
+			b := jumpHash(key*0x9e3779b97f4a7c15, buckets)
+			if b < 0 || b >= buckets {
+				t.Fatalf("jumpHash(%d, %d) = %d, out of range", key, buckets, b)
+			}
+			counts[b]++
+			// Adding a bucket moves keys only to the new bucket.
+			if next := jumpHash(key*0x9e3779b97f4a7c15, buckets+1); next != b && next != buckets {
+				t.Fatalf("key %d moved from bucket %d to %d when bucket %d was added", key, b, next, buckets)
+			}
+		}
+		for b, n := range counts {
+			if want := keys / buckets; n < want*8/10 || n > want*12/10 {
+				t.Errorf("%d buckets: bucket %d got %d keys, want about %d", buckets, b, n, want)
+			}
+		}
+	}
+}
+
+func TestJumpOwnerIgnoresNodeOrder(t *testing.T) {
+	reversed := []storagerpc.Node{testRing[2], testRing[1], testRing[0]}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user%d:usrid", i)
+		if a, b := JumpPartitioner.Owner(key, testRing), JumpPartitioner.Owner(key, reversed); a != b {
+			t.Fatalf("Owner(%q) depends on node order: %v vs %v", key, a, b)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package libstore
+
+import "time"
+
+// maxPrefetches is the maximum number of prefetch RPCs a Libstore will have
+// outstanding at any time.
+const maxPrefetches = 8
+
+func (ls *libstore) Prefetch(keys ...string) {
+	ls.prefetch(keys, func(key string) {
+		ls.fetch(key, true, ls.readLevel)
+	})
+}
+
+func (ls *libstore) PrefetchList(keys ...string) {
+	ls.prefetch(keys, func(key string) {
+		ls.fetchList(key, true, ls.readLevel)
+	})
+}
+
+// prefetch calls fetch in the background for each of keys that is not
+// already cached. Prefetching is pointless in Never mode, since no leases
+// will be requested and so nothing would be cached.
+func (ls *libstore) prefetch(keys []string, fetch func(key string)) {
+	if ls.mode == Never || len(keys) == 0 {
+		return
+	}
+	keys = append([]string(nil), keys...)
+	go func() {
+		for _, key := range keys {
+			if ls.cache.cached(key, time.Now()) {
+				continue
+			}
+			ls.prefetches <- struct{}{}
+			go func(key string) {
+				defer func() { <-ls.prefetches }()
+				fetch(key)
+			}(key)
+		}
+	}()
+}
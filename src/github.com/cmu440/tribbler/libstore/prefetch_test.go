@@ -0,0 +1,51 @@
+package libstore
+
+import (
+	"fmt"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestPrefetchIsBounded(t *testing.T) {
+	const n = 3 * maxPrefetches
+	f := startFakeStorage(t)
+	f.delay = 10 * time.Millisecond
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		f.values[keys[i]] = "v"
+	}
+
+	ls, err := NewLibstore(f.hostPort, "localhost:0", Always, WithCallbackServer(rpc.NewServer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	ls.Prefetch(keys...)
+	deadline := time.Now().Add(5 * time.Second)
+	for f.readCount() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d keys were prefetched", f.readCount(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	f.mu.Lock()
+	busiest := f.busiest
+	f.mu.Unlock()
+	if busiest > maxPrefetches {
+		t.Errorf("%d prefetches were in progress at once, want at most %d", busiest, maxPrefetches)
+	}
+
+	// Every key is now leased, so reading them sends no more RPCs.
+	time.Sleep(50 * time.Millisecond)
+	for _, key := range keys {
+		if _, err := ls.Get(key); err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+	}
+	if got := f.readCount(); got != n {
+		t.Errorf("storage server received %d Gets, want %d", got, n)
+	}
+}
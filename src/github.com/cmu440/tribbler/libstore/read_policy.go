@@ -0,0 +1,159 @@
+package libstore
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// ReadPolicy determines how a Libstore retries and hedges an idempotent read
+// (Get or GetList). The zero value sends a single RPC and gives up on the
+// first error.
+//
+// Only RPC-level failures are retried; a reply with a status other than OK
+// (e.g. KeyNotFound) is a valid answer and is returned as is.
+type ReadPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Values less than 1 are treated as 1.
+	MaxAttempts int
+
+	// BaseBackoff is the upper bound on the time to wait before the first
+	// retry. The bound doubles after each failed attempt, up to MaxBackoff,
+	// and the actual wait is chosen uniformly at random below it.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// HedgeDelay, if positive, is how long to wait for the key's primary
+	// node before sending the same read to a replica. The first reply wins.
+	// The hedged read never requests a lease, and a replica that doesn't
+	// store the key (e.g. because the ring isn't replicated) can't win. It
+	// has no effect on reads above ConsistencyOne, or if the ring has only
+	// one node.
+	HedgeDelay time.Duration
+}
+
+// WithGetPolicy sets the retry and hedging policy used by Get.
+func WithGetPolicy(policy ReadPolicy) Option {
+	return func(ls *libstore) {
+		ls.getPolicy = policy
+	}
+}
+
+// WithGetListPolicy sets the retry and hedging policy used by GetList.
+func WithGetListPolicy(policy ReadPolicy) Option {
+	return func(ls *libstore) {
+		ls.getListPolicy = policy
+	}
+}
+
+// readNodes returns the nodes that a read of key at consistency c may be sent
+// to under policy: the key's owner, followed by the replica to hedge against,
+// if any.
+func (ls *libstore) readNodes(key string, c storagerpc.Consistency, policy ReadPolicy) []storagerpc.Node {
+	owner := ls.owner(key)
+	if policy.HedgeDelay <= 0 || c != storagerpc.ConsistencyOne {
+		return []storagerpc.Node{owner}
+	}
+	nodes := Replicas(ls.partitioner, key, ls.ring(), 2)
+	if nodes[0] != owner {
+		// The key's range has been moved off its ring owner, whose
+		// replica no longer holds it.
+		return []storagerpc.Node{owner}
+	}
+	return nodes
+}
+
+// readReplica sends a hedged read of args.Key to node, a replica of the key's
+// owner, without requesting a lease. A reply other than OK or KeyNotFound
+// means the node couldn't serve the read, and is returned as an error so that
+// the owner's reply is used instead.
+func (ls *libstore) readReplica(node storagerpc.Node, args *storagerpc.GetArgs, isList bool) (*readReply, error) {
+	replicaArgs := *args
+	replicaArgs.WantLease = false
+	reply, err := ls.readFrom(node, &replicaArgs, isList)
+	if err != nil {
+		return nil, err
+	}
+	if reply.status != storagerpc.OK && reply.status != storagerpc.KeyNotFound {
+		return nil, fmt.Errorf("libstore: hedged read of %q from %s: %v", args.Key, node.HostPort, reply.status)
+	}
+	return reply, nil
+}
+
+// readResult is the outcome of a single read RPC.
+type readResult struct {
+	value interface{}
+	err   error
+}
+
+// do performs read against nodes, which must hold the key's primary node
+// followed by any replicas, retrying and hedging as the policy allows. It
+// returns the first successful result, or the last error.
+func (p ReadPolicy) do(nodes []storagerpc.Node, read func(storagerpc.Node) (interface{}, error)) (interface{}, error) {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := p.BaseBackoff
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 && backoff > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(backoff))))
+			backoff *= 2
+			if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		}
+		var value interface{}
+		if value, err = p.hedge(nodes, read); err == nil {
+			return value, nil
+		}
+	}
+	return nil, err
+}
+
+// hedge sends read to the primary node and, if it hasn't answered within
+// HedgeDelay, to the first replica as well.
+func (p ReadPolicy) hedge(nodes []storagerpc.Node, read func(storagerpc.Node) (interface{}, error)) (interface{}, error) {
+	if p.HedgeDelay <= 0 || len(nodes) < 2 {
+		return read(nodes[0])
+	}
+
+	// Buffered so that the losing RPC doesn't leak its goroutine.
+	results := make(chan readResult, 2)
+	send := func(node storagerpc.Node) {
+		value, err := read(node)
+		results <- readResult{value, err}
+	}
+	go send(nodes[0])
+
+	timer := time.NewTimer(p.HedgeDelay)
+	defer timer.Stop()
+	pending := 1
+	hedged := false
+	for {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				pending++
+				go send(nodes[1])
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.value, nil
+			}
+			if !hedged {
+				// The primary failed outright; don't wait for the timer.
+				hedged = true
+				pending++
+				go send(nodes[1])
+			} else if pending == 0 {
+				return nil, res.err
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package libstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// startFakeRing starts two fakeStorages that form a ring, and returns the
+// owner of key followed by its replica.
+func startFakeRing(t *testing.T, key string) (owner, replica *fakeStorage) {
+	a, b := startFakeStorage(t), startFakeStorage(t)
+	ring := []storagerpc.Node{{HostPort: a.hostPort, NodeID: 1}, {HostPort: b.hostPort, NodeID: 1 << 31}}
+	a.ring, b.ring = ring, ring
+	if PrefixPartitioner.Owner(key, ring).HostPort == a.hostPort {
+		return a, b
+	}
+	return b, a
+}
+
+func TestHedgedGetAvoidsSlowOwner(t *testing.T) {
+	owner, replica := startFakeRing(t, "k")
+	owner.values["k"] = "owner"
+	replica.values["k"] = "replica"
+	owner.delay = 2 * time.Second
+
+	ls, err := NewLibstore(owner.hostPort, "", Never, WithGetPolicy(ReadPolicy{HedgeDelay: 20 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	start := time.Now()
+	value, err := ls.Get("k")
+	if err != nil || value != "replica" {
+		t.Fatalf("Get = %q, %v; want %q, nil", value, err, "replica")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged Get took %v; the replica should have answered", elapsed)
+	}
+}
+
+func TestGetRetries(t *testing.T) {
+	tests := []struct {
+		attempts int
+		fail     int
+		wantErr  bool
+		wantRPCs int
+	}{
+		{attempts: 0, fail: 0, wantRPCs: 1},
+		{attempts: 0, fail: 1, wantErr: true, wantRPCs: 1},
+		{attempts: 3, fail: 2, wantRPCs: 3},
+		{attempts: 3, fail: 3, wantErr: true, wantRPCs: 3},
+	}
+	for _, tt := range tests {
+		f := startFakeStorage(t)
+		f.values["k"] = "v"
+		f.fail = tt.fail
+		policy := ReadPolicy{MaxAttempts: tt.attempts, BaseBackoff: time.Millisecond}
+		ls, err := NewLibstore(f.hostPort, "", Never, WithGetPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := ls.Get("k")
+		if tt.wantErr && err == nil {
+			t.Errorf("attempts %d, %d failures: Get = %q, want an error", tt.attempts, tt.fail, value)
+		} else if !tt.wantErr && (err != nil || value != "v") {
+			t.Errorf("attempts %d, %d failures: Get = %q, %v; want %q, nil", tt.attempts, tt.fail, value, err, "v")
+		}
+		if got := f.readCount(); got != tt.wantRPCs {
+			t.Errorf("attempts %d, %d failures: %d RPCs sent, want %d", tt.attempts, tt.fail, got, tt.wantRPCs)
+		}
+		ls.Close()
+	}
+}
+
+func TestGetFollowsMovedRange(t *testing.T) {
+	owner, target := startFakeRing(t, "k")
+	owner.values["k"] = "old"
+	target.values["k"] = "moved"
+
+	ls, err := NewLibstore(owner.hostPort, "", Never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	// Move the whole ring to target, as TransferRange would.
+	owner.mu.Lock()
+	owner.moved = true
+	owner.assigned = []storagerpc.RangeAssignment{{Owner: storagerpc.Node{HostPort: target.hostPort}}}
+	owner.mu.Unlock()
+
+	for _, get := range []func() (string, error){
+		func() (string, error) { return ls.Get("k") },
+		func() (string, error) { return ls.GetAsync("k").Wait() },
+	} {
+		if value, err := get(); err != nil || value != "moved" {
+			t.Fatalf("Get = %q, %v; want %q, nil", value, err, "moved")
+		}
+	}
+	if got := owner.readCount(); got != 1 {
+		t.Errorf("old owner got %d reads, want 1", got)
+	}
+	if got := target.readCount(); got != 2 {
+		t.Errorf("new owner got %d reads, want 2", got)
+	}
+}
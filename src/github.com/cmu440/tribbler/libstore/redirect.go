@@ -0,0 +1,49 @@
+package libstore
+
+import "github.com/cmu440/tribbler/rpc/storagerpc"
+
+// maxRedirects is the number of NotLeader redirects a Libstore follows for a
+// single request before giving up and returning the NotLeader status.
+const maxRedirects = 3
+
+// leaderOf returns the node last reported to lead the range owned by owner,
+// or owner itself if no leader has been reported.
+func (ls *libstore) leaderOf(owner storagerpc.Node) storagerpc.Node {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if leader, ok := ls.leaders[owner.HostPort]; ok {
+		return leader
+	}
+	return owner
+}
+
+// redirect records that the range owned by owner is led by the node at
+// leader (as reported with status NotLeader), and returns that node.
+func (ls *libstore) redirect(owner storagerpc.Node, leader string) storagerpc.Node {
+	node := storagerpc.Node{HostPort: leader}
+	for _, server := range ls.ring() {
+		if server.HostPort == leader {
+			node = server
+			break
+		}
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if node.HostPort == owner.HostPort {
+		delete(ls.leaders, owner.HostPort)
+	} else {
+		ls.leaders[owner.HostPort] = node
+	}
+	return node
+}
+
+// forgetLeader discards the leader recorded for owner's range after it
+// failed to answer, so that the next request goes to owner, which will
+// redirect it to the new leader.
+func (ls *libstore) forgetLeader(owner, leader storagerpc.Node) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.leaders[owner.HostPort] == leader {
+		delete(ls.leaders, owner.HostPort)
+	}
+}
@@ -0,0 +1,66 @@
+package libstore
+
+import (
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// ring returns the storage nodes in the ring.
+func (ls *libstore) ring() []storagerpc.Node {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.servers
+}
+
+// owner returns the storage node responsible for key: the node its range was
+// last moved to, if it has been moved, or else its owner on the ring.
+func (ls *libstore) owner(key string) storagerpc.Node {
+	ls.mu.Lock()
+	servers, assigned := ls.servers, ls.assigned
+	ls.mu.Unlock()
+	if len(assigned) > 0 {
+		h := ls.partitioner.Hash(key)
+		for i := len(assigned) - 1; i >= 0; i-- {
+			if assigned[i].Range.Contains(h) {
+				return assigned[i].Owner
+			}
+		}
+	}
+	return ls.partitioner.Owner(key, servers)
+}
+
+// setRoutes adopts the ring and range assignments in reply, fetched at time
+// now.
+func (ls *libstore) setRoutes(reply *storagerpc.GetServersReply, now time.Time) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.servers = reply.Servers
+	ls.assigned = reply.Assignments
+	ls.routed = now
+	ls.leaders = make(map[string]storagerpc.Node)
+}
+
+// refreshRoutes asks the master for the ring and range assignments again,
+// unless they have been fetched since time since. Requests that find the
+// routes out of date call it before being sent again (see reroute).
+func (ls *libstore) refreshRoutes(since time.Time) error {
+	ls.refreshing.Lock()
+	defer ls.refreshing.Unlock()
+	ls.mu.Lock()
+	fresh := ls.routed.After(since)
+	ls.mu.Unlock()
+	if fresh {
+		return nil
+	}
+	now := time.Now()
+	var reply storagerpc.GetServersReply
+	if err := ls.call(storagerpc.Node{HostPort: ls.masterHostPort}, "GetServers", &storagerpc.GetServersArgs{}, &reply); err != nil {
+		return err
+	}
+	if reply.Status != storagerpc.OK {
+		return &StatusError{Op: "GetServers", Status: reply.Status}
+	}
+	ls.setRoutes(&reply, now)
+	return nil
+}
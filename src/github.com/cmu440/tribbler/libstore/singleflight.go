@@ -0,0 +1,73 @@
+package libstore
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// flight is a Get or GetList RPC in progress. Callers that arrive while the
+// RPC is outstanding wait on done and share its result.
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// flightGroup coalesces concurrent reads of the same key so that only one
+// RPC is sent to the storage server at a time. Whatever the RPC returns,
+// including a lease, is shared by every caller that waited on it.
+type flightGroup struct {
+	mu        sync.Mutex
+	flights   map[string]*flight
+	issued    uint64 // Number of RPCs actually sent (accessed atomically).
+	coalesced uint64 // Number of calls that shared another's RPC (accessed atomically).
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// do calls fn and returns its result, unless a call with the same key is
+// already in flight, in which case it waits for that call and returns its
+// result instead. shared reports whether the result came from another call.
+// Callers should build key with flightKey.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		atomic.AddUint64(&g.coalesced, 1)
+		<-f.done
+		return f.value, f.err, true
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	atomic.AddUint64(&g.issued, 1)
+	f.value, f.err = fn()
+
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.value, f.err, false
+}
+
+// flightKey returns the key used to coalesce op (e.g. "Get" or "GetList")
+// reads of key at consistency c that started at cache generation gen, and
+// that request a lease if wantLease is set. A read that starts after a local
+// write sees a newer generation, so it never shares an RPC that was sent
+// before the write completed; a read that wants a lease never shares one that
+// didn't ask for it, and so couldn't have been granted one.
+func flightKey(op, key string, c storagerpc.Consistency, wantLease bool, gen uint64) string {
+	return fmt.Sprintf("%s/%d/%t/%d/%s", op, c, wantLease, gen, key)
+}
+
+// stats returns the number of RPCs issued and the number of calls that were
+// merged into an RPC issued by another call.
+func (g *flightGroup) stats() (issued, coalesced uint64) {
+	return atomic.LoadUint64(&g.issued), atomic.LoadUint64(&g.coalesced)
+}
@@ -0,0 +1,57 @@
+package libstore
+
+import (
+	"fmt"
+	"net/rpc"
+	"time"
+)
+
+// StaleError is returned by Get and GetList, together with a cached value,
+// when the key's storage node could not be reached and the Libstore fell back
+// to a value whose lease has expired. Callers that would rather show stale
+// data than fail should check for it:
+//
+//	v, err := ls.Get(key)
+//	if _, stale := err.(*libstore.StaleError); stale {
+//	    // v holds the last value seen before Expired.
+//	}
+type StaleError struct {
+	Key     string
+	Expired time.Time // When the value's lease expired.
+	Err     error     // The error that prevented a fresh read.
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("stale value for %q (lease expired at %s): %v",
+		e.Key, e.Expired.Format(time.RFC3339Nano), e.Err)
+}
+
+// WithStaleGrace enables serving stale values: entries are kept in the cache
+// for grace after their lease expires, and are returned with a *StaleError if
+// a fresh read fails because the owning storage node is unreachable. A grace
+// of zero (the default) disables stale reads.
+func WithStaleGrace(grace time.Duration) Option {
+	return func(ls *libstore) {
+		ls.staleGrace = grace
+	}
+}
+
+// staleFallback returns a stale cache entry for key to serve in place of a
+// read that failed with err, or nil if there is none. Only failures to reach
+// the node qualify; an error returned by the storage server itself does not.
+func (ls *libstore) staleFallback(key string, err error) (*cacheEntry, error) {
+	if ls.staleGrace <= 0 {
+		return nil, err
+	}
+	if _, ok := err.(rpc.ServerError); ok {
+		return nil, err
+	}
+	entry, ok := ls.cache.lookupStale(key, time.Now())
+	if !ok {
+		return nil, err
+	}
+	ls.mu.Lock()
+	ls.staleReads++
+	ls.mu.Unlock()
+	return entry, &StaleError{Key: key, Expired: entry.expires, Err: err}
+}
@@ -0,0 +1,91 @@
+package libstore
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+// cacheExpired caches value for key under a lease that expired ago.
+func cacheExpired(c *leaseCache, key, value string, ago time.Duration) {
+	gen := c.beginRead(key)
+	c.endRead(key, gen, &cacheEntry{value: value, expires: time.Now().Add(-ago)})
+}
+
+func TestStaleFallback(t *testing.T) {
+	transportErr := errors.New("connection refused")
+	tests := []struct {
+		name      string
+		grace     time.Duration
+		expired   time.Duration // How long ago the cached lease expired.
+		err       error
+		wantStale bool
+	}{
+		{"transport error within grace", time.Minute, time.Second, transportErr, true},
+		{"connection shut down within grace", time.Minute, time.Second, rpc.ErrShutdown, true},
+		{"server error within grace", time.Minute, time.Second, rpc.ServerError("injected failure"), false},
+		{"transport error after grace", time.Minute, 2 * time.Minute, transportErr, false},
+		{"lease still valid", time.Minute, -time.Minute, transportErr, false},
+		{"stale reads disabled", 0, time.Second, transportErr, false},
+	}
+	for _, test := range tests {
+		ls := &libstore{staleGrace: test.grace, cache: newLeaseCache(test.grace)}
+		cacheExpired(ls.cache, "k", "old", test.expired)
+		entry, err := ls.staleFallback("k", test.err)
+		if !test.wantStale {
+			if entry != nil || err != test.err {
+				t.Errorf("%s: staleFallback = %+v, %v; want nil, %v", test.name, entry, err, test.err)
+			}
+			continue
+		}
+		se, ok := err.(*StaleError)
+		if entry == nil || entry.value != "old" || !ok || se.Err != test.err {
+			t.Errorf("%s: staleFallback = %+v, %v; want the old value and a StaleError", test.name, entry, err)
+		}
+	}
+}
+
+func TestGetFallsBackOnlyWhenUnreachable(t *testing.T) {
+	f := startFakeStorage(t)
+	f.values["k"] = "new"
+	l, err := NewLibstore(f.hostPort, "", Never, WithStaleGrace(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ls := l.(*libstore)
+	for _, key := range []string{"k", "missing"} {
+		cacheExpired(ls.cache, key, "old", time.Second)
+	}
+
+	// A reachable server's answer is returned, whatever its status.
+	if value, err := ls.Get("k"); err != nil || value != "new" {
+		t.Errorf("Get = %q, %v; want %q, nil", value, err, "new")
+	}
+	if _, err := ls.Get("missing"); err == nil {
+		t.Error("Get of a missing key succeeded")
+	} else if _, ok := err.(*StatusError); !ok {
+		t.Errorf("Get of a missing key = %v, want a StatusError", err)
+	}
+	f.mu.Lock()
+	f.fail = 1
+	f.mu.Unlock()
+	if _, err := ls.Get("k"); err == nil {
+		t.Error("Get failed by the server succeeded")
+	} else if _, ok := err.(*StaleError); ok {
+		t.Errorf("Get failed by the server = %v, want the server's error", err)
+	}
+
+	// Once the server can't be reached, the stale value is served.
+	f.listener.Close()
+	ls.mu.Lock()
+	ls.clients[f.hostPort].Close()
+	ls.mu.Unlock()
+	for _, get := range []func(string) (string, error){ls.Get, func(key string) (string, error) { return ls.GetAsync(key).Wait() }} {
+		value, err := get("k")
+		if _, ok := err.(*StaleError); !ok || value != "old" {
+			t.Errorf("Get of an unreachable key = %q, %v; want %q and a StaleError", value, err, "old")
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the latency buckets servers use for their RPCs.
+var DefaultBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Methods counts the requests a server handles for each RPC method, by the
+// status of their replies, and records their latencies. It is safe for
+// concurrent use.
+type Methods struct {
+	buckets []time.Duration
+
+	mu      sync.Mutex
+	methods map[string]*MethodSnapshot
+}
+
+// MethodSnapshot describes the requests handled for one method.
+type MethodSnapshot struct {
+	Method   string
+	Requests uint64
+	Counts   []uint64          // Latencies, bucketed as for Writer.Histogram.
+	Sum      time.Duration     // Total latency of all requests.
+	Statuses map[string]uint64 // Requests by the status of their reply.
+}
+
+// NewMethods returns a Methods that buckets latencies by the given upper
+// bounds, which must be in increasing order.
+func NewMethods(buckets []time.Duration) *Methods {
+	return &Methods{buckets: buckets, methods: make(map[string]*MethodSnapshot)}
+}
+
+// Observe records a request for method that was answered with status (e.g.
+// "OK", or "error" if the RPC itself failed) after latency.
+func (m *Methods) Observe(method, status string, latency time.Duration) {
+	bucket := sort.Search(len(m.buckets), func(i int) bool {
+		return latency <= m.buckets[i]
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.methods[method]
+	if !ok {
+		s = &MethodSnapshot{
+			Method:   method,
+			Counts:   make([]uint64, len(m.buckets)+1),
+			Statuses: make(map[string]uint64),
+		}
+		m.methods[method] = s
+	}
+	s.Requests++
+	s.Counts[bucket]++
+	s.Sum += latency
+	s.Statuses[status]++
+}
+
+// Snapshot returns a copy of the statistics of every method, in order of
+// method name.
+func (m *Methods) Snapshot() []MethodSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snaps := make([]MethodSnapshot, 0, len(m.methods))
+	for _, s := range m.methods {
+		c := *s
+		c.Counts = append([]uint64(nil), s.Counts...)
+		c.Statuses = make(map[string]uint64, len(s.Statuses))
+		for status, n := range s.Statuses {
+			c.Statuses[status] = n
+		}
+		snaps = append(snaps, c)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].Method < snaps[j].Method
+	})
+	return snaps
+}
+
+// Write writes the request counts, latencies and response statuses of every
+// method as the metrics <prefix>_requests_total,
+// <prefix>_request_duration_seconds and <prefix>_responses_total, labelled by
+// method (and status).
+func (m *Methods) Write(w *Writer, prefix string) {
+	snaps := m.Snapshot()
+	for _, s := range snaps {
+		w.Counter(prefix+"_requests_total", "RPC requests handled, by method.",
+			float64(s.Requests), "method", s.Method)
+	}
+	for _, s := range snaps {
+		w.Histogram(prefix+"_request_duration_seconds", "Time taken to handle RPC requests, by method.",
+			m.buckets, s.Counts, s.Sum, "method", s.Method)
+	}
+	for _, s := range snaps {
+		statuses := make([]string, 0, len(s.Statuses))
+		for status := range s.Statuses {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			w.Counter(prefix+"_responses_total", "RPC responses sent, by method and status.",
+				float64(s.Statuses[status]), "method", s.Method, "status", status)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMethodsBuckets(t *testing.T) {
+	m := NewMethods([]time.Duration{time.Millisecond, time.Second})
+	for _, latency := range []time.Duration{0, time.Millisecond, time.Millisecond + 1, time.Second, time.Minute} {
+		m.Observe("Get", "OK", latency)
+	}
+	m.Observe("Put", "error", time.Second)
+	m.Observe("Get", "KeyNotFound", time.Microsecond)
+
+	snaps := m.Snapshot()
+	if len(snaps) != 2 || snaps[0].Method != "Get" || snaps[1].Method != "Put" {
+		t.Fatalf("Snapshot = %+v, want Get then Put", snaps)
+	}
+	get := snaps[0]
+	if want := []uint64{3, 2, 1}; get.Requests != 6 || !reflect.DeepEqual(get.Counts, want) {
+		t.Errorf("Get: %d requests bucketed %v, want 6 bucketed %v", get.Requests, get.Counts, want)
+	}
+	if want := 2*time.Millisecond + time.Second + time.Minute + time.Microsecond + 1; get.Sum != want {
+		t.Errorf("Get: latency sum %v, want %v", get.Sum, want)
+	}
+	if want := map[string]uint64{"OK": 5, "KeyNotFound": 1}; !reflect.DeepEqual(get.Statuses, want) {
+		t.Errorf("Get: statuses %v, want %v", get.Statuses, want)
+	}
+
+	// Snapshots are copies.
+	get.Counts[0] = 100
+	if m.Snapshot()[0].Counts[0] != 3 {
+		t.Error("changing a snapshot changed the Methods")
+	}
+}
@@ -0,0 +1,116 @@
+// Package metrics exposes a server's counters over HTTP in the Prometheus
+// text format, so that dashboards can scrape them.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path is the HTTP path at which every server serves its metrics, on the same
+// listener (and http.ServeMux) as its RPCs.
+const Path = "/metrics"
+
+// Handler returns an http.Handler that serves the metrics written by collect,
+// which is called afresh for every scrape.
+func Handler(collect func(w *Writer)) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w := NewWriter(rw)
+		collect(w)
+		w.Flush()
+	})
+}
+
+// Writer writes metrics in the Prometheus text format. Every sample of a
+// metric must be written before the next metric is started, and a metric's
+// type and help text are taken from its first sample. Labels are given as
+// alternating names and values.
+type Writer struct {
+	w       *bufio.Writer
+	current string // The metric whose samples are being written.
+}
+
+// NewWriter returns a Writer that writes to w. Call Flush once every metric
+// has been written.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Flush writes any buffered output to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Counter writes a sample of a counter: a value that only goes up.
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.describe(name, "counter", help)
+	w.sample(name, labels, "", "", value)
+}
+
+// Gauge writes a sample of a gauge: a value that may go up and down.
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.describe(name, "gauge", help)
+	w.sample(name, labels, "", "", value)
+}
+
+// Histogram writes a histogram of durations. counts[i] is the number of
+// observations no greater than buckets[i] (and greater than buckets[i-1]),
+// with a final element counting the observations greater than every bucket.
+func (w *Writer) Histogram(name, help string, buckets []time.Duration, counts []uint64, sum time.Duration, labels ...string) {
+	w.describe(name, "histogram", help)
+	var total uint64
+	for i, n := range counts {
+		total += n
+		le := "+Inf"
+		if i < len(buckets) {
+			le = strconv.FormatFloat(buckets[i].Seconds(), 'g', -1, 64)
+		}
+		w.sample(name+"_bucket", labels, "le", le, float64(total))
+	}
+	w.sample(name+"_sum", labels, "", "", sum.Seconds())
+	w.sample(name+"_count", labels, "", "", float64(total))
+}
+
+// describe writes the HELP and TYPE lines of name, unless its samples are
+// already being written.
+func (w *Writer) describe(name, kind, help string) {
+	if name == w.current {
+		return
+	}
+	w.current = name
+	fmt.Fprintf(w.w, "# HELP %s %s\n", name, escape(help, false))
+	fmt.Fprintf(w.w, "# TYPE %s %s\n", name, kind)
+}
+
+// sample writes a single sample line. If extra is non-empty, it is added as a
+// final label with value extraValue.
+func (w *Writer) sample(name string, labels []string, extra, extraValue string, value float64) {
+	w.w.WriteString(name)
+	if len(labels) > 0 || extra != "" {
+		pairs := make([]string, 0, len(labels)/2+1)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], escape(labels[i+1], true)))
+		}
+		if extra != "" {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra, extraValue))
+		}
+		fmt.Fprintf(w.w, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(w.w, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// escape escapes s for use in a help text or, if quoted is set, a label value.
+func escape(s string, quoted bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quoted {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
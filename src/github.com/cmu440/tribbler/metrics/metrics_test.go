@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// written returns the text write writes to a Writer.
+func written(write func(w *Writer)) string {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	write(w)
+	w.Flush()
+	return buf.String()
+}
+
+func TestWriter(t *testing.T) {
+	got := written(func(w *Writer) {
+		w.Counter("requests_total", "Requests handled.", 3, "method", "Get")
+		w.Counter("requests_total", "Requests handled.", 1.5, "method", "Put")
+		w.Gauge("pending", "Requests in progress,\nright now.", 2)
+		w.Counter("errors_total", `Errors, by "kind".`, 1e9, "kind", "a\\b \"c\"\nd", "node", "x")
+	})
+	want := `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{method="Get"} 3
+requests_total{method="Put"} 1.5
+# HELP pending Requests in progress,\nright now.
+# TYPE pending gauge
+pending 2
+# HELP errors_total Errors, by "kind".
+# TYPE errors_total counter
+errors_total{kind="a\\b \"c\"\nd",node="x"} 1e+09
+`
+	if got != want {
+		t.Errorf("Writer wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	buckets := []time.Duration{time.Millisecond, 500 * time.Millisecond, time.Second}
+	got := written(func(w *Writer) {
+		w.Histogram("latency_seconds", "Request latency.", buckets, []uint64{2, 0, 3, 1}, 2500*time.Millisecond, "method", "Get")
+	})
+	want := `# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="Get",le="0.001"} 2
+latency_seconds_bucket{method="Get",le="0.5"} 2
+latency_seconds_bucket{method="Get",le="1"} 5
+latency_seconds_bucket{method="Get",le="+Inf"} 6
+latency_seconds_sum{method="Get"} 2.5
+latency_seconds_count{method="Get"} 6
+`
+	if got != want {
+		t.Errorf("Histogram wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(func(w *Writer) {
+		w.Gauge("up", "Whether the server is up.", 1)
+	}).ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+	if want := "# HELP up Whether the server is up.\n# TYPE up gauge\nup 1\n"; rec.Body.String() != want {
+		t.Errorf("body = %q, want %q", rec.Body.String(), want)
+	}
+}
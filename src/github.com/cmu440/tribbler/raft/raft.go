@@ -0,0 +1,669 @@
+// Package raft implements the Raft consensus algorithm (Ongaro and Ousterhout,
+// 2014), used to replicate each hash range of the storage ring across a group
+// of storage servers.
+//
+// A Node is one member of one group. Many groups may share a process: their
+// Nodes are added to a Host, which receives the Raft RPCs for all of them and
+// dispatches each to its group's Node.
+//
+// A Node configured with a Dir keeps its term, vote and log in a file there,
+// synced before the node acts on them, and recovers them when restarted with
+// the same Dir; committed entries are then applied again from the start of
+// the log. A node without a Dir keeps its state in memory only, and if it
+// restarts must rejoin its group under a new address, or the group may lose
+// committed entries.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/raftrpc"
+)
+
+// Default timing.
+const (
+	DefaultElectionTimeout   = 300 * time.Millisecond
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+)
+
+// tickInterval is how often a Node checks its election and heartbeat timers.
+const tickInterval = 10 * time.Millisecond
+
+var (
+	// ErrLostLeadership is returned by Propose if the node stopped being
+	// leader before the command was committed. The command may or may not
+	// eventually be applied.
+	ErrLostLeadership = errors.New("raft: lost leadership before the command was committed")
+
+	// ErrStopped is returned by Propose once the node has been stopped.
+	ErrStopped = errors.New("raft: node stopped")
+)
+
+// NotLeaderError is returned by Propose on a node that isn't its group's
+// leader.
+type NotLeaderError struct {
+	Leader string // The leader's host:port, or "" if it isn't known.
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "raft: not the leader, and no leader is known"
+	}
+	return fmt.Sprintf("raft: not the leader; the leader is %s", e.Leader)
+}
+
+// Config describes a Node.
+type Config struct {
+	Group string   // Identifies the group among those sharing a Host.
+	Self  string   // This node's host:port.
+	Peers []string // Every member's host:port, including Self.
+
+	// Apply is called with each committed command, in log order, from a
+	// single goroutine.
+	Apply func(index uint64, command []byte)
+
+	// ElectionTimeout is the minimum time a follower waits to hear from a
+	// leader before standing for election; each wait is randomized up to
+	// twice as long. It is also the length of the leader's read lease.
+	ElectionTimeout time.Duration
+
+	// HeartbeatInterval is how often the leader contacts its followers
+	// when it has nothing else to send. It should be well below
+	// ElectionTimeout.
+	HeartbeatInterval time.Duration
+
+	// Dir is the directory in which the node persists its state, in a
+	// file named after Group. If empty, the state is kept in memory only.
+	Dir string
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// waiter is a Propose call waiting for its entry to be applied.
+type waiter struct {
+	term uint64
+	done chan error
+}
+
+// Node is a member of a Raft group.
+type Node struct {
+	cfg       Config
+	transport Transport
+
+	mu       sync.Mutex
+	role     role
+	term     uint64
+	votedFor string
+	log      []raftrpc.Entry // log[0] is a sentinel; entries are indexed from 1.
+	leader   string          // The current leader, if known.
+
+	leaderSince time.Time // When this node last became leader.
+	inherited   bool      // Whether its log then held commands from earlier terms.
+
+	commitIndex uint64
+	lastApplied uint64
+
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	lastAck    map[string]time.Time // When each follower's last acked append was sent.
+
+	electionDeadline time.Time
+	nextHeartbeat    time.Time
+	lastHeard        time.Time // When this follower last heard from a leader.
+
+	stable    *stableStore // Nil without Config.Dir.
+	savedTerm uint64       // The term and vote last saved to stable.
+	savedVote string
+	unsaved   uint64 // The first log index not yet saved to stable.
+
+	waiters map[uint64]waiter // Propose calls, by log index.
+	applyCh chan struct{}     // Signals the applier that commitIndex moved.
+	stopped chan struct{}
+}
+
+// NewNode creates a Node as a follower, restoring its state from cfg.Dir if
+// there is any, and starts its timers. The Node sends RPCs to its peers
+// through transport, and must be added to a Host (or otherwise have its
+// RequestVote and AppendEntries methods invoked) to receive theirs.
+func NewNode(cfg Config, transport Transport) (*Node, error) {
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	n := &Node{
+		cfg:        cfg,
+		transport:  transport,
+		log:        []raftrpc.Entry{{}},
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		lastAck:    make(map[string]time.Time),
+		waiters:    make(map[uint64]waiter),
+		applyCh:    make(chan struct{}, 1),
+		stopped:    make(chan struct{}),
+	}
+	if cfg.Dir != "" {
+		stable, state, err := openStableStore(stablePath(cfg.Dir, cfg.Group))
+		if err != nil {
+			return nil, err
+		}
+		n.stable = stable
+		n.term, n.votedFor, n.log = state.term, state.votedFor, state.log
+		n.savedTerm, n.savedVote, n.unsaved = n.term, n.votedFor, n.lastIndex()+1
+	}
+	n.resetElectionTimer(time.Now())
+	go n.run()
+	go n.applier()
+	return n, nil
+}
+
+// Group returns the name of the node's group.
+func (n *Node) Group() string {
+	return n.cfg.Group
+}
+
+// Stop stops the node. Pending and future Propose calls fail with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.stopped:
+		return
+	default:
+	}
+	close(n.stopped)
+	for index, w := range n.waiters {
+		w.done <- ErrStopped
+		delete(n.waiters, index)
+	}
+	if n.stable != nil {
+		n.stable.close()
+	}
+}
+
+// Leader returns the group's leader, if known, and whether it is this node.
+func (n *Node) Leader() (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader, n.role == leader
+}
+
+// LeaderSince reports when this node became its group's leader, and whether
+// its log then held commands from earlier terms, which a previous leader may
+// have acted on. ok is false if the node isn't the leader.
+func (n *Node) LeaderSince() (since time.Time, inherited, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != leader {
+		return time.Time{}, false, false
+	}
+	return n.leaderSince, n.inherited, true
+}
+
+// ReadLease reports whether this node may serve reads from its own state
+// machine: it is the leader, it has applied an entry from its own term (so
+// that it has every committed entry), and a majority of the group has
+// acknowledged it recently enough that no other leader can have been elected.
+// If not, it returns the leader to redirect to, if known: "" means the caller
+// should retry later.
+func (n *Node) ReadLease() (bool, string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != leader {
+		return false, n.leader
+	}
+	if n.lastApplied < n.commitIndex || n.log[n.commitIndex].Term != n.term {
+		return false, ""
+	}
+	// Followers refuse to vote for ElectionTimeout after hearing from us,
+	// so the lease runs from when the majority's acks were sent. A margin
+	// allows for clock drift between machines.
+	lease := n.cfg.ElectionTimeout * 9 / 10
+	if time.Since(n.quorumAck()) >= lease {
+		return false, ""
+	}
+	return true, n.cfg.Self
+}
+
+// quorumAck returns the latest time by which a majority of the group had
+// acknowledged the leader.
+func (n *Node) quorumAck() time.Time {
+	acks := []time.Time{time.Now()}
+	for _, peer := range n.cfg.Peers {
+		if peer != n.cfg.Self {
+			acks = append(acks, n.lastAck[peer])
+		}
+	}
+	// Sort newest first; the majority-th newest ack is the quorum's.
+	sort.Slice(acks, func(i, j int) bool {
+		return acks[i].After(acks[j])
+	})
+	return acks[len(n.cfg.Peers)/2]
+}
+
+// Propose appends command to the group's log and waits until it has been
+// committed and applied, returning the index it was applied at. It fails with
+// a *NotLeaderError if this node isn't the leader.
+func (n *Node) Propose(command []byte) (uint64, error) {
+	n.mu.Lock()
+	select {
+	case <-n.stopped:
+		n.mu.Unlock()
+		return 0, ErrStopped
+	default:
+	}
+	if n.role != leader {
+		err := &NotLeaderError{Leader: n.leader}
+		n.mu.Unlock()
+		return 0, err
+	}
+	n.log = append(n.log, raftrpc.Entry{Term: n.term, Command: command})
+	index := n.lastIndex()
+	if err := n.persist(); err != nil {
+		n.truncate(index)
+		n.mu.Unlock()
+		return 0, err
+	}
+	w := waiter{term: n.term, done: make(chan error, 1)}
+	n.waiters[index] = w
+	n.broadcast(time.Now())
+	n.advanceCommit()
+	n.mu.Unlock()
+	return index, <-w.done
+}
+
+// Proposed reports whether the entry at index was appended by a Propose call
+// on this node that is still waiting for it to be applied. Apply may call it
+// to tell its own commands from those of other leaders.
+func (n *Node) Proposed(index uint64) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w, ok := n.waiters[index]
+	return ok && index <= n.lastIndex() && w.term == n.log[index].Term
+}
+
+func (n *Node) lastIndex() uint64 {
+	return uint64(len(n.log) - 1)
+}
+
+// persist durably saves the node's term and vote, if they have changed, and
+// the log entries not yet saved. It must succeed before the node replies to
+// an RPC or sends its peers anything based on them. Without a Dir it does
+// nothing.
+func (n *Node) persist() error {
+	if n.stable == nil {
+		return nil
+	}
+	if n.term == n.savedTerm && n.votedFor == n.savedVote && n.unsaved > n.lastIndex() {
+		return nil
+	}
+	if err := n.stable.save(stableState{term: n.term, votedFor: n.votedFor, log: n.log}, n.unsaved); err != nil {
+		return err
+	}
+	n.savedTerm, n.savedVote, n.unsaved = n.term, n.votedFor, n.lastIndex()+1
+	return nil
+}
+
+func (n *Node) resetElectionTimer(now time.Time) {
+	timeout := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.electionDeadline = now.Add(timeout)
+}
+
+// run drives the node's timers until it is stopped.
+func (n *Node) run() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case now := <-ticker.C:
+			n.mu.Lock()
+			if n.role == leader {
+				if !now.Before(n.nextHeartbeat) {
+					n.broadcast(now)
+				}
+			} else if !now.Before(n.electionDeadline) {
+				n.startElection(now)
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// stepDown makes the node a follower, adopting term if it is newer.
+func (n *Node) stepDown(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader = ""
+	}
+	n.role = follower
+}
+
+func (n *Node) startElection(now time.Time) {
+	n.role = candidate
+	n.term++
+	n.votedFor = n.cfg.Self
+	n.leader = ""
+	n.resetElectionTimer(now)
+	if err := n.persist(); err != nil {
+		n.role = follower
+		return
+	}
+
+	args := &raftrpc.RequestVoteArgs{
+		Group:        n.cfg.Group,
+		Term:         n.term,
+		CandidateID:  n.cfg.Self,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.log[n.lastIndex()].Term,
+	}
+	votes := 1
+	if votes > len(n.cfg.Peers)/2 {
+		n.becomeLeader(now)
+		return
+	}
+	for _, peer := range n.cfg.Peers {
+		if peer == n.cfg.Self {
+			continue
+		}
+		go func(peer string) {
+			var reply raftrpc.RequestVoteReply
+			if err := n.transport.RequestVote(peer, args, &reply); err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.term {
+				n.stepDown(reply.Term)
+				n.resetElectionTimer(time.Now())
+				return
+			}
+			if n.role != candidate || n.term != args.Term || !reply.VoteGranted {
+				return
+			}
+			if votes++; votes > len(n.cfg.Peers)/2 {
+				n.becomeLeader(time.Now())
+			}
+		}(peer)
+	}
+}
+
+func (n *Node) becomeLeader(now time.Time) {
+	n.role = leader
+	n.leader = n.cfg.Self
+	n.leaderSince = now
+	n.inherited = false
+	for _, e := range n.log[1:] {
+		if e.Command != nil {
+			n.inherited = true
+			break
+		}
+	}
+	for _, peer := range n.cfg.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+		n.lastAck[peer] = time.Time{}
+	}
+	// Commit a no-op from the new term, which commits everything before it.
+	n.log = append(n.log, raftrpc.Entry{Term: n.term})
+	if err := n.persist(); err != nil {
+		n.truncate(n.lastIndex())
+		n.role, n.leader = follower, ""
+		return
+	}
+	n.broadcast(now)
+	n.advanceCommit()
+}
+
+// broadcast sends AppendEntries to every follower.
+func (n *Node) broadcast(now time.Time) {
+	n.nextHeartbeat = now.Add(n.cfg.HeartbeatInterval)
+	for _, peer := range n.cfg.Peers {
+		if peer != n.cfg.Self {
+			go n.sendAppend(peer)
+		}
+	}
+}
+
+// sendAppend sends the entries peer is missing (possibly none) to peer.
+func (n *Node) sendAppend(peer string) {
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return
+	}
+	prev := n.nextIndex[peer] - 1
+	if prev > n.lastIndex() {
+		prev = n.lastIndex()
+	}
+	args := &raftrpc.AppendEntriesArgs{
+		Group:        n.cfg.Group,
+		Term:         n.term,
+		LeaderID:     n.cfg.Self,
+		PrevLogIndex: prev,
+		PrevLogTerm:  n.log[prev].Term,
+		Entries:      append([]raftrpc.Entry(nil), n.log[prev+1:]...),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	sent := time.Now()
+	var reply raftrpc.AppendEntriesReply
+	if err := n.transport.AppendEntries(peer, args, &reply); err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.term {
+		n.stepDown(reply.Term)
+		n.resetElectionTimer(time.Now())
+		return
+	}
+	if n.role != leader || n.term != args.Term {
+		return
+	}
+	if sent.After(n.lastAck[peer]) {
+		n.lastAck[peer] = sent
+	}
+	if reply.Success {
+		if match := prev + uint64(len(args.Entries)); match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+			n.nextIndex[peer] = match + 1
+		}
+		n.advanceCommit()
+		return
+	}
+	next := reply.ConflictIndex
+	if next < 1 {
+		next = 1
+	}
+	if next < n.nextIndex[peer] {
+		n.nextIndex[peer] = next
+		go n.sendAppend(peer)
+	}
+}
+
+// advanceCommit commits the newest entry from the current term that a
+// majority of the group has stored, along with everything before it.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.log[index].Term != n.term {
+			break
+		}
+		count := 1
+		for _, peer := range n.cfg.Peers {
+			if peer != n.cfg.Self && n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count > len(n.cfg.Peers)/2 {
+			n.commitIndex = index
+			n.signalApply()
+			return
+		}
+	}
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// applier applies committed entries in order and completes the Propose
+// calls waiting on them.
+func (n *Node) applier() {
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case <-n.applyCh:
+		}
+		n.mu.Lock()
+		start := n.lastApplied + 1
+		entries := append([]raftrpc.Entry(nil), n.log[start:n.commitIndex+1]...)
+		n.mu.Unlock()
+
+		for i, e := range entries {
+			index := start + uint64(i)
+			if e.Command != nil && n.cfg.Apply != nil {
+				n.cfg.Apply(index, e.Command)
+			}
+			n.mu.Lock()
+			n.lastApplied = index
+			if w, ok := n.waiters[index]; ok {
+				delete(n.waiters, index)
+				if w.term == e.Term {
+					w.done <- nil
+				} else {
+					w.done <- ErrLostLeadership
+				}
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// RequestVote handles a candidate's request for this node's vote.
+func (n *Node) RequestVote(args *raftrpc.RequestVoteArgs, reply *raftrpc.RequestVoteReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.requestVote(args, reply)
+	return n.persist()
+}
+
+func (n *Node) requestVote(args *raftrpc.RequestVoteArgs, reply *raftrpc.RequestVoteReply) {
+	now := time.Now()
+	reply.Term = n.term
+
+	// While our leader's read lease may still be valid, don't help elect
+	// another leader (or disrupt this one by adopting a newer term).
+	if n.role == leader || (n.leader != "" && now.Sub(n.lastHeard) < n.cfg.ElectionTimeout) {
+		return
+	}
+	if args.Term < n.term {
+		return
+	}
+	if args.Term > n.term {
+		n.stepDown(args.Term)
+		reply.Term = n.term
+	}
+	lastTerm := n.log[n.lastIndex()].Term
+	upToDate := args.LastLogTerm > lastTerm ||
+		(args.LastLogTerm == lastTerm && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.CandidateID) && upToDate {
+		n.votedFor = args.CandidateID
+		reply.VoteGranted = true
+		n.resetElectionTimer(now)
+	}
+}
+
+// AppendEntries handles entries (or a heartbeat) from the group's leader.
+func (n *Node) AppendEntries(args *raftrpc.AppendEntriesArgs, reply *raftrpc.AppendEntriesReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.appendEntries(args, reply)
+	return n.persist()
+}
+
+func (n *Node) appendEntries(args *raftrpc.AppendEntriesArgs, reply *raftrpc.AppendEntriesReply) {
+	now := time.Now()
+	reply.Term = n.term
+	if args.Term < n.term {
+		return
+	}
+	if args.Term > n.term || n.role != follower {
+		n.stepDown(args.Term)
+		reply.Term = n.term
+	}
+	n.leader = args.LeaderID
+	n.lastHeard = now
+	n.resetElectionTimer(now)
+
+	if args.PrevLogIndex > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return
+	}
+	if term := n.log[args.PrevLogIndex].Term; term != args.PrevLogTerm {
+		index := args.PrevLogIndex
+		for index > 1 && n.log[index-1].Term == term {
+			index--
+		}
+		reply.ConflictIndex = index
+		return
+	}
+
+	for i, e := range args.Entries {
+		index := args.PrevLogIndex + 1 + uint64(i)
+		if index <= n.lastIndex() {
+			if n.log[index].Term == e.Term {
+				continue
+			}
+			n.truncate(index)
+		}
+		n.log = append(n.log, args.Entries[i:]...)
+		break
+	}
+	if args.LeaderCommit > n.commitIndex {
+		last := args.PrevLogIndex + uint64(len(args.Entries))
+		if args.LeaderCommit < last {
+			last = args.LeaderCommit
+		}
+		if last > n.commitIndex {
+			n.commitIndex = last
+			n.signalApply()
+		}
+	}
+	reply.Success = true
+}
+
+// truncate discards the entries from index on, failing any Propose calls
+// waiting on them.
+func (n *Node) truncate(index uint64) {
+	for i := index; i <= n.lastIndex(); i++ {
+		if w, ok := n.waiters[i]; ok {
+			delete(n.waiters, i)
+			w.done <- ErrLostLeadership
+		}
+	}
+	n.log = n.log[:index]
+	if index < n.unsaved {
+		n.unsaved = index
+	}
+}
@@ -0,0 +1,102 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+// startSolo starts a single-member group persisting its state in dir, and
+// waits until it leads the group. Applied commands are sent on applied.
+func startSolo(t *testing.T, dir string, applied chan<- string) *Node {
+	n, err := NewNode(Config{
+		Group: "g",
+		Self:  "self:1",
+		Peers: []string{"self:1"},
+		Apply: func(index uint64, command []byte) { applied <- string(command) },
+		Dir:   dir,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitLeader(t, n)
+	return n
+}
+
+// waitLeader waits until n leads its group.
+func waitLeader(t *testing.T, n *Node) {
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(tickInterval) {
+		if _, ok := n.Leader(); ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("node never became leader")
+		}
+	}
+}
+
+func TestNodeRestoresState(t *testing.T) {
+	dir := t.TempDir()
+	applied := make(chan string, 10)
+	n := startSolo(t, dir, applied)
+	for _, cmd := range []string{"a", "b"} {
+		if _, err := n.Propose([]byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+		<-applied
+	}
+	n.mu.Lock()
+	term := n.term
+	n.mu.Unlock()
+	if _, inherited, ok := n.LeaderSince(); !ok || inherited {
+		t.Errorf("new node: LeaderSince() = inherited %v, ok %v; want false, true", inherited, ok)
+	}
+	n.Stop()
+
+	// The restarted node reapplies its log, and its new term is newer.
+	n = startSolo(t, dir, applied)
+	defer n.Stop()
+	for _, want := range []string{"a", "b"} {
+		select {
+		case got := <-applied:
+			if got != want {
+				t.Fatalf("restored node applied %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("restored node never applied %q", want)
+		}
+	}
+	if _, inherited, ok := n.LeaderSince(); !ok || !inherited {
+		t.Errorf("restored node: LeaderSince() = inherited %v, ok %v; want true, true", inherited, ok)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.term <= term {
+		t.Errorf("restored node is in term %d, want later than %d", n.term, term)
+	}
+}
+
+func TestProposed(t *testing.T) {
+	proposed := make(chan bool, 1)
+	var n *Node
+	n, err := NewNode(Config{
+		Group: "g",
+		Self:  "self:1",
+		Peers: []string{"self:1"},
+		Apply: func(index uint64, command []byte) { proposed <- n.Proposed(index) },
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+	waitLeader(t, n)
+	index, err := n.Propose([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !<-proposed {
+		t.Error("Proposed = false while applying the node's own command, want true")
+	}
+	if n.Proposed(index) {
+		t.Error("Proposed = true once Propose has returned, want false")
+	}
+}
@@ -0,0 +1,112 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cmu440/tribbler/rpc/raftrpc"
+)
+
+// stableRecord is one change to a node's persistent state, as stored in its
+// file: either a new term and vote, or an entry at Index, which replaces the
+// entry there and discards any after it.
+type stableRecord struct {
+	Term     uint64         `json:",omitempty"`
+	VotedFor string         `json:",omitempty"`
+	Index    uint64         `json:",omitempty"`
+	Entry    *raftrpc.Entry `json:",omitempty"`
+}
+
+// stableState is the state a node must not forget across restarts.
+type stableState struct {
+	term     uint64
+	votedFor string
+	log      []raftrpc.Entry // log[0] is a sentinel, as in Node.
+}
+
+// stableStore is an append-only file of stableRecords. Each batch of records
+// is synced to disk before the node acts on the state it describes.
+type stableStore struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+// stablePath returns the file in which the member of group keeps its state in
+// dir.
+func stablePath(dir, group string) string {
+	return filepath.Join(dir, group+".raft")
+}
+
+// openStableStore opens the file at path, creating it if necessary, and
+// returns the state recorded in it. The file is compacted to hold only that
+// state.
+func openStableStore(path string) (*stableStore, stableState, error) {
+	state := stableState{log: []raftrpc.Entry{{}}}
+	if f, err := os.Open(path); err == nil {
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for {
+			var rec stableRecord
+			if err := dec.Decode(&rec); err != nil {
+				// A torn final record is expected after a crash; the
+				// node never acted on the state it describes.
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					if _, ok := err.(*json.SyntaxError); !ok {
+						f.Close()
+						return nil, state, err
+					}
+				}
+				break
+			}
+			if rec.Entry != nil {
+				if rec.Index < 1 || rec.Index > uint64(len(state.log)) {
+					continue
+				}
+				state.log = append(state.log[:rec.Index], *rec.Entry)
+			} else {
+				state.term, state.votedFor = rec.Term, rec.VotedFor
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, state, err
+	}
+
+	// Write the state to a new file and swap it in atomically.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, state, err
+	}
+	s := &stableStore{file: f, enc: json.NewEncoder(f)}
+	if err := s.save(state, 1); err != nil {
+		f.Close()
+		return nil, state, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		return nil, state, err
+	}
+	return s, state, nil
+}
+
+// save durably records state's term and vote, and its log entries from index
+// from on.
+func (s *stableStore) save(state stableState, from uint64) error {
+	if err := s.enc.Encode(stableRecord{Term: state.term, VotedFor: state.votedFor}); err != nil {
+		return err
+	}
+	for i := from; i < uint64(len(state.log)); i++ {
+		if err := s.enc.Encode(stableRecord{Index: i, Entry: &state.log[i]}); err != nil {
+			return err
+		}
+	}
+	return s.file.Sync()
+}
+
+// close closes the file.
+func (s *stableStore) close() error {
+	return s.file.Close()
+}
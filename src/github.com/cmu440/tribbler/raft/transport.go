@@ -0,0 +1,144 @@
+package raft
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/raftrpc"
+)
+
+// Transport carries a Node's RPCs to its peers.
+type Transport interface {
+	RequestVote(peer string, args *raftrpc.RequestVoteArgs, reply *raftrpc.RequestVoteReply) error
+	AppendEntries(peer string, args *raftrpc.AppendEntriesArgs, reply *raftrpc.AppendEntriesReply) error
+}
+
+// rpcTransport sends RPCs to the "Raft" service of each peer over HTTP.
+type rpcTransport struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*rpc.Client // By host:port.
+}
+
+// NewRPCTransport returns a Transport that calls the "Raft" service (see
+// raftrpc.Wrap) of each peer using net/rpc over HTTP, giving up on each call
+// after timeout. It may be shared by all the Nodes in a process.
+func NewRPCTransport(timeout time.Duration) Transport {
+	return &rpcTransport{timeout: timeout, clients: make(map[string]*rpc.Client)}
+}
+
+func (t *rpcTransport) RequestVote(peer string, args *raftrpc.RequestVoteArgs, reply *raftrpc.RequestVoteReply) error {
+	return t.call(peer, "Raft.RequestVote", args, reply)
+}
+
+func (t *rpcTransport) AppendEntries(peer string, args *raftrpc.AppendEntriesArgs, reply *raftrpc.AppendEntriesReply) error {
+	return t.call(peer, "Raft.AppendEntries", args, reply)
+}
+
+func (t *rpcTransport) call(peer, method string, args, reply interface{}) error {
+	t.mu.Lock()
+	cli, ok := t.clients[peer]
+	t.mu.Unlock()
+	if !ok {
+		var err error
+		if cli, err = rpc.DialHTTP("tcp", peer); err != nil {
+			return err
+		}
+		t.mu.Lock()
+		if existing, ok := t.clients[peer]; ok {
+			cli.Close()
+			cli = existing
+		} else {
+			t.clients[peer] = cli
+		}
+		t.mu.Unlock()
+	}
+
+	call := cli.Go(method, args, reply, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		if call.Error == rpc.ErrShutdown {
+			t.drop(peer, cli)
+		}
+		return call.Error
+	case <-timer.C:
+		return fmt.Errorf("raft: %s to %s timed out", method, peer)
+	}
+}
+
+// drop discards a broken connection so that the next call redials.
+func (t *rpcTransport) drop(peer string, cli *rpc.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clients[peer] == cli {
+		delete(t.clients, peer)
+		cli.Close()
+	}
+}
+
+// Host receives Raft RPCs on behalf of all the Nodes in a process and
+// dispatches each to the Node of the group it names. It implements
+// raftrpc.RemoteRaft.
+type Host struct {
+	mu    sync.Mutex
+	nodes map[string]*Node // By group.
+}
+
+// NewHost returns a Host with no Nodes.
+func NewHost() *Host {
+	return &Host{nodes: make(map[string]*Node)}
+}
+
+// Add starts dispatching RPCs for n's group to n.
+func (h *Host) Add(n *Node) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodes[n.Group()] = n
+}
+
+// Remove stops dispatching RPCs for group, and returns its Node (or nil).
+func (h *Host) Remove(group string) *Node {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.nodes[group]
+	delete(h.nodes, group)
+	return n
+}
+
+// StopAll stops and removes every Node.
+func (h *Host) StopAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for group, n := range h.nodes {
+		n.Stop()
+		delete(h.nodes, group)
+	}
+}
+
+// Node returns the Node for group, or nil if there is none.
+func (h *Host) Node(group string) *Node {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.nodes[group]
+}
+
+func (h *Host) RequestVote(args *raftrpc.RequestVoteArgs, reply *raftrpc.RequestVoteReply) error {
+	n := h.Node(args.Group)
+	if n == nil {
+		return fmt.Errorf("raft: unknown group %q", args.Group)
+	}
+	return n.RequestVote(args, reply)
+}
+
+func (h *Host) AppendEntries(args *raftrpc.AppendEntriesArgs, reply *raftrpc.AppendEntriesReply) error {
+	n := h.Node(args.Group)
+	if n == nil {
+		return fmt.Errorf("raft: unknown group %q", args.Group)
+	}
+	return n.AppendEntries(args, reply)
+}
@@ -0,0 +1,194 @@
+// Package rebalancer plans and executes hash range moves between storage
+// servers so as to even out their load.
+package rebalancer
+
+import (
+	"fmt"
+	"math"
+	"net/rpc"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// Metric selects which aspect of load the rebalancer equalizes.
+type Metric int
+
+const (
+	Requests Metric = iota // Request rate.
+	Keys                   // Number of keys stored.
+	Bytes                  // Number of bytes stored.
+)
+
+// ParseMetric parses the name of a metric ("requests", "keys" or "bytes").
+func ParseMetric(name string) (Metric, error) {
+	switch name {
+	case "requests":
+		return Requests, nil
+	case "keys":
+		return Keys, nil
+	case "bytes":
+		return Bytes, nil
+	default:
+		return 0, fmt.Errorf("unknown metric %q", name)
+	}
+}
+
+// value returns the part of b's load measured by m.
+func (m Metric) value(b adminrpc.BucketLoad) float64 {
+	switch m {
+	case Keys:
+		return float64(b.Keys)
+	case Bytes:
+		return float64(b.Bytes)
+	default:
+		return b.Rate
+	}
+}
+
+// NodeLoad is the load reported by one storage server.
+type NodeLoad struct {
+	Node    storagerpc.Node
+	Buckets []adminrpc.BucketLoad
+}
+
+// Total returns the node's total load as measured by m.
+func (l NodeLoad) Total(m Metric) float64 {
+	var total float64
+	for _, b := range l.Buckets {
+		total += m.value(b)
+	}
+	return total
+}
+
+// Move is a hash range to be moved from one server to another.
+type Move struct {
+	Range storagerpc.HashRange
+	From  storagerpc.Node
+	To    storagerpc.Node
+	Load  float64 // The range's load, as measured by the plan's metric.
+}
+
+func (mv Move) String() string {
+	return fmt.Sprintf("move (%d, %d] from %s to %s (load %.2f)",
+		mv.Range.Start, mv.Range.End, mv.From.HostPort, mv.To.HostPort, mv.Load)
+}
+
+// Collect asks the master storage server for the ring's nodes and then asks
+// each node for its load, split into the given number of buckets.
+func Collect(masterHostPort string, buckets int) ([]NodeLoad, error) {
+	master, err := rpc.DialHTTP("tcp", masterHostPort)
+	if err != nil {
+		return nil, err
+	}
+	defer master.Close()
+	var servers storagerpc.GetServersReply
+	if err := master.Call("StorageServer.GetServers", &storagerpc.GetServersArgs{}, &servers); err != nil {
+		return nil, err
+	}
+	if servers.Status != storagerpc.OK {
+		return nil, fmt.Errorf("GetServers failed with status %v", servers.Status)
+	}
+
+	loads := make([]NodeLoad, len(servers.Servers))
+	for i, node := range servers.Servers {
+		cli, err := rpc.DialHTTP("tcp", node.HostPort)
+		if err != nil {
+			return nil, err
+		}
+		var reply adminrpc.LoadReply
+		err = cli.Call("Admin.Load", &adminrpc.LoadArgs{Buckets: buckets}, &reply)
+		cli.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", node.HostPort, err)
+		}
+		loads[i] = NodeLoad{Node: node, Buckets: reply.Buckets}
+	}
+	return loads, nil
+}
+
+// Plan proposes at most maxMoves moves that bring every node's load, as
+// measured by m, within the given fraction (e.g. 0.1) of the mean. Each move
+// takes a bucket from the most loaded node and gives it to the least loaded
+// one, choosing the bucket that best halves the difference between them; it
+// stops early once no move would narrow the gap.
+func Plan(loads []NodeLoad, m Metric, tolerance float64, maxMoves int) []Move {
+	if len(loads) < 2 {
+		return nil
+	}
+	totals := make([]float64, len(loads))
+	buckets := make([][]adminrpc.BucketLoad, len(loads))
+	var sum float64
+	for i, l := range loads {
+		totals[i] = l.Total(m)
+		buckets[i] = append([]adminrpc.BucketLoad(nil), l.Buckets...)
+		sum += totals[i]
+	}
+	mean := sum / float64(len(loads))
+
+	var moves []Move
+	for len(moves) < maxMoves {
+		hi, lo := 0, 0
+		for i := range totals {
+			if totals[i] > totals[hi] {
+				hi = i
+			}
+			if totals[i] < totals[lo] {
+				lo = i
+			}
+		}
+		gap := totals[hi] - totals[lo]
+		if totals[hi] <= mean*(1+tolerance) || gap <= 0 {
+			break
+		}
+
+		// Pick the bucket whose load is closest to half the gap. Moving
+		// anything less than the whole gap narrows it.
+		best := -1
+		bestDist := math.Inf(1)
+		for j, b := range buckets[hi] {
+			v := m.value(b)
+			if v <= 0 || v >= gap {
+				continue
+			}
+			if d := math.Abs(v - gap/2); d < bestDist {
+				best, bestDist = j, d
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		b := buckets[hi][best]
+		buckets[hi] = append(buckets[hi][:best], buckets[hi][best+1:]...)
+		buckets[lo] = append(buckets[lo], b)
+		totals[hi] -= m.value(b)
+		totals[lo] += m.value(b)
+		moves = append(moves, Move{Range: b.Range, From: loads[hi].Node, To: loads[lo].Node, Load: m.value(b)})
+	}
+	return moves
+}
+
+// Execute carries out moves in order by asking each source node to transfer
+// its range, stopping at the first failure. A node replies to TransferRange
+// only once the master routes the range to its new owner, so libstores that
+// ask the master for the ring afterwards find the moved keys.
+func Execute(moves []Move) error {
+	for _, mv := range moves {
+		cli, err := rpc.DialHTTP("tcp", mv.From.HostPort)
+		if err != nil {
+			return fmt.Errorf("%v: %v", mv, err)
+		}
+		args := &storagerpc.TransferRangeArgs{Range: mv.Range, Target: mv.To}
+		var reply storagerpc.TransferRangeReply
+		err = cli.Call("StorageServer.TransferRange", args, &reply)
+		cli.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %v", mv, err)
+		}
+		if reply.Status != storagerpc.OK {
+			return fmt.Errorf("%v: TransferRange failed with status %v", mv, reply.Status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package rebalancer
+
+import (
+	"testing"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// load returns the load of a node named hostPort whose buckets hold the
+// given numbers of keys.
+func load(hostPort string, keys ...int) NodeLoad {
+	l := NodeLoad{Node: storagerpc.Node{HostPort: hostPort}}
+	for i, k := range keys {
+		r := storagerpc.HashRange{Start: uint32(i * 100), End: uint32(i*100 + 100)}
+		l.Buckets = append(l.Buckets, adminrpc.BucketLoad{Range: r, Keys: k})
+	}
+	return l
+}
+
+func TestPlan(t *testing.T) {
+	type move struct {
+		from, to string
+		load     float64
+	}
+	tests := []struct {
+		name      string
+		loads     []NodeLoad
+		tolerance float64
+		maxMoves  int
+		want      []move
+	}{
+		{"single node", []NodeLoad{load("a", 5, 5)}, 0, 10, nil},
+		{"balanced", []NodeLoad{load("a", 5, 5), load("b", 4, 6)}, 0, 10, nil},
+		{"within tolerance", []NodeLoad{load("a", 6, 5, 1), load("b", 4, 4)}, 0.25, 10, nil},
+		{"outside tolerance", []NodeLoad{load("a", 6, 5, 1), load("b", 4, 4)}, 0, 10, []move{
+			{"a", "b", 1},
+		}},
+		{"bucket closest to half the gap", []NodeLoad{load("a", 1, 4, 7), load("b")}, 0, 10, []move{
+			{"a", "b", 7},
+		}},
+		{"bucket larger than the gap", []NodeLoad{load("a", 10), load("b", 2)}, 0, 10, nil},
+		{"most to least loaded", []NodeLoad{load("a", 3), load("b", 1, 1, 1, 1, 1, 1), load("c")}, 0, 10, []move{
+			{"b", "c", 1},
+			{"b", "c", 1},
+			{"b", "c", 1},
+		}},
+		{"maxMoves", []NodeLoad{load("a", 1, 1, 1, 1, 1, 1, 1, 1), load("b")}, 0, 2, []move{
+			{"a", "b", 1},
+			{"a", "b", 1},
+		}},
+		{"stops once balanced", []NodeLoad{load("a", 1, 1, 1, 1, 1, 1, 1, 1), load("b")}, 0, 10, []move{
+			{"a", "b", 1},
+			{"a", "b", 1},
+			{"a", "b", 1},
+			{"a", "b", 1},
+		}},
+	}
+	for _, test := range tests {
+		got := Plan(test.loads, Keys, test.tolerance, test.maxMoves)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: Plan = %v, want %d moves", test.name, got, len(test.want))
+			continue
+		}
+		for i, mv := range got {
+			want := test.want[i]
+			if mv.From.HostPort != want.from || mv.To.HostPort != want.to || mv.Load != want.load {
+				t.Errorf("%s: move %d = %v, want load %v from %s to %s", test.name, i, mv, want.load, want.from, want.to)
+			}
+		}
+	}
+}
+
+func TestPlanDoesNotChangeLoads(t *testing.T) {
+	loads := []NodeLoad{load("a", 1, 2, 3, 4), load("b")}
+	Plan(loads, Keys, 0, 10)
+	if len(loads[0].Buckets) != 4 || len(loads[1].Buckets) != 0 {
+		t.Errorf("Plan changed its input: %v", loads)
+	}
+}
@@ -0,0 +1,157 @@
+// This file contains the arguments used to perform administrative RPCs
+// against a storage server.
+
+package adminrpc
+
+import (
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// PrefixRate is the request rate observed for a key prefix (i.e. a user ID).
+type PrefixRate struct {
+	Prefix string
+	Rate   float64 // Requests per second, averaged over the tracking window.
+}
+
+type HotPrefixesArgs struct {
+	N int // Maximum number of prefixes to return.
+}
+
+type HotPrefixesReply struct {
+	Prefixes []PrefixRate // Hottest first.
+}
+
+// RevocationStats describes the lease revocations a storage server has
+// performed on behalf of writes.
+type RevocationStats struct {
+	Revocations  uint64        // Leases revoked.
+	Acked        uint64        // Revocations acknowledged by the lease holder.
+	WaitedOut    uint64        // Revocations that had to wait for the lease to expire.
+	TotalLatency time.Duration // Sum of the time writes spent waiting on each revocation.
+	MaxLatency   time.Duration
+}
+
+type RevocationStatsArgs struct {
+	// Intentionally left empty.
+}
+
+type RevocationStatsReply struct {
+	Stats RevocationStats
+}
+
+// QueueDepth is the number of writes to a key that are in progress or
+// waiting for an earlier write to complete.
+type QueueDepth struct {
+	Key   string
+	Depth int
+}
+
+type WriteQueuesArgs struct {
+	// Intentionally left empty.
+}
+
+type WriteQueuesReply struct {
+	Queues []QueueDepth // Deepest first; keys without pending writes are omitted.
+}
+
+// BucketLoad is the load on one part of the hash range owned by a storage
+// server.
+type BucketLoad struct {
+	Range storagerpc.HashRange
+	Keys  int     // Number of values and lists stored.
+	Bytes int64   // Total size of the keys and their values.
+	Rate  float64 // Requests per second, averaged over the tracking window.
+}
+
+type LoadArgs struct {
+	Buckets int // Number of roughly equal parts to split the server's range into.
+}
+
+type LoadReply struct {
+	Buckets []BucketLoad
+}
+
+// RangeDivergence describes how a replica's copy of a hash range differed
+// from this server's when they were last compared.
+type RangeDivergence struct {
+	Range    storagerpc.HashRange
+	Replica  string // The replica's host:port.
+	Leaves   int    // Merkle tree leaves whose hashes differed.
+	Repaired int    // Keys sent to, or deleted from, the replica.
+	Checked  time.Time
+	Err      string // Set if the comparison or repair failed.
+}
+
+type RepairArgs struct {
+	DryRun bool // Only compare the replicas, without repairing them.
+}
+
+type RepairReply struct {
+	Ranges []RangeDivergence
+}
+
+type DrainServerArgs struct {
+	Timeout time.Duration // How long to wait for requests in progress; 0 waits as long as it takes.
+}
+
+type DrainServerReply struct {
+	Drained bool // False if requests were still in progress after Timeout.
+}
+
+// LatencyBuckets are the upper bounds of the buckets of every Histogram.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Histogram is a distribution of request latencies.
+type Histogram struct {
+	// Counts[i] is the number of requests that took at most
+	// LatencyBuckets[i] (and more than LatencyBuckets[i-1]). The final
+	// element counts the requests slower than every bucket.
+	Counts []uint64
+	Sum    time.Duration // Total latency of all requests.
+}
+
+// MethodStats describes the requests a server has handled for one RPC method.
+type MethodStats struct {
+	Method   string // E.g. "Get".
+	Requests uint64
+	Latency  Histogram
+}
+
+// LeaseCount is the number of unexpired leases held on a key.
+type LeaseCount struct {
+	Key     string
+	Holders int
+}
+
+type StatsArgs struct {
+	// Intentionally left empty.
+}
+
+type StatsReply struct {
+	Keys  int   // Number of values stored.
+	Lists int   // Number of lists stored.
+	Bytes int64 // Total size of the keys and their values and lists.
+
+	// Ranges are the hash ranges the server owns: the one between its
+	// predecessor's NodeID and its own, less any it has moved away, plus
+	// any it has received.
+	Ranges []storagerpc.HashRange
+
+	Leases             []LeaseCount // Keys with unexpired leases, most held first.
+	PendingRevocations int          // Lease revocations currently in progress.
+
+	Methods []MethodStats // In order of method name.
+}
@@ -0,0 +1,29 @@
+// This file provides a type-safe wrapper that should be used to register
+// a storage server to receive administrative RPCs.
+
+package adminrpc
+
+type RemoteAdmin interface {
+	HotPrefixes(*HotPrefixesArgs, *HotPrefixesReply) error
+	RevocationStats(*RevocationStatsArgs, *RevocationStatsReply) error
+	WriteQueues(*WriteQueuesArgs, *WriteQueuesReply) error
+	Load(*LoadArgs, *LoadReply) error
+	Repair(*RepairArgs, *RepairReply) error
+	Stats(*StatsArgs, *StatsReply) error
+	DrainServer(*DrainServerArgs, *DrainServerReply) error
+}
+
+type Admin struct {
+	// Embed all methods into the struct. See the Effective Go section about
+	// embedding for more details: golang.org/doc/effective_go.html#embedding
+	RemoteAdmin
+}
+
+// Wrap wraps a in a type-safe wrapper struct to ensure that only the desired
+// Admin methods are exported to receive RPCs. The storage server should
+// register it under the name "Admin", alongside "StorageServer":
+//
+//	rpc.RegisterName("Admin", adminrpc.Wrap(storageServer))
+func Wrap(a RemoteAdmin) RemoteAdmin {
+	return &Admin{a}
+}
@@ -0,0 +1,42 @@
+// This file contains the arguments used to perform RPCs between the members
+// of a Raft group.
+
+package raftrpc
+
+// Entry is one entry of a Raft log.
+type Entry struct {
+	Term    uint64
+	Command []byte // Nil for the no-op a leader appends when elected.
+}
+
+type RequestVoteArgs struct {
+	Group        string // The Raft group the candidate belongs to.
+	Term         uint64
+	CandidateID  string // The candidate's host:port.
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+type RequestVoteReply struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+type AppendEntriesArgs struct {
+	Group        string // The Raft group the leader belongs to.
+	Term         uint64
+	LeaderID     string // The leader's host:port.
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+type AppendEntriesReply struct {
+	Term    uint64
+	Success bool
+
+	// On failure, the index the leader should try next, which skips over
+	// the whole of the conflicting term rather than one entry at a time.
+	ConflictIndex uint64
+}
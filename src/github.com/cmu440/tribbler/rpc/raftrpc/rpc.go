@@ -0,0 +1,24 @@
+// This file provides a type-safe wrapper that should be used to register a
+// storage server to receive RPCs from the other members of its Raft groups.
+
+package raftrpc
+
+type RemoteRaft interface {
+	RequestVote(*RequestVoteArgs, *RequestVoteReply) error
+	AppendEntries(*AppendEntriesArgs, *AppendEntriesReply) error
+}
+
+type Raft struct {
+	// Embed all methods into the struct. See the Effective Go section about
+	// embedding for more details: golang.org/doc/effective_go.html#embedding
+	RemoteRaft
+}
+
+// Wrap wraps r in a type-safe wrapper struct to ensure that only the desired
+// Raft methods are exported to receive RPCs. The storage server should
+// register it under the name "Raft":
+//
+//	rpc.RegisterName("Raft", raftrpc.Wrap(host))
+func Wrap(r RemoteRaft) RemoteRaft {
+	return &Raft{r}
+}
@@ -3,18 +3,71 @@
 
 package storagerpc
 
+import "fmt"
+
 // Status represents the status of a RPC's reply.
 type Status int
 
 const (
-	OK           Status = iota + 1 // The RPC was a success.
-	KeyNotFound                    // The specified key does not exist.
-	ItemNotFound                   // The specified item does not exist.
-	WrongServer                    // The specified key does not fall in the server's hash range.
-	ItemExists                     // The item already exists in the list.
-	NotReady                       // The storage servers are still getting ready.
+	OK               Status = iota + 1 // The RPC was a success.
+	KeyNotFound                        // The specified key does not exist.
+	ItemNotFound                       // The specified item does not exist.
+	WrongServer                        // The specified key does not fall in the server's hash range.
+	ItemExists                         // The item already exists in the list.
+	NotReady                           // The storage servers are still getting ready.
+	WrongPartitioner                   // The registering server uses a different partitioner than the ring.
+	Unavailable                        // Too few replicas answered to satisfy the requested consistency.
+	NotLeader                          // The server doesn't lead the key's Raft group; see the reply's Leader.
 )
 
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case KeyNotFound:
+		return "KeyNotFound"
+	case ItemNotFound:
+		return "ItemNotFound"
+	case WrongServer:
+		return "WrongServer"
+	case ItemExists:
+		return "ItemExists"
+	case NotReady:
+		return "NotReady"
+	case WrongPartitioner:
+		return "WrongPartitioner"
+	case Unavailable:
+		return "Unavailable"
+	case NotLeader:
+		return "NotLeader"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// Consistency is the number of a key's replicas that must take part in a read
+// or write before it completes. The zero value is ConsistencyOne.
+type Consistency int
+
+const (
+	ConsistencyOne    Consistency = iota // A single replica (for writes, the primary).
+	ConsistencyQuorum                    // A majority of the replicas.
+	ConsistencyAll                       // Every replica.
+)
+
+// Required returns the number of the given number of replicas that must take
+// part in an operation at consistency c.
+func (c Consistency) Required(replicas int) int {
+	switch c {
+	case ConsistencyQuorum:
+		return replicas/2 + 1
+	case ConsistencyAll:
+		return replicas
+	default:
+		return 1
+	}
+}
+
 // Lease constants.
 const (
 	QueryCacheSeconds = 10 // Time period used for tracking queries/determining whether to request leases.
@@ -35,7 +88,8 @@ type Node struct {
 }
 
 type RegisterArgs struct {
-	ServerInfo Node
+	ServerInfo  Node
+	Partitioner string // Name of the registering server's partitioner ("" for the default).
 }
 
 type RegisterReply struct {
@@ -43,48 +97,85 @@ type RegisterReply struct {
 	Servers []Node
 }
 
+type UnregisterArgs struct {
+	ServerInfo Node
+}
+
+type UnregisterReply struct {
+	Status Status
+}
+
+type SetServersArgs struct {
+	Servers []Node
+}
+
+type SetServersReply struct {
+	Status Status
+}
+
 type GetServersArgs struct {
 	// Intentionally left empty.
 }
 
 type GetServersReply struct {
-	Status  Status
-	Servers []Node
+	Status      Status
+	Servers     []Node
+	Partitioner string            // Name of the ring's partitioner ("" for the default).
+	Assignments []RangeAssignment // Ranges moved off their ring owners, oldest first.
 }
 
 type GetArgs struct {
-	Key       string
-	WantLease bool
-	HostPort  string // The Libstore's callback host:port.
+	Key         string
+	WantLease   bool
+	HostPort    string // The Libstore's callback host:port.
+	Consistency Consistency
 }
 
 type GetReply struct {
-	Status Status
-	Value  string
-	Lease  Lease
+	Status  Status
+	Value   string
+	Lease   Lease
+	Version uint64 // Version of the value returned; higher is newer.
+	Leader  string // With status NotLeader, the host:port of the key's leader, if known.
+	Deleted bool   // With status KeyNotFound, the key was deleted at Version.
 }
 
 type GetListReply struct {
-	Status Status
-	Value  []string
-	Lease  Lease
+	Status  Status
+	Value   []string
+	Lease   Lease
+	Version uint64 // Version of the list returned; higher is newer.
+	Leader  string // With status NotLeader, the host:port of the key's leader, if known.
+	Deleted bool   // With status KeyNotFound, the key was deleted at Version.
 }
 
 type PutArgs struct {
-	Key   string
-	Value string
+	Key         string
+	Value       string
+	Consistency Consistency
 }
 
 type PutReply struct {
 	Status Status
+	Leader string // With status NotLeader, the host:port of the key's leader, if known.
 }
 
 type DeleteArgs struct {
-	Key string
+	Key         string
+	Consistency Consistency
 }
 
 type DeleteReply struct {
 	Status Status
+	Leader string // With status NotLeader, the host:port of the key's leader, if known.
+}
+
+type ReleaseLeasesArgs struct {
+	HostPort string // The callback host:port of the Libstore giving up its leases.
+}
+
+type ReleaseLeasesReply struct {
+	Status Status
 }
 
 type RevokeLeaseArgs struct {
@@ -94,3 +185,138 @@ type RevokeLeaseArgs struct {
 type RevokeLeaseReply struct {
 	Status Status
 }
+
+// WriteOp identifies the kind of a write within a batch.
+type WriteOp int
+
+const (
+	PutOp            WriteOp = iota + 1 // Put Value at Key.
+	DeleteOp                            // Delete Key (Value is ignored).
+	AppendToListOp                      // Append Value to the list at Key.
+	RemoveFromListOp                    // Remove Value from the list at Key.
+)
+
+// Write is a single write within a batch.
+type Write struct {
+	Op          WriteOp
+	Key         string
+	Value       string
+	Consistency Consistency
+}
+
+type BatchArgs struct {
+	Writes []Write
+}
+
+type BatchReply struct {
+	Statuses []Status // One per write, in the order the writes were given.
+}
+
+// HashRange is the set of hashes h with Start < h <= End. If Start >= End the
+// range wraps around the ring (and if Start == End it covers the whole ring).
+type HashRange struct {
+	Start uint32
+	End   uint32
+}
+
+// Contains reports whether h falls within r.
+func (r HashRange) Contains(h uint32) bool {
+	if r.Start < r.End {
+		return r.Start < h && h <= r.End
+	}
+	return r.Start < h || h <= r.End
+}
+
+// Overlaps reports whether r and o have any hash in common.
+func (r HashRange) Overlaps(o HashRange) bool {
+	return r.Contains(o.End) || o.Contains(r.End)
+}
+
+// RangeAssignment records that a hash range has been moved with TransferRange
+// to a node other than the one the ring assigns it to. Where assignments
+// overlap, the later one holds.
+type RangeAssignment struct {
+	Range HashRange
+	Owner Node
+}
+
+type AssignRangeArgs struct {
+	Assignment RangeAssignment
+}
+
+type AssignRangeReply struct {
+	Status Status
+}
+
+type TransferRangeArgs struct {
+	Range  HashRange
+	Target Node // The node to move the range to.
+}
+
+type TransferRangeReply struct {
+	Status Status
+	Keys   int // Number of keys (values and lists) moved.
+}
+
+type ReceiveRangeArgs struct {
+	Range  HashRange
+	Values map[string]string
+	Lists  map[string][]string
+	Done   bool // Set on the last chunk, once the master routes Range to the receiver.
+	Abort  bool // Set to drop the chunks of Range received so far, instead of sending more.
+}
+
+type ReceiveRangeReply struct {
+	Status Status
+}
+
+type MerkleTreeArgs struct {
+	Range HashRange
+	Depth int // Number of levels below the root to return.
+}
+
+type MerkleTreeReply struct {
+	Status Status
+	Nodes  []uint64 // The tree's hashes in breadth-first order, root first.
+}
+
+type KeyDigestsArgs struct {
+	Ranges []HashRange
+}
+
+type KeyDigestsReply struct {
+	Status   Status
+	Digests  map[string]uint64 // Digest of each key's value, list or tombstone, by key.
+	Versions map[string]uint64 // Version of each key in Digests.
+}
+
+type RepairKeysArgs struct {
+	Values   map[string]string
+	Lists    map[string][]string
+	Deleted  []string          // Keys to remove, whether they hold values or lists.
+	Versions map[string]uint64 // If set, a key is only changed if its version here is at least as high.
+	Fetch    []string          // Keys whose state to return in the reply.
+}
+
+type RepairKeysReply struct {
+	Status   Status
+	Values   map[string]string // The state of the keys in Fetch, as in RepairKeysArgs.
+	Lists    map[string][]string
+	Deleted  []string
+	Versions map[string]uint64
+}
+
+// ReplicateArgs carries the state of a key from its primary to a replica
+// after a write, or after a read found the replica out of date.
+type ReplicateArgs struct {
+	Key     string
+	Value   string
+	List    []string
+	IsList  bool
+	Deleted bool   // The key has been deleted; Value and List are ignored.
+	Version uint64 // The replica keeps whichever state has the higher version.
+}
+
+type ReplicateReply struct {
+	Status Status
+}
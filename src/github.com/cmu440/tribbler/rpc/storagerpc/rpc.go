@@ -5,6 +5,8 @@ package storagerpc
 
 type RemoteStorageServer interface {
 	RegisterServer(*RegisterArgs, *RegisterReply) error
+	UnregisterServer(*UnregisterArgs, *UnregisterReply) error
+	SetServers(*SetServersArgs, *SetServersReply) error
 	GetServers(*GetServersArgs, *GetServersReply) error
 	Get(*GetArgs, *GetReply) error
 	GetList(*GetArgs, *GetListReply) error
@@ -12,6 +14,15 @@ type RemoteStorageServer interface {
 	Delete(*DeleteArgs, *DeleteReply) error
 	AppendToList(*PutArgs, *PutReply) error
 	RemoveFromList(*PutArgs, *PutReply) error
+	Batch(*BatchArgs, *BatchReply) error
+	ReleaseLeases(*ReleaseLeasesArgs, *ReleaseLeasesReply) error
+	TransferRange(*TransferRangeArgs, *TransferRangeReply) error
+	ReceiveRange(*ReceiveRangeArgs, *ReceiveRangeReply) error
+	MerkleTree(*MerkleTreeArgs, *MerkleTreeReply) error
+	KeyDigests(*KeyDigestsArgs, *KeyDigestsReply) error
+	RepairKeys(*RepairKeysArgs, *RepairKeysReply) error
+	Replicate(*ReplicateArgs, *ReplicateReply) error
+	AssignRange(*AssignRangeArgs, *AssignRangeReply) error
 }
 
 type StorageServer struct {
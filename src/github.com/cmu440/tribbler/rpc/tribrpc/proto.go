@@ -3,7 +3,10 @@
 
 package tribrpc
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Status represents the status of a RPC's reply.
 type Status int
@@ -14,8 +17,28 @@ const (
 	NoSuchPost                         // The specified PostKey does not exist.
 	NoSuchTargetUser                   // The specified TargerUserID does not exist.
 	Exists                             // The specified UserID or TargerUserID already exists.
+	BadCursor                          // The specified Cursor was not returned as a NextCursor.
 )
 
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case NoSuchUser:
+		return "NoSuchUser"
+	case NoSuchPost:
+		return "NoSuchPost"
+	case NoSuchTargetUser:
+		return "NoSuchTargetUser"
+	case Exists:
+		return "Exists"
+	case BadCursor:
+		return "BadCursor"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
 // Tribble stores the contents and information identifying a unique
 // tribble message.
 type Tribble struct {
@@ -68,11 +91,26 @@ type GetFriendsReply struct {
 	Status  Status
 	UserIDs []string
 }
+
+// MaxPageSize is the most tribbles a single GetTribbles or
+// GetTribblesBySubscription returns.
+const MaxPageSize = 100
+
 type GetTribblesArgs struct {
 	UserID string
+
+	// Cursor continues a listing from the NextCursor of the previous page.
+	// An empty Cursor starts from the most recent tribble.
+	Cursor string
+
+	PageSize int // Number of tribbles to return; 0 (or more than MaxPageSize) means MaxPageSize.
 }
 
 type GetTribblesReply struct {
 	Status   Status
 	Tribbles []Tribble
+
+	// NextCursor is the Cursor for the page of older tribbles that follows
+	// this one, or empty if there are no older tribbles.
+	NextCursor string
 }
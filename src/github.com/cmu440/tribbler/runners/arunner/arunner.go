@@ -0,0 +1,121 @@
+// A program that inspects a running storage server through its Admin service.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/rpc"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+)
+
+var top = flag.Int("n", 10, "number of hot prefixes or write queues to show")
+
+func init() {
+	log.SetFlags(log.Lshortfile | log.Lmicroseconds)
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, "The arunner program prints statistics from a running storage server.\n\n")
+		fmt.Fprintln(os.Stderr, "Usage: arunner [flags] <storage server host:port> [command]")
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Possible commands:")
+		fmt.Fprintln(os.Stderr, "  stats        storage, leases and per-method requests (default)")
+		fmt.Fprintln(os.Stderr, "  hot          busiest key prefixes")
+		fmt.Fprintln(os.Stderr, "  revocations  lease revocation statistics")
+		fmt.Fprintln(os.Stderr, "  queues       deepest write queues")
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	cmd := "stats"
+	if flag.NArg() > 1 {
+		cmd = flag.Arg(1)
+	}
+	cli, err := rpc.DialHTTP("tcp", flag.Arg(0))
+	if err != nil {
+		log.Fatalln("Failed to connect to storage server:", err)
+	}
+	defer cli.Close()
+
+	switch cmd {
+	case "stats":
+		var reply adminrpc.StatsReply
+		call(cli, "Admin.Stats", &adminrpc.StatsArgs{}, &reply)
+		printStats(&reply)
+	case "hot":
+		var reply adminrpc.HotPrefixesReply
+		call(cli, "Admin.HotPrefixes", &adminrpc.HotPrefixesArgs{N: *top}, &reply)
+		for _, p := range reply.Prefixes {
+			fmt.Printf("%-30s %8.2f req/s\n", p.Prefix, p.Rate)
+		}
+	case "revocations":
+		var reply adminrpc.RevocationStatsReply
+		call(cli, "Admin.RevocationStats", &adminrpc.RevocationStatsArgs{}, &reply)
+		s := reply.Stats
+		fmt.Printf("revocations: %d (acked %d, waited out %d)\n", s.Revocations, s.Acked, s.WaitedOut)
+		if s.Revocations > 0 {
+			fmt.Printf("latency: mean %v, max %v\n", s.TotalLatency/time.Duration(s.Revocations), s.MaxLatency)
+		}
+	case "queues":
+		var reply adminrpc.WriteQueuesReply
+		call(cli, "Admin.WriteQueues", &adminrpc.WriteQueuesArgs{}, &reply)
+		for i, q := range reply.Queues {
+			if i == *top {
+				break
+			}
+			fmt.Printf("%-40s %d\n", q.Key, q.Depth)
+		}
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func call(cli *rpc.Client, method string, args, reply interface{}) {
+	if err := cli.Call(method, args, reply); err != nil {
+		log.Fatalf("%s failed: %v", method, err)
+	}
+}
+
+func printStats(s *adminrpc.StatsReply) {
+	fmt.Printf("keys: %d, lists: %d, bytes: %d\n", s.Keys, s.Lists, s.Bytes)
+	ranges := make([]string, len(s.Ranges))
+	for i, r := range s.Ranges {
+		ranges[i] = fmt.Sprintf("(%d, %d]", r.Start, r.End)
+	}
+	fmt.Printf("ranges: %s\n", strings.Join(ranges, " "))
+	fmt.Printf("leased keys: %d, pending revocations: %d\n", len(s.Leases), s.PendingRevocations)
+	for i, l := range s.Leases {
+		if i == *top {
+			break
+		}
+		fmt.Printf("  %-40s %d holders\n", l.Key, l.Holders)
+	}
+
+	fmt.Printf("\n%-16s %10s %12s", "method", "requests", "mean")
+	for _, b := range adminrpc.LatencyBuckets {
+		fmt.Printf(" %8s", "<="+b.String())
+	}
+	fmt.Printf(" %8s\n", "more")
+	for _, m := range s.Methods {
+		mean := "-"
+		if m.Requests > 0 {
+			mean = (m.Latency.Sum / time.Duration(m.Requests)).String()
+		}
+		fmt.Printf("%-16s %10d %12s", m.Method, m.Requests, mean)
+		for _, n := range m.Latency.Counts {
+			fmt.Printf(" %8d", n)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,58 @@
+// A program that rebalances load across a running ring of storage servers.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/cmu440/tribbler/rebalancer"
+)
+
+var (
+	dryRun    = flag.Bool("dry", true, "only print the planned moves, without executing them")
+	metric    = flag.String("metric", "requests", "load to equalize: requests, keys or bytes")
+	buckets   = flag.Int("buckets", 16, "number of buckets to split each node's range into")
+	tolerance = flag.Float64("tolerance", 0.1, "acceptable load above the mean, as a fraction of the mean")
+	maxMoves  = flag.Int("moves", 8, "maximum number of ranges to move")
+)
+
+func init() {
+	log.SetFlags(log.Lshortfile | log.Lmicroseconds)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 {
+		log.Fatalln("Usage: rrunner <master storage server host:port>")
+	}
+	m, err := rebalancer.ParseMetric(*metric)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	loads, err := rebalancer.Collect(flag.Arg(0), *buckets)
+	if err != nil {
+		log.Fatalln("Failed to collect load:", err)
+	}
+	for _, l := range loads {
+		fmt.Printf("%s: %.2f\n", l.Node.HostPort, l.Total(m))
+	}
+
+	moves := rebalancer.Plan(loads, m, *tolerance, *maxMoves)
+	if len(moves) == 0 {
+		fmt.Println("Load is balanced; nothing to do.")
+		return
+	}
+	for _, mv := range moves {
+		fmt.Println(mv)
+	}
+	if *dryRun {
+		return
+	}
+	if err := rebalancer.Execute(moves); err != nil {
+		log.Fatalln("Rebalancing failed:", err)
+	}
+	fmt.Println("Done.")
+}
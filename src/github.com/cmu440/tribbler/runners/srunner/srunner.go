@@ -9,7 +9,12 @@ import (
 	"math"
 	"math/big"
 	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/cmu440/tribbler/libstore"
 	"github.com/cmu440/tribbler/storageserver"
 )
 
@@ -20,6 +25,13 @@ var (
 	masterHostPort = flag.String("master", "", "master storage server host port (if non-empty then this storage server is a slave)")
 	numNodes       = flag.Int("N", 1, "the number of nodes in the ring (including the master)")
 	nodeID         = flag.Uint("id", 0, "a 32-bit unsigned node ID to use for consistent hashing")
+	leaseJournal   = flag.String("journal", "", "file in which to record granted leases, so that restarts honor them (default: tribbler-leases-<port> in the temporary directory; \"none\" to keep no journal and refuse writes for a lease period after starting)")
+	partitioner    = flag.String("partitioner", libstore.PrefixPartitionerName, "how keys are assigned to nodes (fnv32-prefix, fnv32-key, jump-prefix or fnv32-split:<user>,...); must match the rest of the ring")
+	replication    = flag.Int("replication", 1, "the number of servers storing each key (not with jump-prefix)")
+	useRaft        = flag.Bool("raft", false, "replicate each range with a Raft group instead of primary-backup (not with jump-prefix)")
+	raftDir        = flag.String("raftdir", "", "directory in which Raft groups persist their state (default: memory only)")
+	antiEntropy    = flag.Duration("antientropy", time.Minute, "how often to compare and repair replicas (0 to disable)")
+	drainTimeout   = flag.Duration("drain", 30*time.Second, "how long to wait for requests in progress when shutting down")
 )
 
 func init() {
@@ -41,12 +53,40 @@ func main() {
 		randID = rand.Uint32()
 	}
 
+	p, ok := libstore.LookupPartitioner(*partitioner)
+	if !ok {
+		log.Fatalln("Unknown partitioner:", *partitioner)
+	}
+
 	// Create and start the StorageServer.
-	_, err := storageserver.NewStorageServer(*masterHostPort, *numNodes, *port, randID)
+	opts := []storageserver.Option{
+		storageserver.WithPartitioner(p),
+		storageserver.WithReplication(*replication),
+		storageserver.WithAntiEntropy(*antiEntropy),
+		storageserver.WithDrainTimeout(*drainTimeout),
+	}
+	if *useRaft {
+		opts = append(opts, storageserver.WithRaft())
+		if *raftDir != "" {
+			opts = append(opts, storageserver.WithRaftDir(*raftDir))
+		}
+	}
+	if *leaseJournal == "none" {
+		opts = append(opts, storageserver.WithoutLeaseJournal())
+	} else if *leaseJournal != "" {
+		opts = append(opts, storageserver.WithLeaseJournal(*leaseJournal))
+	}
+	ss, err := storageserver.NewStorageServer(*masterHostPort, *numNodes, *port, randID, opts...)
 	if err != nil {
 		log.Fatalln("Failed to create storage server:", err)
 	}
 
-	// Run the storage server forever.
-	select {}
+	// Run the storage server until interrupted, then shut it down cleanly.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	log.Printf("Received %v; draining requests in progress...", sig)
+	if err := ss.Close(); err != nil {
+		log.Fatalln("Failed to shut down cleanly:", err)
+	}
 }
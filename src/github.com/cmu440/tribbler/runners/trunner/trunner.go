@@ -6,12 +6,19 @@ import (
 	"flag"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 
+	"github.com/cmu440/tribbler/libstore"
 	"github.com/cmu440/tribbler/tribserver"
 )
 
-var port = flag.Int("port", 9010, "port number to listen on")
+var (
+	port       = flag.Int("port", 9010, "port number to listen on")
+	staleGrace = flag.Duration("stalegrace", 0, "how long after their leases expire to serve cached values while a storage server is unreachable (0 disables)")
+)
 
 func init() {
 	log.SetFlags(log.Lshortfile | log.Lmicroseconds)
@@ -25,11 +32,17 @@ func main() {
 
 	// Create and start the TribServer.
 	hostPort := net.JoinHostPort("localhost", strconv.Itoa(*port))
-	_, err := tribserver.NewTribServer(flag.Arg(0), hostPort)
+	ts, err := tribserver.NewTribServer(flag.Arg(0), hostPort, libstore.WithStaleGrace(*staleGrace))
 	if err != nil {
 		log.Fatalln("Server could not be created:", err)
 	}
 
-	// Run the Tribbler server forever.
-	select {}
+	// Run the Tribbler server until terminated, then shut it down cleanly.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	log.Printf("Received %v; shutting down...", sig)
+	if err := ts.Close(); err != nil {
+		log.Fatalln("Failed to shut down cleanly:", err)
+	}
 }
@@ -0,0 +1,270 @@
+package storageserver
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// defaultAntiEntropyInterval is how often replicas are compared by default.
+const defaultAntiEntropyInterval = time.Minute
+
+// WithReplication makes every key stored on n servers: the key's owner (its
+// primary) and the n-1 servers that follow it in order of NodeID, as chosen
+// by libstore.Replicas. Writes are applied by the primary and forwarded to
+// the replicas. The default is 1, i.e. no replication.
+func WithReplication(n int) Option {
+	return func(ss *storageServer) {
+		ss.replication = n
+	}
+}
+
+// WithAntiEntropy sets how often the server compares each range it is the
+// primary of with the range's replicas and repairs any that have diverged.
+// An interval of zero disables the background process, leaving repairs to
+// the Admin service's Repair RPC.
+func WithAntiEntropy(interval time.Duration) Option {
+	return func(ss *storageServer) {
+		ss.entropy.interval = interval
+	}
+}
+
+// antiEntropy keeps the outcome of the most recent comparison of each of the
+// server's ranges with each of its replicas.
+type antiEntropy struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]adminrpc.RangeDivergence // By replica host:port and range.
+}
+
+func newAntiEntropy() antiEntropy {
+	return antiEntropy{
+		interval: defaultAntiEntropyInterval,
+		last:     make(map[string]adminrpc.RangeDivergence),
+	}
+}
+
+// record stores the outcome of a comparison, replacing any earlier one for
+// the same replica and range.
+func (a *antiEntropy) record(d adminrpc.RangeDivergence) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last[fmt.Sprintf("%s/%d-%d", d.Replica, d.Range.Start, d.Range.End)] = d
+}
+
+// snapshot returns the most recent outcome for every replica and range.
+func (a *antiEntropy) snapshot() []adminrpc.RangeDivergence {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ds := make([]adminrpc.RangeDivergence, 0, len(a.last))
+	for _, d := range a.last {
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+// runAntiEntropy repairs this server's replicas, and forgets old tombstones,
+// every ss.entropy.interval until the server is closed.
+func (ss *storageServer) runAntiEntropy() {
+	ticker := time.NewTicker(ss.entropy.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ss.repairAll(false)
+			ss.sweepTombstones(now.Add(-tombstoneLifetime))
+		case <-ss.stop:
+			return
+		}
+	}
+}
+
+// repairAll compares every range this server is the primary of with each of
+// the range's replicas and, unless dryRun is set, repairs them. Each outcome
+// is recorded in ss.entropy and returned.
+func (ss *storageServer) repairAll(dryRun bool) []adminrpc.RangeDivergence {
+	servers := ss.ring()
+	self := storagerpc.Node{HostPort: ss.hostPort, NodeID: ss.nodeID}
+	var ds []adminrpc.RangeDivergence
+	for _, r := range ss.ownedRanges() {
+		for _, node := range rangeMembers(self, servers, ss.replication)[1:] {
+			var d adminrpc.RangeDivergence
+			if cli, err := ss.peers.get(node.HostPort); err != nil {
+				d = adminrpc.RangeDivergence{Range: r, Replica: node.HostPort, Checked: time.Now(), Err: err.Error()}
+			} else {
+				d = ss.repairReplica(cli, node.HostPort, r, dryRun)
+			}
+			ss.entropy.record(d)
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// repairReplica compares this server's copy of range r with the copy on the
+// replica at hostPort and, unless dryRun is set, brings both up to date:
+// each key that differs is resolved in favor of the copy with the higher
+// version, the primary's copy winning ties. Deletes are propagated only as
+// tombstones; a key missing from one copy is never taken to have been
+// deleted, but to have been missed.
+//
+// The trees' roots are compared first, so that replicas that agree cost a
+// single small RPC. Otherwise the full trees are compared to find the leaves
+// that differ, the digests of the keys under those leaves are compared, and
+// only the keys that differ are sent or fetched, in chunks of at most
+// maxTransferChunk keys.
+func (ss *storageServer) repairReplica(replica *rpc.Client, hostPort string, r storagerpc.HashRange, dryRun bool) adminrpc.RangeDivergence {
+	d := adminrpc.RangeDivergence{Range: r, Replica: hostPort, Checked: time.Now()}
+	fail := func(err error) adminrpc.RangeDivergence {
+		d.Err = err.Error()
+		return d
+	}
+	hash := ss.partitioner.Hash
+	records := ss.records(r)
+
+	local := buildMerkleTree(r, hash, records)
+	var root storagerpc.MerkleTreeReply
+	if err := callStatus(replica, "StorageServer.MerkleTree", &storagerpc.MerkleTreeArgs{Range: r}, &root, &root.Status); err != nil {
+		return fail(err)
+	}
+	if len(root.Nodes) == 1 && root.Nodes[0] == local.nodes[0] {
+		return d
+	}
+
+	var tree storagerpc.MerkleTreeReply
+	args := &storagerpc.MerkleTreeArgs{Range: r, Depth: local.depth}
+	if err := callStatus(replica, "StorageServer.MerkleTree", args, &tree, &tree.Status); err != nil {
+		return fail(err)
+	}
+	leaves := local.diff(tree.Nodes)
+	d.Leaves = len(leaves)
+	if len(leaves) == 0 {
+		return d
+	}
+
+	ranges := make([]storagerpc.HashRange, len(leaves))
+	for i, leaf := range leaves {
+		ranges[i] = local.leafRange(leaf)
+	}
+	var remote storagerpc.KeyDigestsReply
+	if err := callStatus(replica, "StorageServer.KeyDigests", &storagerpc.KeyDigestsArgs{Ranges: ranges}, &remote, &remote.Status); err != nil {
+		return fail(err)
+	}
+	mine, versions := keyDigests(ranges, hash, records)
+
+	fix := &storagerpc.RepairKeysArgs{Versions: make(map[string]uint64)}
+	n := 0
+	send := func() error {
+		if n == 0 || dryRun {
+			return nil
+		}
+		var reply storagerpc.RepairKeysReply
+		if err := callStatus(replica, "StorageServer.RepairKeys", fix, &reply, &reply.Status); err != nil {
+			return err
+		}
+		ss.adoptRepairs(&reply)
+		fix, n = &storagerpc.RepairKeysArgs{Versions: make(map[string]uint64)}, 0
+		return nil
+	}
+	resolve := func(key string) error {
+		d.Repaired++
+		if remote.Versions[key] > versions[key] {
+			fix.Fetch = append(fix.Fetch, key)
+		} else {
+			addRepair(fix, key, records[key])
+		}
+		if n++; n == maxTransferChunk {
+			return send()
+		}
+		return nil
+	}
+
+	for key, digest := range mine {
+		if theirs, ok := remote.Digests[key]; !ok || theirs != digest {
+			if err := resolve(key); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	for key := range remote.Digests {
+		if _, ok := mine[key]; !ok {
+			if err := resolve(key); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := send(); err != nil {
+		return fail(err)
+	}
+	return d
+}
+
+// addRepair adds key, holding rec, to the keys a RepairKeys call carries.
+func addRepair(fix *storagerpc.RepairKeysArgs, key string, rec *record) {
+	switch {
+	case rec.deleted:
+		fix.Deleted = append(fix.Deleted, key)
+	case rec.isList:
+		if fix.Lists == nil {
+			fix.Lists = make(map[string][]string)
+		}
+		fix.Lists[key] = rec.list
+	default:
+		if fix.Values == nil {
+			fix.Values = make(map[string]string)
+		}
+		fix.Values[key] = rec.value
+	}
+	fix.Versions[key] = rec.version
+}
+
+// adoptRepairs stores the newer states of keys fetched from a replica.
+func (ss *storageServer) adoptRepairs(reply *storagerpc.RepairKeysReply) {
+	for key, value := range reply.Values {
+		ss.adopt(key, record{value: value, version: reply.Versions[key]})
+	}
+	for key, list := range reply.Lists {
+		ss.adopt(key, record{list: list, isList: true, version: reply.Versions[key]})
+	}
+	for _, key := range reply.Deleted {
+		ss.adopt(key, record{deleted: true, version: reply.Versions[key]})
+	}
+}
+
+// adopt stores rec, a newer state of key fetched from a replica, unless the
+// key has caught up with it meanwhile. It goes through the key's write queue
+// and revokes the key's leases first, as a write would, since libstores may
+// be caching the state it replaces.
+func (ss *storageServer) adopt(key string, rec record) {
+	ss.writes.acquire(key)
+	defer ss.writes.release(key)
+	ss.mu.Lock()
+	stale := rec.version > ss.version(key)
+	ss.mu.Unlock()
+	if !stale {
+		return
+	}
+	ss.revokeLeases(key)
+	ss.mu.Lock()
+	if rec.version > ss.version(key) {
+		ss.put(key, rec, time.Now())
+	}
+	ss.mu.Unlock()
+}
+
+// callStatus calls method on cli and turns a reply status other than OK into
+// an error.
+func callStatus(cli *rpc.Client, method string, args, reply interface{}, status *storagerpc.Status) error {
+	if err := cli.Call(method, args, reply); err != nil {
+		return err
+	}
+	if *status != storagerpc.OK {
+		return fmt.Errorf("%s failed with status %v", method, *status)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package storageserver
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startReplicatedPair starts a ring of two servers that replicate each
+// other's keys, with background anti-entropy disabled.
+func startReplicatedPair(t *testing.T) (a, b *storageServer) {
+	return startPair(t, WithReplication(2), WithAntiEntropy(0))
+}
+
+// startPair starts a ring of two servers, a the master and b the slave,
+// created with the extra options, and closes them when the test is done.
+func startPair(t *testing.T, extra ...Option) (a, b *storageServer) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	dir := t.TempDir()
+	opts := func(name string) []Option {
+		return append([]Option{WithLeaseJournal(filepath.Join(dir, name))}, extra...)
+	}
+	started := make(chan StorageServer)
+	errs := make(chan error, 1)
+	go func() {
+		ss, err := NewStorageServer("", 2, port, 1<<30, opts("a")...)
+		if err != nil {
+			errs <- err
+			return
+		}
+		started <- ss
+	}()
+	master := fmt.Sprintf("localhost:%d", port)
+	var slave StorageServer
+	for deadline := time.Now().Add(5 * time.Second); slave == nil; {
+		if slave, err = NewStorageServer(master, 2, 0, 3<<30, opts("b")...); err != nil && time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case ss := <-started:
+		a = ss.(*storageServer)
+	case err := <-errs:
+		t.Fatal(err)
+	}
+	b = slave.(*storageServer)
+	t.Cleanup(func() {
+		b.Close()
+		a.Close()
+	})
+	return a, b
+}
+
+func TestRepairReplicaByVersion(t *testing.T) {
+	a, b := startReplicatedPair(t)
+	r := a.ringRange(a.nodeID, a.ring())
+
+	// Pick keys that a is the primary of.
+	var keys []string
+	for i := 0; len(keys) < 4; i++ {
+		if key := fmt.Sprintf("user%d:post", i); r.Contains(a.partitioner.Hash(key)) {
+			keys = append(keys, key)
+		}
+	}
+	deleted, missed, newer, older := keys[0], keys[1], keys[2], keys[3]
+	a.mu.Lock()
+	b.mu.Lock()
+	a.store[deleted] = &record{deleted: true, version: 5}
+	b.store[deleted] = &record{value: "old", version: 3}
+	b.store[missed] = &record{value: "b only", version: 2}
+	a.store[newer] = &record{value: "stale", version: 1}
+	b.store[newer] = &record{value: "fresh", version: 4}
+	a.store[older] = &record{list: []string{"fresh"}, isList: true, version: 6}
+	b.store[older] = &record{list: []string{"stale"}, isList: true, version: 2}
+	b.mu.Unlock()
+	a.mu.Unlock()
+
+	cli, err := a.peers.get(b.hostPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := a.repairReplica(cli, b.hostPort, r, false); d.Err != "" || d.Repaired != 4 {
+		t.Fatalf("repairReplica = %+v, want 4 keys repaired", d)
+	}
+
+	for _, ss := range []*storageServer{a, b} {
+		ss.mu.Lock()
+		if rec := ss.store[deleted]; rec == nil || !rec.deleted || rec.version != 5 {
+			t.Errorf("%s: %s = %+v, want the tombstone", ss.hostPort, deleted, rec)
+		}
+		if rec := ss.find(missed); rec == nil || rec.value != "b only" {
+			t.Errorf("%s: %s = %+v, want the value only b had", ss.hostPort, missed, rec)
+		}
+		if rec := ss.find(newer); rec == nil || rec.value != "fresh" || rec.version != 4 {
+			t.Errorf("%s: %s = %+v, want b's newer value", ss.hostPort, newer, rec)
+		}
+		if rec := ss.find(older); rec == nil || len(rec.list) != 1 || rec.list[0] != "fresh" {
+			t.Errorf("%s: %s = %+v, want a's newer list", ss.hostPort, older, rec)
+		}
+		ss.mu.Unlock()
+	}
+	if d := a.repairReplica(cli, b.hostPort, r, false); d.Err != "" || d.Leaves != 0 {
+		t.Errorf("second repairReplica = %+v, want the copies to agree", d)
+	}
+}
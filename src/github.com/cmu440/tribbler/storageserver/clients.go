@@ -0,0 +1,60 @@
+package storageserver
+
+import (
+	"net/rpc"
+	"sync"
+)
+
+// clientPool holds one connection to each of a set of servers, dialing them
+// on first use.
+type clientPool struct {
+	mu      sync.Mutex
+	clients map[string]*rpc.Client // By host:port.
+}
+
+func newClientPool() *clientPool {
+	return &clientPool{clients: make(map[string]*rpc.Client)}
+}
+
+// get returns a connection to the server at hostPort, dialing it if
+// necessary.
+func (p *clientPool) get(hostPort string) (*rpc.Client, error) {
+	p.mu.Lock()
+	cli, ok := p.clients[hostPort]
+	p.mu.Unlock()
+	if ok {
+		return cli, nil
+	}
+
+	cli, err := rpc.DialHTTP("tcp", hostPort)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing, ok := p.clients[hostPort]; ok {
+		cli.Close()
+		return existing, nil
+	}
+	p.clients[hostPort] = cli
+	return cli, nil
+}
+
+// peek returns the connection to the server at hostPort without dialing it,
+// or nil if there is none.
+func (p *clientPool) peek(hostPort string) *rpc.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.clients[hostPort]
+}
+
+// drop discards a connection that failed, so that the next call to the same
+// server redials it.
+func (p *clientPool) drop(hostPort string, cli *rpc.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.clients[hostPort] == cli {
+		delete(p.clients, hostPort)
+		cli.Close()
+	}
+}
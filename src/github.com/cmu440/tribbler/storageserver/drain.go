@@ -0,0 +1,180 @@
+package storageserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/libstore"
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// defaultDrainTimeout bounds how long Close waits for requests in progress.
+const defaultDrainTimeout = 30 * time.Second
+
+// WithDrainTimeout sets how long Close waits for requests in progress before
+// shutting the server down regardless. The default is 30 seconds.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(ss *storageServer) {
+		ss.drainTimeout = d
+	}
+}
+
+// drainGate counts the requests in progress and, once draining has begun,
+// turns new ones away.
+type drainGate struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	idle     chan struct{} // Closed when draining and active reaches zero.
+}
+
+func newDrainGate() *drainGate {
+	return &drainGate{idle: make(chan struct{})}
+}
+
+// enter admits a request, returning false if the server is draining. Every
+// admitted request must call leave when it completes.
+func (g *drainGate) enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// leave marks an admitted request as complete.
+func (g *drainGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active--; g.active == 0 && g.draining {
+		close(g.idle)
+	}
+}
+
+// started reports whether draining has begun.
+func (g *drainGate) started() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.draining
+}
+
+// drain stops admitting requests and waits until those in progress have
+// completed or ctx is done.
+func (g *drainGate) drain(ctx context.Context) error {
+	g.mu.Lock()
+	if !g.draining {
+		g.draining = true
+		if g.active == 0 {
+			close(g.idle)
+		}
+	}
+	g.mu.Unlock()
+	select {
+	case <-g.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (ss *storageServer) Drain(ctx context.Context) error {
+	return ss.gate.drain(ctx)
+}
+
+func (ss *storageServer) DrainServer(args *adminrpc.DrainServerArgs, reply *adminrpc.DrainServerReply) error {
+	defer ss.observeOK("DrainServer", time.Now())
+	ctx := context.Background()
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.Timeout)
+		defer cancel()
+	}
+	reply.Drained = ss.Drain(ctx) == nil
+	return nil
+}
+
+func (ss *storageServer) Close() error {
+	ss.closeOnce.Do(func() {
+		ss.closeErr = ss.shutdown()
+	})
+	return ss.closeErr
+}
+
+// shutdown does the work of Close.
+func (ss *storageServer) shutdown() error {
+	// Leave the ring first, so that libstores stop sending requests here
+	// while those in progress drain.
+	var err error
+	if ss.masterHostPort != "" {
+		err = ss.leave()
+	}
+	if !ss.gate.started() {
+		ctx, cancel := context.WithTimeout(context.Background(), ss.drainTimeout)
+		if derr := ss.Drain(ctx); err == nil {
+			err = derr
+		}
+		cancel()
+	}
+	close(ss.stop)
+
+	if ss.raftHost != nil {
+		ss.raftHost.StopAll()
+	}
+	if ss.journal != nil {
+		if jerr := ss.journal.close(); err == nil {
+			err = jerr
+		}
+	}
+	if ss.listener != nil {
+		if lerr := ss.listener.Close(); err == nil {
+			err = lerr
+		}
+	}
+	return err
+}
+
+// leave moves the ranges this server owns to its successor on the ring and
+// then removes it from the master's list of nodes, so that libstores stop
+// routing to it without any key changing owners unmoved. If a range can't be
+// moved the server stays on the ring. With Raft, whose groups are made up of
+// the nodes on the ring, or a partitioner that doesn't divide the ring into
+// ranges, the ring is left as it is.
+func (ss *storageServer) leave() error {
+	if _, ok := ss.partitioner.(libstore.RangePartitioner); !ok || ss.raftHost != nil {
+		return nil
+	}
+	self := storagerpc.Node{HostPort: ss.hostPort, NodeID: ss.nodeID}
+	members := rangeMembers(self, ss.ring(), 2)
+	if len(members) < 2 {
+		return nil
+	}
+	successor := members[1]
+	for _, r := range ss.ownedRanges() {
+		args := &storagerpc.TransferRangeArgs{Range: r, Target: successor}
+		var reply storagerpc.TransferRangeReply
+		if err := ss.transferRange(args, &reply); err != nil {
+			return err
+		}
+		if reply.Status != storagerpc.OK {
+			return fmt.Errorf("moving range %+v to %s failed with status %v", r, successor.HostPort, reply.Status)
+		}
+	}
+	return ss.unregister()
+}
+
+// unregister removes this server from the master's list of nodes, so that
+// libstores stop routing to it.
+func (ss *storageServer) unregister() error {
+	master, err := ss.peers.get(ss.masterHostPort)
+	if err != nil {
+		return err
+	}
+	args := &storagerpc.UnregisterArgs{ServerInfo: storagerpc.Node{HostPort: ss.hostPort, NodeID: ss.nodeID}}
+	var reply storagerpc.UnregisterReply
+	return callStatus(master, "StorageServer.UnregisterServer", args, &reply, &reply.Status)
+}
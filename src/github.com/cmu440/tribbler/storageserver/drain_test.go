@@ -0,0 +1,127 @@
+package storageserver
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/libstore"
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestDrainServer(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	ss, err := NewStorageServer("", 1, port, 1, WithLeaseJournal(filepath.Join(t.TempDir(), "leases")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	cli, err := rpc.DialHTTP("tcp", ss.(*storageServer).hostPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	var get storagerpc.GetReply
+	if err := cli.Call("StorageServer.Get", &storagerpc.GetArgs{Key: "k"}, &get); err != nil || get.Status != storagerpc.KeyNotFound {
+		t.Fatalf("Get before draining = %v, %v; want KeyNotFound", get.Status, err)
+	}
+	var drain adminrpc.DrainServerReply
+	if err := cli.Call("Admin.DrainServer", &adminrpc.DrainServerArgs{Timeout: time.Second}, &drain); err != nil || !drain.Drained {
+		t.Fatalf("DrainServer = %+v, %v; want drained", drain, err)
+	}
+	get = storagerpc.GetReply{}
+	if err := cli.Call("StorageServer.Get", &storagerpc.GetArgs{Key: "k"}, &get); err != nil || get.Status != storagerpc.NotReady {
+		t.Errorf("Get after draining = %v, %v; want NotReady", get.Status, err)
+	}
+}
+
+func TestCloseMovesRangesToSuccessor(t *testing.T) {
+	a, b := startPair(t)
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("user%d:post", i); b.ownsKey(k) {
+			key = k
+		}
+	}
+	var put storagerpc.PutReply
+	if err := b.Put(&storagerpc.PutArgs{Key: key, Value: "v"}, &put); err != nil || put.Status != storagerpc.OK {
+		t.Fatalf("Put = %v, %v; want OK", put.Status, err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("second Close = %v, want the first's nil", err)
+	}
+	if ring := a.ring(); len(ring) != 1 || ring[0].NodeID != a.nodeID {
+		t.Errorf("ring after Close = %+v, want only the master", ring)
+	}
+	var get storagerpc.GetReply
+	if err := a.Get(&storagerpc.GetArgs{Key: key}, &get); err != nil || get.Status != storagerpc.OK || get.Value != "v" {
+		t.Errorf("Get from the master = %v %q, %v; want OK \"v\"", get.Status, get.Value, err)
+	}
+}
+
+func TestLibstoreFollowsClosedOwner(t *testing.T) {
+	a, b := startPair(t)
+	ls, err := libstore.NewLibstore(a.hostPort, "", libstore.Never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("user%d:post", i); b.ownsKey(k) {
+			key = k
+		}
+	}
+	if err := ls.Put(key, "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The libstore still routes key to b, which answers NotReady while it
+	// drains and can't be reached once it has closed.
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if value, err := ls.Get(key); err != nil || value != "v" {
+		t.Errorf("Get after the owner closed = %q, %v; want \"v\", nil", value, err)
+	}
+	if err := ls.Put(key, "w"); err != nil {
+		t.Errorf("Put after the owner closed = %v", err)
+	}
+}
+
+func TestUnregisterServerUpdatesSlaves(t *testing.T) {
+	a, b := startPair(t)
+	gone := storagerpc.Node{HostPort: "localhost:1", NodeID: 2 << 30}
+	for _, ss := range []*storageServer{a, b} {
+		ss.mu.Lock()
+		ss.servers = append(append([]storagerpc.Node(nil), ss.servers...), gone)
+		ss.mu.Unlock()
+	}
+
+	var reply storagerpc.UnregisterReply
+	if err := a.UnregisterServer(&storagerpc.UnregisterArgs{ServerInfo: gone}, &reply); err != nil || reply.Status != storagerpc.OK {
+		t.Fatalf("UnregisterServer = %v, %v; want OK", reply.Status, err)
+	}
+	if ring := b.ring(); len(ring) != 2 {
+		t.Errorf("slave's ring after UnregisterServer = %+v, want the master and itself", ring)
+	}
+	for _, node := range b.ring() {
+		if node.HostPort == gone.HostPort {
+			t.Errorf("slave's ring still has %s", gone.HostPort)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package storageserver
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+)
+
+// Request rates are tracked over a sliding window of hotBuckets buckets, each
+// hotBucketWidth wide.
+const (
+	hotBucketWidth = time.Second
+	hotBuckets     = 10
+)
+
+// prefixCounter counts the requests made for one prefix in each bucket of the
+// window.
+type prefixCounter struct {
+	counts [hotBuckets]uint64
+	last   int64 // The most recent bucket that has been written.
+}
+
+// advance moves the window forward to bucket, clearing buckets that have
+// fallen out of it.
+func (c *prefixCounter) advance(bucket int64) {
+	if bucket <= c.last {
+		return
+	}
+	if bucket-c.last >= hotBuckets {
+		c.counts = [hotBuckets]uint64{}
+	} else {
+		for b := c.last + 1; b <= bucket; b++ {
+			c.counts[b%hotBuckets] = 0
+		}
+	}
+	c.last = bucket
+}
+
+func (c *prefixCounter) total() uint64 {
+	var n uint64
+	for _, count := range c.counts {
+		n += count
+	}
+	return n
+}
+
+// hotTracker tracks the request rate for each key prefix (i.e. each user) so
+// that operators can find users whose keys make a node a hotspot.
+type hotTracker struct {
+	mu        sync.Mutex
+	prefixes  map[string]*prefixCounter
+	lastPrune int64 // The bucket in which idle prefixes were last pruned.
+}
+
+func newHotTracker() *hotTracker {
+	return &hotTracker{prefixes: make(map[string]*prefixCounter)}
+}
+
+// record counts a request for key made at time now. Once per window, prefixes
+// that have seen no requests within it are forgotten.
+func (t *hotTracker) record(key string, now time.Time) {
+	prefix := strings.SplitN(key, ":", 2)[0]
+	bucket := now.UnixNano() / int64(hotBucketWidth)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if bucket-t.lastPrune >= hotBuckets {
+		t.prune(bucket)
+	}
+	c, ok := t.prefixes[prefix]
+	if !ok {
+		c = &prefixCounter{last: bucket}
+		t.prefixes[prefix] = c
+	}
+	c.advance(bucket)
+	c.counts[bucket%hotBuckets]++
+}
+
+// top returns the n prefixes with the highest request rates as of time now,
+// hottest first. Prefixes that have seen no requests within the window are
+// forgotten.
+func (t *hotTracker) top(n int, now time.Time) []adminrpc.PrefixRate {
+	bucket := now.UnixNano() / int64(hotBucketWidth)
+	window := (hotBuckets * hotBucketWidth).Seconds()
+
+	t.mu.Lock()
+	t.prune(bucket)
+	rates := make([]adminrpc.PrefixRate, 0, len(t.prefixes))
+	for prefix, c := range t.prefixes {
+		rates = append(rates, adminrpc.PrefixRate{Prefix: prefix, Rate: float64(c.total()) / window})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].Rate != rates[j].Rate {
+			return rates[i].Rate > rates[j].Rate
+		}
+		return rates[i].Prefix < rates[j].Prefix
+	})
+	if n >= 0 && n < len(rates) {
+		rates = rates[:n]
+	}
+	return rates
+}
+
+// prune advances every prefix's window to bucket and forgets the prefixes
+// that have seen no requests within it. t.mu must be held.
+func (t *hotTracker) prune(bucket int64) {
+	for prefix, c := range t.prefixes {
+		c.advance(bucket)
+		if c.total() == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+	t.lastPrune = bucket
+}
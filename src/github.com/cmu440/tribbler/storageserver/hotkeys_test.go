@@ -0,0 +1,39 @@
+package storageserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHotTracker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tr := newHotTracker()
+	for i := 0; i < 20; i++ {
+		tr.record("alice:post_1", start)
+	}
+	for i := 0; i < 5; i++ {
+		tr.record("bob:sublist", start.Add(time.Duration(i)*time.Second))
+	}
+
+	top := tr.top(-1, start.Add(5*time.Second))
+	if len(top) != 2 || top[0].Prefix != "alice" || top[1].Prefix != "bob" {
+		t.Fatalf("top = %v, want alice then bob", top)
+	}
+	if top[0].Rate != 2 || top[1].Rate != 0.5 {
+		t.Errorf("rates = %v and %v, want 2 and 0.5", top[0].Rate, top[1].Rate)
+	}
+	if top := tr.top(1, start.Add(5*time.Second)); len(top) != 1 {
+		t.Errorf("top(1) returned %d prefixes", len(top))
+	}
+
+	// Once their requests fall out of the window, recording a request for
+	// another prefix forgets them without waiting for top.
+	tr.record("carol:usrid", start.Add(14*time.Second))
+	if len(tr.prefixes) != 3 {
+		t.Errorf("prefixes pruned before a window had passed since the last pruning")
+	}
+	tr.record("carol:usrid", start.Add(15*time.Second))
+	if _, ok := tr.prefixes["alice"]; ok || len(tr.prefixes) != 1 {
+		t.Errorf("idle prefixes were not pruned by record: %v", tr.prefixes)
+	}
+}
@@ -0,0 +1,236 @@
+package storageserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// WithLeaseJournal makes the server record every lease it grants in the file
+// at path, so that a restarted server knows which libstores may still be
+// caching which keys and can revoke (or wait out) their leases before
+// writing. The file must belong to this server alone. Records are compacted
+// away as their leases expire.
+//
+// Without this option, the server journals its leases in
+// defaultJournalPath(port). Since a server listening on that port before
+// this one may have crashed, any unexpired leases found there are honored.
+func WithLeaseJournal(path string) Option {
+	return func(ss *storageServer) {
+		ss.journalPath = path
+	}
+}
+
+// WithoutLeaseJournal makes the server keep no lease journal. It then can't
+// tell whether it has restarted, and so refuses writes with status NotReady
+// until LeaseSeconds+LeaseGuardSeconds after every start, by which time any
+// lease it could have granted before a restart has expired.
+func WithoutLeaseJournal() Option {
+	return func(ss *storageServer) {
+		ss.journalPath = ""
+		ss.noJournal = true
+	}
+}
+
+// defaultJournalPath returns the lease journal used by a server listening on
+// port that wasn't given one with WithLeaseJournal.
+func defaultJournalPath(port int) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("tribbler-leases-%d", port))
+}
+
+// leaseFence returns the time before which a server started at start without
+// a lease journal must refuse writes.
+func leaseFence(start time.Time) time.Time {
+	return start.Add((storagerpc.LeaseSeconds + storagerpc.LeaseGuardSeconds) * time.Second)
+}
+
+// leaseRecord is a lease as stored in the journal.
+type leaseRecord struct {
+	Key      string
+	HostPort string
+	Expires  time.Time
+}
+
+// minJournalCompaction is the fewest records a journal holds before it is
+// compacted.
+const minJournalCompaction = 1024
+
+// leaseJournal is an append-only file of granted leases. Each record is
+// synced to disk before the lease is handed out; records written while a
+// sync is in progress share the next one. The file is rewritten without the
+// expired leases once it has doubled in size since it was last compacted.
+type leaseJournal struct {
+	path string
+
+	mu      sync.Mutex
+	synced  *sync.Cond // Signalled when a sync completes.
+	file    *os.File
+	enc     *json.Encoder
+	err     error         // Why the journal broke, if it did; every record fails from then on.
+	live    []leaseRecord // Every record in the file, expired or not.
+	limit   int           // Compact when live reaches this many records.
+	written uint64        // Records written to the file.
+	durable uint64        // Records known to be on disk.
+	syncing bool
+}
+
+// openLeaseJournal opens the journal at path, creating it if necessary, and
+// returns the leases recorded in it that are still unexpired at time now. The
+// journal is compacted to hold only those leases.
+func openLeaseJournal(path string, now time.Time) (*leaseJournal, []leaseRecord, error) {
+	var live []leaseRecord
+	if f, err := os.Open(path); err == nil {
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for {
+			var rec leaseRecord
+			if err := dec.Decode(&rec); err != nil {
+				// A torn final record is expected after a crash; the
+				// lease it describes was never handed out.
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					if _, ok := err.(*json.SyntaxError); !ok {
+						f.Close()
+						return nil, nil, err
+					}
+				}
+				break
+			}
+			if now.Before(rec.Expires) {
+				live = append(live, rec)
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	f, err := rewriteJournal(path, live)
+	if err != nil {
+		return nil, nil, err
+	}
+	j := &leaseJournal{path: path, file: f, enc: json.NewEncoder(f), live: live}
+	j.synced = sync.NewCond(&j.mu)
+	j.setLimit()
+	return j, append([]leaseRecord(nil), live...), nil
+}
+
+// rewriteJournal replaces the journal at path with one holding records, and
+// returns it open for appending.
+func rewriteJournal(path string, records []leaseRecord) (*os.File, error) {
+	// Write the records to a new file and swap it in atomically.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// record durably records a lease on key granted to the libstore at hostPort.
+func (j *leaseJournal) record(key, hostPort string, expires time.Time) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return j.err
+	}
+	rec := leaseRecord{Key: key, HostPort: hostPort, Expires: expires}
+	if len(j.live) >= j.limit && !j.syncing {
+		j.compact(time.Now())
+		if j.err != nil {
+			return j.err
+		}
+	}
+	if err := j.enc.Encode(rec); err != nil {
+		j.err = err
+		return err
+	}
+	j.live = append(j.live, rec)
+	j.written++
+	mine := j.written
+
+	for j.durable < mine && j.err == nil {
+		if j.syncing {
+			j.synced.Wait()
+			continue
+		}
+		// Sync everything written so far, letting other grants append
+		// (and wait for the next sync) in the meantime.
+		j.syncing = true
+		upTo, f := j.written, j.file
+		j.mu.Unlock()
+		err := f.Sync()
+		j.mu.Lock()
+		j.syncing = false
+		if err != nil {
+			j.err = err
+		} else {
+			j.durable = upTo
+		}
+		j.synced.Broadcast()
+	}
+	return j.err
+}
+
+// compact rewrites the journal without the leases that expired before now.
+// It must not be called during a sync. j.mu must be held.
+func (j *leaseJournal) compact(now time.Time) {
+	var live []leaseRecord
+	for _, rec := range j.live {
+		if now.Before(rec.Expires) {
+			live = append(live, rec)
+		}
+	}
+	f, err := rewriteJournal(j.path, live)
+	if err != nil {
+		j.err = err
+		return
+	}
+	j.file.Close()
+	j.file, j.enc, j.live = f, json.NewEncoder(f), live
+	j.durable = j.written
+	j.setLimit()
+}
+
+// setLimit sets when the journal is next compacted: once it holds twice as
+// many records as it does now. j.mu must be held.
+func (j *leaseJournal) setLimit() {
+	j.limit = 2 * len(j.live)
+	if j.limit < minJournalCompaction {
+		j.limit = minJournalCompaction
+	}
+}
+
+// close flushes and closes the journal.
+func (j *leaseJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for j.syncing {
+		j.synced.Wait()
+	}
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
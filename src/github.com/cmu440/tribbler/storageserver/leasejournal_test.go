@@ -0,0 +1,79 @@
+package storageserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// freePort returns a port that was free a moment ago.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestLeaseJournalCompactsExpiredLeases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	now := time.Now()
+	j, live, err := openLeaseJournal(path, now)
+	if err != nil || len(live) != 0 {
+		t.Fatalf("openLeaseJournal = %v, %v; want an empty journal", live, err)
+	}
+	for i := 0; i < minJournalCompaction; i++ {
+		if err := j.record(fmt.Sprintf("key%d", i), "lib", now.Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.record("live", "lib", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(j.live); n != 1 {
+		t.Errorf("journal holds %d records after compacting, want 1", n)
+	}
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, live, err = openLeaseJournal(path, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+	if len(live) != 1 || live[0].Key != "live" {
+		t.Errorf("reopened journal = %+v, want only the live lease", live)
+	}
+}
+
+func TestServerWithoutJournalFencesWrites(t *testing.T) {
+	port := freePort(t)
+	s, err := NewStorageServer("", 1, port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := s.(*storageServer)
+	if ss.journalPath != defaultJournalPath(port) || !ss.acceptingWrites(time.Now()) {
+		t.Errorf("server with the default journal %q refuses writes", ss.journalPath)
+	}
+	s.Close()
+	os.Remove(defaultJournalPath(port))
+
+	// A master without a journal can't tell whether it has restarted.
+	s, err = NewStorageServer("", 1, freePort(t), 1, WithoutLeaseJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var put storagerpc.PutReply
+	if err := s.Put(&storagerpc.PutArgs{Key: "k", Value: "v"}, &put); err != nil || put.Status != storagerpc.NotReady {
+		t.Errorf("Put to a server started without a journal = %v, %v; want NotReady", put.Status, err)
+	}
+}
@@ -0,0 +1,175 @@
+package storageserver
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// merkleDepth is the depth of the Merkle trees built over hash ranges, which
+// therefore have (up to) 2^merkleDepth leaves.
+const merkleDepth = 10
+
+// keyDigest hashes a key together with its value, list or tombstone, so that
+// two copies of the key have the same digest exactly when they hold the same
+// data. Versions are left out: copies that agree match however they came to
+// be, and every tombstone for a key matches every other.
+func keyDigest(key string, rec *record) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	switch {
+	case rec.deleted:
+		h.Write([]byte{0, 'd'})
+	case rec.isList:
+		h.Write([]byte{0, 'l'})
+		for _, item := range rec.list {
+			h.Write([]byte(item))
+			h.Write([]byte{0})
+		}
+	default:
+		h.Write([]byte{0, 'v'})
+		h.Write([]byte(rec.value))
+	}
+	return h.Sum64()
+}
+
+// merkleTree is a Merkle tree over the keys stored in a hash range. The range
+// is split into equal parts, one per leaf; each leaf's hash combines the
+// digests of the keys in its part, and each inner node's hash is the hash of
+// its children's.
+type merkleTree struct {
+	r     storagerpc.HashRange
+	depth int
+	nodes []uint64 // Breadth-first, root first: node i has children 2i+1 and 2i+2.
+}
+
+// rangeSize returns the number of hashes in r.
+func rangeSize(r storagerpc.HashRange) uint64 {
+	if r.Start == r.End {
+		return 1 << 32
+	}
+	return uint64(r.End - r.Start)
+}
+
+// newMerkleTree returns an empty tree over r. Small ranges get shallower
+// trees, so that every leaf covers at least one hash.
+func newMerkleTree(r storagerpc.HashRange) *merkleTree {
+	depth := merkleDepth
+	for depth > 0 && uint64(1)<<uint(depth) > rangeSize(r) {
+		depth--
+	}
+	return &merkleTree{r: r, depth: depth, nodes: make([]uint64, 2<<uint(depth)-1)}
+}
+
+// leaves returns the number of leaves in t.
+func (t *merkleTree) leaves() int {
+	return 1 << uint(t.depth)
+}
+
+// leaf returns the index of the leaf covering h, which must be in t's range.
+// The range's hashes are numbered by their offset o from Start+1, and leaf i
+// covers the offsets with o*leaves/size == i.
+func (t *merkleTree) leaf(h uint32) int {
+	offset := uint64(h - t.r.Start - 1)
+	return int(offset * uint64(t.leaves()) / rangeSize(t.r))
+}
+
+// leafRange returns the part of t's range covered by leaf i: exactly the
+// hashes h for which leaf(h) == i.
+func (t *merkleTree) leafRange(i int) storagerpc.HashRange {
+	size, n := rangeSize(t.r), uint64(t.leaves())
+	// The smallest offset o with o*n/size >= i is ceil(i*size/n).
+	first := func(i uint64) uint32 { return uint32((i*size + n - 1) / n) }
+	return storagerpc.HashRange{
+		Start: t.r.Start + first(uint64(i)),
+		End:   t.r.Start + first(uint64(i+1)),
+	}
+}
+
+// add adds the digest of a key with hash h to its leaf. Digests are combined
+// with XOR so that keys may be added in any order.
+func (t *merkleTree) add(h uint32, digest uint64) {
+	t.nodes[t.leaves()-1+t.leaf(h)] ^= digest
+}
+
+// seal computes the hashes of t's inner nodes once every key has been added.
+func (t *merkleTree) seal() {
+	var buf [16]byte
+	for i := t.leaves() - 2; i >= 0; i-- {
+		binary.BigEndian.PutUint64(buf[:8], t.nodes[2*i+1])
+		binary.BigEndian.PutUint64(buf[8:], t.nodes[2*i+2])
+		h := fnv.New64a()
+		h.Write(buf[:])
+		t.nodes[i] = h.Sum64()
+	}
+}
+
+// top returns the hashes of the top depth levels of t (the root alone for
+// depth 0), in the order expected by diff.
+func (t *merkleTree) top(depth int) []uint64 {
+	if depth > t.depth {
+		depth = t.depth
+	}
+	return t.nodes[:2<<uint(depth)-1]
+}
+
+// diff returns the leaves of t whose hashes differ from those in other, which
+// must hold all the nodes of another tree over the same range.
+func (t *merkleTree) diff(other []uint64) []int {
+	if len(other) != len(t.nodes) {
+		// The peer built its tree differently; treat every leaf as
+		// diverged.
+		all := make([]int, t.leaves())
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+	var leaves []int
+	var walk func(i int)
+	walk = func(i int) {
+		if t.nodes[i] == other[i] {
+			return
+		}
+		if i >= t.leaves()-1 {
+			leaves = append(leaves, i-(t.leaves()-1))
+			return
+		}
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	return leaves
+}
+
+// buildMerkleTree builds the tree over r for the given records, of which only
+// keys whose hash falls in r are included.
+func buildMerkleTree(r storagerpc.HashRange, hash func(string) uint32, records map[string]*record) *merkleTree {
+	t := newMerkleTree(r)
+	for key, rec := range records {
+		if h := hash(key); r.Contains(h) {
+			t.add(h, keyDigest(key, rec))
+		}
+	}
+	t.seal()
+	return t
+}
+
+// keyDigests returns the digests and versions of the given records whose hash
+// falls in any of ranges.
+func keyDigests(ranges []storagerpc.HashRange, hash func(string) uint32, records map[string]*record) (digests, versions map[string]uint64) {
+	digests = make(map[string]uint64)
+	versions = make(map[string]uint64)
+	for key, rec := range records {
+		h := hash(key)
+		for _, r := range ranges {
+			if r.Contains(h) {
+				digests[key] = keyDigest(key, rec)
+				versions[key] = rec.version
+				break
+			}
+		}
+	}
+	return digests, versions
+}
@@ -0,0 +1,51 @@
+package storageserver
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestMerkleLeafRange(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []storagerpc.HashRange{
+		{Start: 0, End: 0},                   // The whole ring.
+		{Start: 7, End: 7},                   // The whole ring, offset.
+		{Start: 0, End: 1000},                // Not a multiple of the leaf count.
+		{Start: 100, End: 103},               // Fewer hashes than merkleDepth allows.
+		{Start: 4000000000, End: 12345},      // Wraps around the ring.
+		{Start: 1, End: 1<<32 - 1},           // Almost the whole ring.
+		{Start: 123456789, End: 3000000001},  // Uneven.
+		{Start: 1<<32 - 5, End: 1<<32 - 2},   // Tiny, near the top.
+		{Start: 1<<31 + 17, End: 1<<31 - 17}, // Wraps, almost the whole ring.
+	}
+	for _, r := range tests {
+		tree := newMerkleTree(r)
+		size := rangeSize(r)
+		check := func(h uint32) {
+			i := tree.leaf(h)
+			if i < 0 || i >= tree.leaves() {
+				t.Fatalf("range %v: leaf(%d) = %d, want in [0, %d)", r, h, i, tree.leaves())
+			}
+			if lr := tree.leafRange(i); !lr.Contains(h) {
+				t.Fatalf("range %v: leaf(%d) = %d, whose range %v does not contain it", r, h, i, lr)
+			}
+		}
+		// The ends of the range, and random hashes in between.
+		check(r.Start + 1)
+		check(r.Start + uint32(size))
+		for j := 0; j < 3000; j++ {
+			check(r.Start + 1 + uint32(rng.Int63n(int64(size))))
+		}
+		// The leaves' ranges tile the range without gaps.
+		for i := 0; i < tree.leaves()-1; i++ {
+			if a, b := tree.leafRange(i), tree.leafRange(i+1); a.End != b.Start {
+				t.Fatalf("range %v: leaf %d ends at %d but leaf %d starts at %d", r, i, a.End, i+1, b.Start)
+			}
+		}
+		if first, last := tree.leafRange(0), tree.leafRange(tree.leaves()-1); first.Start != r.Start || last.End != r.End {
+			t.Fatalf("range %v: leaves cover (%d, %d]", r, first.Start, last.End)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package storageserver
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/cmu440/tribbler/metrics"
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+)
+
+// methodStats converts snapshots of ss.metrics into the form reported by the
+// Admin service's Stats RPC.
+func methodStats(snaps []metrics.MethodSnapshot) []adminrpc.MethodStats {
+	stats := make([]adminrpc.MethodStats, len(snaps))
+	for i, s := range snaps {
+		stats[i] = adminrpc.MethodStats{
+			Method:   s.Method,
+			Requests: s.Requests,
+			Latency:  adminrpc.Histogram{Counts: s.Counts, Sum: s.Sum},
+		}
+	}
+	return stats
+}
+
+// writeMetrics writes the server's metrics for the handler served at
+// metrics.Path.
+func (ss *storageServer) writeMetrics(w *metrics.Writer) {
+	ss.metrics.Write(w, "storage_rpc")
+	w.Counter("storage_leases_granted_total", "Leases granted to libstores.",
+		float64(atomic.LoadUint64(&ss.granted)))
+	rs := ss.revoker.snapshot()
+	w.Counter("storage_lease_revocations_total", "Leases revoked before writes, by outcome.",
+		float64(rs.Acked), "outcome", "acked")
+	w.Counter("storage_lease_revocations_total", "Leases revoked before writes, by outcome.",
+		float64(rs.WaitedOut), "outcome", "waited_out")
+	w.Counter("storage_lease_revocation_seconds_total", "Time writes spent waiting on lease revocations.",
+		rs.TotalLatency.Seconds())
+	w.Gauge("storage_lease_revocations_pending", "Lease revocations in progress.",
+		float64(ss.revoker.inProgress()))
+}
+
+// leaseCounts returns the number of leases on each key that are unexpired at
+// time now, most held first.
+func leaseCounts(leases map[string][]leaseHolder, now time.Time) []adminrpc.LeaseCount {
+	var counts []adminrpc.LeaseCount
+	for key, holders := range leases {
+		n := 0
+		for _, h := range holders {
+			if now.Before(h.expires) {
+				n++
+			}
+		}
+		if n > 0 {
+			counts = append(counts, adminrpc.LeaseCount{Key: key, Holders: n})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Holders != counts[j].Holders {
+			return counts[i].Holders > counts[j].Holders
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	return counts
+}
@@ -0,0 +1,106 @@
+package storageserver
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestStatsCounts(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	s, err := NewStorageServer("", 1, port, 1, WithLeaseJournal(filepath.Join(t.TempDir(), "leases")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ss := s.(*storageServer)
+
+	var put storagerpc.PutReply
+	for _, kv := range [][2]string{{"a", "1"}, {"bb", "22"}, {"gone", "x"}} {
+		ss.Put(&storagerpc.PutArgs{Key: kv[0], Value: kv[1]}, &put)
+	}
+	ss.AppendToList(&storagerpc.PutArgs{Key: "l", Value: "item"}, &put)
+	ss.Delete(&storagerpc.DeleteArgs{Key: "gone"}, &storagerpc.DeleteReply{})
+	var get storagerpc.GetReply
+	ss.Get(&storagerpc.GetArgs{Key: "a", WantLease: true, HostPort: "localhost:1"}, &get)
+	if !get.Lease.Granted {
+		t.Fatal("Get was not granted a lease")
+	}
+	ss.Get(&storagerpc.GetArgs{Key: "missing"}, &get)
+
+	var reply adminrpc.StatsReply
+	if err := ss.Stats(&adminrpc.StatsArgs{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Keys != 2 || reply.Lists != 1 || reply.Bytes != 2+4+5 {
+		t.Errorf("Stats = %d keys, %d lists and %d bytes; want 2, 1 and 11", reply.Keys, reply.Lists, reply.Bytes)
+	}
+	if len(reply.Leases) != 1 || reply.Leases[0] != (adminrpc.LeaseCount{Key: "a", Holders: 1}) {
+		t.Errorf("Leases = %v, want one on a", reply.Leases)
+	}
+
+	want := map[string]uint64{"AppendToList": 1, "Delete": 1, "Get": 2, "Put": 3}
+	var methods []string
+	for _, m := range reply.Methods {
+		methods = append(methods, m.Method)
+		var counted uint64
+		for _, n := range m.Latency.Counts {
+			counted += n
+		}
+		if m.Requests != want[m.Method] || counted != m.Requests {
+			t.Errorf("%s: %d requests, %d in latency buckets; want %d", m.Method, m.Requests, counted, want[m.Method])
+		}
+		if len(m.Latency.Counts) != len(adminrpc.LatencyBuckets)+1 {
+			t.Errorf("%s: %d latency buckets, want %d", m.Method, len(m.Latency.Counts), len(adminrpc.LatencyBuckets)+1)
+		}
+	}
+	if len(methods) != len(want) || methods[0] != "AppendToList" || methods[3] != "Put" {
+		t.Errorf("Methods = %v, want AppendToList, Delete, Get and Put in order", methods)
+	}
+}
+
+func TestStatsCountsEveryMethod(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	s, err := NewStorageServer("", 1, port, 1, WithLeaseJournal(filepath.Join(t.TempDir(), "leases")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ss := s.(*storageServer)
+
+	ss.Batch(&storagerpc.BatchArgs{Writes: []storagerpc.Write{{Op: storagerpc.PutOp, Key: "a", Value: "1"}}}, &storagerpc.BatchReply{})
+	ss.GetServers(&storagerpc.GetServersArgs{}, &storagerpc.GetServersReply{})
+	ss.ReleaseLeases(&storagerpc.ReleaseLeasesArgs{HostPort: "localhost:1"}, &storagerpc.ReleaseLeasesReply{})
+	ss.ReceiveRange(&storagerpc.ReceiveRangeArgs{Abort: true}, &storagerpc.ReceiveRangeReply{})
+	ss.TransferRange(&storagerpc.TransferRangeArgs{}, &storagerpc.TransferRangeReply{})
+	ss.HotPrefixes(&adminrpc.HotPrefixesArgs{N: 1}, &adminrpc.HotPrefixesReply{})
+	ss.Load(&adminrpc.LoadArgs{Buckets: 1}, &adminrpc.LoadReply{})
+	ss.Stats(&adminrpc.StatsArgs{}, &adminrpc.StatsReply{})
+
+	var reply adminrpc.StatsReply
+	if err := ss.Stats(&adminrpc.StatsArgs{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]uint64)
+	for _, m := range reply.Methods {
+		got[m.Method] = m.Requests
+	}
+	for _, method := range []string{"Batch", "GetServers", "ReleaseLeases", "ReceiveRange", "TransferRange", "HotPrefixes", "Load", "Stats"} {
+		if got[method] != 1 {
+			t.Errorf("%s: %d requests, want 1", method, got[method])
+		}
+	}
+}
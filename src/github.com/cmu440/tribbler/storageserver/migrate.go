@@ -0,0 +1,335 @@
+package storageserver
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// maxTransferChunk is the maximum number of keys sent in one ReceiveRange RPC.
+const maxTransferChunk = 512
+
+// routeBackRetryInterval is how long a server waits before asking the master
+// again to route back a range whose move failed.
+const routeBackRetryInterval = time.Second
+
+// errMoveInProgress is returned by rangeMoves.begin if the requested range
+// overlaps a move that is already in progress.
+var errMoveInProgress = errors.New("an overlapping range is already being moved")
+
+// rangeMove is a hash range being moved off this server.
+type rangeMove struct {
+	r    storagerpc.HashRange
+	done chan struct{} // Closed once the move has completed or failed.
+}
+
+// incomingRange holds the keys of a range being received until the chunk
+// with Done set arrives, so that an abandoned move leaves nothing behind.
+type incomingRange struct {
+	values map[string]string
+	lists  map[string][]string
+}
+
+// movedRange is a hash range that has been moved to another server.
+type movedRange struct {
+	r     storagerpc.HashRange
+	owner storagerpc.Node
+}
+
+// rangeMoves tracks the hash ranges this server is moving, or has moved, to
+// other servers, and those it has received from other servers.
+//
+// Writes and lease grants are admitted, by the hash of their key, from the
+// moment they check that their key isn't being moved until they are done. A
+// move waits only for those admitted in its own range.
+type rangeMoves struct {
+	mu       sync.Mutex
+	released *sync.Cond     // Signaled whenever an admitted operation is done.
+	admitted map[uint32]int // Writes and lease grants in progress, by hash.
+	moving   []*rangeMove
+	moved    []movedRange
+	received []storagerpc.HashRange
+}
+
+// cond returns m.released, creating it on first use. m.mu must be held.
+func (m *rangeMoves) cond() *sync.Cond {
+	if m.released == nil {
+		m.released = sync.NewCond(&m.mu)
+	}
+	return m.released
+}
+
+// begin starts moving r, once the writes and lease grants in progress in r
+// are done. Until the returned move is passed to finish, writes to keys in r
+// block in beginWrite and no leases are granted on them.
+func (m *rangeMoves) begin(r storagerpc.HashRange) (*rangeMove, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mv := range m.moving {
+		if mv.r.Overlaps(r) {
+			return nil, errMoveInProgress
+		}
+	}
+	mv := &rangeMove{r: r, done: make(chan struct{})}
+	m.moving = append(m.moving, mv)
+	for m.busy(r) {
+		m.cond().Wait()
+	}
+	return mv, nil
+}
+
+// busy reports whether a write or lease grant is in progress in r. m.mu must
+// be held.
+func (m *rangeMoves) busy(r storagerpc.HashRange) bool {
+	for h := range m.admitted {
+		if r.Contains(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// admit admits an operation on h, unless h is being moved, in which case it
+// returns the move. m.mu must be held.
+func (m *rangeMoves) admit(h uint32) *rangeMove {
+	if mv := m.movingAtLocked(h); mv != nil {
+		return mv
+	}
+	if m.admitted == nil {
+		m.admitted = make(map[uint32]int)
+	}
+	m.admitted[h]++
+	return nil
+}
+
+// release ends an operation on h admitted by admit.
+func (m *rangeMoves) release(h uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.admitted[h]--; m.admitted[h] == 0 {
+		delete(m.admitted, h)
+	}
+	m.cond().Broadcast()
+}
+
+// finish completes mv. If target is nil the move failed and the range stays
+// on this server; otherwise target now owns it.
+func (m *rangeMoves) finish(mv *rangeMove, target *storagerpc.Node) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, other := range m.moving {
+		if other == mv {
+			m.moving = append(m.moving[:i], m.moving[i+1:]...)
+			break
+		}
+	}
+	if target != nil {
+		var received []storagerpc.HashRange
+		for _, r := range m.received {
+			received = append(received, subtractRange(r, mv.r)...)
+		}
+		m.received = received
+		m.moved = append(m.moved, movedRange{r: mv.r, owner: *target})
+	}
+	close(mv.done)
+}
+
+// movingAt returns the move in progress whose range holds h, or nil if h
+// isn't being moved.
+func (m *rangeMoves) movingAt(h uint32) *rangeMove {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.movingAtLocked(h)
+}
+
+// movingAtLocked is movingAt for callers that hold m.mu.
+func (m *rangeMoves) movingAtLocked(h uint32) *rangeMove {
+	for _, mv := range m.moving {
+		if mv.r.Contains(h) {
+			return mv
+		}
+	}
+	return nil
+}
+
+// inMove reports whether h is in a range that is being moved, in which case
+// no lease may be granted on it.
+func (m *rangeMoves) inMove(h uint32) bool {
+	return m.movingAt(h) != nil
+}
+
+// unlessMoving calls grant, and returns its result, unless h is in a range
+// that is being moved. No move can begin while grant runs.
+func (m *rangeMoves) unlessMoving(h uint32, grant func() bool) bool {
+	m.mu.Lock()
+	mv := m.admit(h)
+	m.mu.Unlock()
+	if mv != nil {
+		return false
+	}
+	defer m.release(h)
+	return grant()
+}
+
+// beginWrite blocks while h is in a range that is being moved, and then
+// admits a write to h: no move of a range holding h can begin until the
+// write calls endWrite.
+func (m *rangeMoves) beginWrite(h uint32) {
+	for {
+		m.mu.Lock()
+		mv := m.admit(h)
+		m.mu.Unlock()
+		if mv == nil {
+			return
+		}
+		<-mv.done
+	}
+}
+
+// endWrite ends a write to h admitted by beginWrite.
+func (m *rangeMoves) endWrite(h uint32) {
+	m.release(h)
+}
+
+// movedTo returns the node h has been moved to, or nil if h hasn't been moved
+// off this server.
+func (m *rangeMoves) movedTo(h uint32) *storagerpc.Node {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mr := range m.moved {
+		if mr.r.Contains(h) {
+			owner := mr.owner
+			return &owner
+		}
+	}
+	return nil
+}
+
+// receive records that this server has taken ownership of r, which it may
+// previously have moved elsewhere.
+func (m *rangeMoves) receive(r storagerpc.HashRange) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var moved []movedRange
+	for _, mr := range m.moved {
+		for _, rest := range subtractRange(mr.r, r) {
+			moved = append(moved, movedRange{r: rest, owner: mr.owner})
+		}
+	}
+	m.moved = moved
+	m.received = append(m.received, r)
+}
+
+// subtractRange returns the (at most two) ranges that together hold the
+// hashes in a but not in b.
+func subtractRange(a, b storagerpc.HashRange) []storagerpc.HashRange {
+	if b.Start == b.End {
+		return nil
+	}
+	// Work with offsets from a.Start, so that a is (0, sizeA]. The hashes
+	// not in b form the range (b.End, b.Start], whose offsets start at
+	// rest; it may wrap past a.Start, so it is also tried one ring earlier.
+	sizeA := rangeSize(a)
+	rest := uint64(b.End - a.Start)
+	sizeRest := rangeSize(storagerpc.HashRange{Start: b.End, End: b.Start})
+	var out []storagerpc.HashRange
+	for _, lo := range []int64{int64(rest), int64(rest) - 1<<32} {
+		hi := lo + int64(sizeRest)
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > int64(sizeA) {
+			hi = int64(sizeA)
+		}
+		if lo < hi {
+			out = append(out, storagerpc.HashRange{Start: a.Start + uint32(lo), End: a.Start + uint32(hi)})
+		}
+	}
+	return out
+}
+
+// owned returns the hash ranges owned by a server whose ring range is base:
+// base less the ranges moved away, plus those received.
+func (m *rangeMoves) owned(base storagerpc.HashRange) []storagerpc.HashRange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	owned := []storagerpc.HashRange{base}
+	for _, mr := range m.moved {
+		var rest []storagerpc.HashRange
+		for _, r := range owned {
+			rest = append(rest, subtractRange(r, mr.r)...)
+		}
+		owned = rest
+	}
+	return append(owned, m.received...)
+}
+
+// receivedHash reports whether h is in a range this server has received.
+func (m *rangeMoves) receivedHash(h uint32) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.received {
+		if r.Contains(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// abortRange tells target to drop the keys of r it has received but not yet
+// taken on.
+func abortRange(target *rpc.Client, r storagerpc.HashRange) error {
+	var reply storagerpc.ReceiveRangeReply
+	return callStatus(target, "StorageServer.ReceiveRange", &storagerpc.ReceiveRangeArgs{Range: r, Abort: true}, &reply, &reply.Status)
+}
+
+// sendRange streams values and lists to target in chunks of at most
+// maxTransferChunk keys. If done is set, the last chunk (empty if there are
+// no keys) is marked as done, handing r over to target.
+func sendRange(target *rpc.Client, r storagerpc.HashRange, values map[string]string, lists map[string][]string, done bool) error {
+	args := &storagerpc.ReceiveRangeArgs{Range: r}
+	n := 0
+	send := func(done bool) error {
+		args.Done = done
+		var reply storagerpc.ReceiveRangeReply
+		if err := target.Call("StorageServer.ReceiveRange", args, &reply); err != nil {
+			return err
+		}
+		if reply.Status != storagerpc.OK {
+			return fmt.Errorf("ReceiveRange failed with status %v", reply.Status)
+		}
+		args.Values, args.Lists, n = nil, nil, 0
+		return nil
+	}
+
+	for key, value := range values {
+		if args.Values == nil {
+			args.Values = make(map[string]string)
+		}
+		args.Values[key] = value
+		if n++; n == maxTransferChunk {
+			if err := send(false); err != nil {
+				return err
+			}
+		}
+	}
+	for key, list := range lists {
+		if args.Lists == nil {
+			args.Lists = make(map[string][]string)
+		}
+		args.Lists[key] = list
+		if n++; n == maxTransferChunk {
+			if err := send(false); err != nil {
+				return err
+			}
+		}
+	}
+	if n == 0 && !done {
+		return nil
+	}
+	return send(done)
+}
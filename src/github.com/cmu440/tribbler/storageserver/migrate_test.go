@@ -0,0 +1,176 @@
+package storageserver
+
+import (
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestSubtractRange(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Small hashes near both ends of the ring make overlaps and wrapping
+	// likely.
+	hash := func() uint32 {
+		h := uint32(rng.Intn(64))
+		if rng.Intn(2) == 0 {
+			h = -h
+		}
+		return h
+	}
+	for i := 0; i < 2000; i++ {
+		a := storagerpc.HashRange{Start: hash(), End: hash()}
+		b := storagerpc.HashRange{Start: hash(), End: hash()}
+		rest := subtractRange(a, b)
+		for j := 0; j < 200; j++ {
+			h := hash()
+			want := a.Contains(h) && !b.Contains(h)
+			got := 0
+			for _, r := range rest {
+				if r.Contains(h) {
+					got++
+				}
+			}
+			if got > 1 || (got == 1) != want {
+				t.Fatalf("subtractRange(%v, %v) = %v: hash %d is in %d of them, want in a-b = %v", a, b, rest, h, got, want)
+			}
+		}
+	}
+}
+
+func TestRangeMovesReceiveBack(t *testing.T) {
+	var m rangeMoves
+	b := storagerpc.Node{HostPort: "b:9000", NodeID: 2}
+	r := storagerpc.HashRange{Start: 100, End: 200}
+
+	// Move (100, 200] from A to B, then receive (150, 200] back.
+	mv, err := m.begin(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.finish(mv, &b)
+	m.receive(storagerpc.HashRange{Start: 150, End: 200})
+
+	tests := []struct {
+		h     uint32
+		moved bool
+	}{{101, true}, {150, true}, {151, false}, {200, false}, {201, false}}
+	for _, test := range tests {
+		if got := m.movedTo(test.h) != nil; got != test.moved {
+			t.Errorf("movedTo(%d) != nil is %v, want %v", test.h, got, test.moved)
+		}
+		if want := r.Contains(test.h) && !test.moved; m.receivedHash(test.h) != want {
+			t.Errorf("receivedHash(%d) = %v, want %v", test.h, !want, want)
+		}
+	}
+
+	// Moving it away again forgets that it was received.
+	mv, err = m.begin(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.finish(mv, &b)
+	if m.receivedHash(175) || m.movedTo(175) == nil {
+		t.Errorf("hash 175 should have moved to B again")
+	}
+}
+
+func TestRangeMovesAdmission(t *testing.T) {
+	var m rangeMoves
+	r := storagerpc.HashRange{Start: 100, End: 200}
+
+	// A move waits for the write in progress in its range, but not for
+	// one outside it.
+	m.beginWrite(250)
+	m.beginWrite(150)
+	begun := make(chan *rangeMove)
+	go func() {
+		mv, err := m.begin(r)
+		if err != nil {
+			t.Error(err)
+		}
+		begun <- mv
+	}()
+	select {
+	case <-begun:
+		t.Fatal("move began while a write in its range was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.endWrite(150)
+	mv := <-begun
+	m.endWrite(250)
+
+	// Writes and lease grants in the range wait for the move, others don't.
+	if m.unlessMoving(150, func() bool { return true }) {
+		t.Error("lease granted in a range being moved")
+	}
+	m.beginWrite(250)
+	m.endWrite(250)
+	written := make(chan struct{})
+	go func() {
+		m.beginWrite(150)
+		m.endWrite(150)
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("write admitted in a range being moved")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.finish(mv, nil)
+	<-written
+}
+
+func TestSendRangeHandsOverOnlyWhenDone(t *testing.T) {
+	a, b := startPair(t)
+	r := a.ringRange(a.nodeID, a.ring())
+	key := "user0:post"
+	for i := 1; !r.Contains(a.partitioner.Hash(key)); i++ {
+		key = fmt.Sprintf("user%d:post", i)
+	}
+	cli, err := rpc.DialHTTP("tcp", b.hostPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	if err := sendRange(cli, r, map[string]string{key: "v", "abandoned": "v"}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if b.ownsKey(key) {
+		t.Errorf("b owns %s before the transfer is done", key)
+	}
+	b.mu.Lock()
+	if rec := b.find(key); rec != nil {
+		t.Errorf("b stores %s = %+v before the transfer is done", key, rec)
+	}
+	b.mu.Unlock()
+
+	// An aborted transfer leaves nothing behind for the next one.
+	if err := abortRange(cli, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendRange(cli, r, map[string]string{key: "w"}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendRange(cli, r, nil, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if !b.ownsKey(key) {
+		t.Errorf("b doesn't own %s once the transfer is done", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rec := b.find(key); rec == nil || rec.value != "w" {
+		t.Errorf("b has %s = %+v, want the value sent after the abort", key, rec)
+	}
+	if rec := b.find("abandoned"); rec != nil {
+		t.Errorf("b stores a key sent before the abort: %+v", rec)
+	}
+	if len(b.incoming) != 0 {
+		t.Errorf("b still holds %d ranges aside once the transfer is done", len(b.incoming))
+	}
+}
@@ -0,0 +1,15 @@
+package storageserver
+
+import "github.com/cmu440/tribbler/libstore"
+
+// Option configures optional StorageServer behavior. Options are applied in
+// order by NewStorageServer.
+type Option func(*storageServer)
+
+// WithPartitioner sets the partitioner used to decide which keys the server
+// is responsible for. All servers in a ring must use the same partitioner.
+func WithPartitioner(p libstore.Partitioner) Option {
+	return func(ss *storageServer) {
+		ss.partitioner = p
+	}
+}
@@ -0,0 +1,166 @@
+package storageserver
+
+import (
+	"net/rpc"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// nextVersion returns the version to give a write to a key whose current
+// version is prev. Versions are timestamps, bumped past prev should the clock
+// have gone backwards, so that a newly promoted primary's writes supersede
+// its predecessor's as long as their clocks are roughly in step.
+func nextVersion(prev uint64, now time.Time) uint64 {
+	v := uint64(now.UnixNano())
+	if v <= prev {
+		v = prev + 1
+	}
+	return v
+}
+
+// replicate sends the new state of a key to every node in replicas, in
+// parallel, and reports whether at least need of them acknowledged it. It
+// returns as soon as the outcome is known; sends still in flight carry on in
+// the background, so that every replica eventually catches up.
+func (ss *storageServer) replicate(replicas []storagerpc.Node, args *storagerpc.ReplicateArgs, need int) bool {
+	// Buffered so that late replies don't leak their goroutines.
+	acks := make(chan bool, len(replicas))
+	for _, node := range replicas {
+		go func(node storagerpc.Node) {
+			var reply storagerpc.ReplicateReply
+			acks <- ss.callPeer(node, "StorageServer.Replicate", args, &reply) && reply.Status == storagerpc.OK
+		}(node)
+	}
+	acked, failed := 0, 0
+	for acked < need {
+		if failed > len(replicas)-need {
+			return false
+		}
+		if <-acks {
+			acked++
+		} else {
+			failed++
+		}
+	}
+	return true
+}
+
+// replicaRead is one replica's answer to a read.
+type replicaRead struct {
+	node    storagerpc.Node
+	status  storagerpc.Status // OK or KeyNotFound.
+	value   string
+	list    []string
+	isList  bool // With status OK, the key holds a list.
+	deleted bool // With status KeyNotFound, the key is a tombstone.
+	version uint64
+}
+
+// repairable reports whether r is the whole state of its key, so that a
+// replica may be overwritten with it. A key that holds a value reads as
+// KeyNotFound to GetList, and a list as KeyNotFound to Get, at the key's
+// version; such a read says nothing about what the key holds.
+func (r replicaRead) repairable() bool {
+	return r.status == storagerpc.OK || r.deleted
+}
+
+// readReplicas reads key, as a list if isList is set, from each of replicas
+// in parallel, and returns the first need answers along with local (this
+// server's own answer), or false if too few replicas answered. Replicas are
+// read at ConsistencyOne, so they answer from their own store.
+func (ss *storageServer) readReplicas(local replicaRead, replicas []storagerpc.Node, key string, isList bool, need int) ([]replicaRead, bool) {
+	reads := []replicaRead{local}
+	if len(reads) >= need {
+		return reads, true
+	}
+	results := make(chan *replicaRead, len(replicas))
+	for _, node := range replicas {
+		go func(node storagerpc.Node) {
+			args := &storagerpc.GetArgs{Key: key}
+			if isList {
+				var reply storagerpc.GetListReply
+				if ss.callPeer(node, "StorageServer.GetList", args, &reply) && readable(reply.Status) {
+					results <- &replicaRead{node: node, status: reply.Status, list: reply.Value, isList: true, deleted: reply.Deleted, version: reply.Version}
+					return
+				}
+			} else {
+				var reply storagerpc.GetReply
+				if ss.callPeer(node, "StorageServer.Get", args, &reply) && readable(reply.Status) {
+					results <- &replicaRead{node: node, status: reply.Status, value: reply.Value, deleted: reply.Deleted, version: reply.Version}
+					return
+				}
+			}
+			results <- nil
+		}(node)
+	}
+	failed := 0
+	for len(reads) < need {
+		if failed > len(replicas)-(need-1) {
+			return nil, false
+		}
+		if r := <-results; r != nil {
+			reads = append(reads, *r)
+		} else {
+			failed++
+		}
+	}
+	return reads, true
+}
+
+// readable reports whether status is a valid answer from a replica.
+func readable(status storagerpc.Status) bool {
+	return status == storagerpc.OK || status == storagerpc.KeyNotFound
+}
+
+// newest returns the read with the highest version. Deletions are kept as
+// versioned tombstones, so a KeyNotFound may be the newest answer.
+func newest(reads []replicaRead) replicaRead {
+	best := reads[0]
+	for _, r := range reads[1:] {
+		if r.version > best.version {
+			best = r
+		}
+	}
+	return best
+}
+
+// readRepair sends best to each replica whose answer in reads was older, in
+// the background, unless best isn't the key's whole state.
+func (ss *storageServer) readRepair(key string, best replicaRead, reads []replicaRead) {
+	if !best.repairable() {
+		return
+	}
+	args := &storagerpc.ReplicateArgs{
+		Key:     key,
+		Value:   best.value,
+		List:    best.list,
+		IsList:  best.isList,
+		Deleted: best.deleted,
+		Version: best.version,
+	}
+	for _, r := range reads {
+		if r.version < best.version && r.node.NodeID != ss.nodeID {
+			go func(node storagerpc.Node) {
+				var reply storagerpc.ReplicateReply
+				ss.callPeer(node, "StorageServer.Replicate", args, &reply)
+			}(r.node)
+		}
+	}
+}
+
+// callPeer calls method on another storage server, reporting whether the call
+// went through. A broken connection is dropped so that the next call redials.
+func (ss *storageServer) callPeer(node storagerpc.Node, method string, args, reply interface{}) bool {
+	cli, err := ss.peers.get(node.HostPort)
+	if err != nil {
+		return false
+	}
+	if err := cli.Call(method, args, reply); err != nil {
+		if err == rpc.ErrShutdown {
+			ss.peers.drop(node.HostPort, cli)
+		}
+		return false
+	}
+	return true
+}
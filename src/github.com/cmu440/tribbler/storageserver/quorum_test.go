@@ -0,0 +1,95 @@
+package storageserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestQuorumReadRepairsOnlyWholeStates(t *testing.T) {
+	a, b := startReplicatedPair(t)
+	r := a.ringRange(a.nodeID, a.ring())
+
+	// Pick keys that a is the primary of.
+	var keys []string
+	for i := 0; len(keys) < 2; i++ {
+		if key := fmt.Sprintf("user%d:post", i); r.Contains(a.partitioner.Hash(key)) {
+			keys = append(keys, key)
+		}
+	}
+	value, deleted := keys[0], keys[1]
+	a.mu.Lock()
+	b.mu.Lock()
+	a.store[value] = &record{value: "old", version: 1}
+	b.store[value] = &record{value: "new", version: 2}
+	a.store[deleted] = &record{value: "old", version: 1}
+	b.store[deleted] = &record{deleted: true, version: 2}
+	b.mu.Unlock()
+	a.mu.Unlock()
+
+	// Read as a list, b's newer value is a KeyNotFound, but not a delete.
+	res := a.read(&storagerpc.GetArgs{Key: value, Consistency: storagerpc.ConsistencyAll}, true)
+	if res.status != storagerpc.KeyNotFound {
+		t.Errorf("GetList(%s) status = %v, want KeyNotFound", value, res.status)
+	}
+	a.mu.Lock()
+	if rec := a.find(value); rec == nil || rec.value != "old" {
+		t.Errorf("%s = %+v after GetList, want the value kept", value, rec)
+	}
+	a.mu.Unlock()
+
+	// Read as a value, b's newer value replaces a's.
+	res = a.read(&storagerpc.GetArgs{Key: value, Consistency: storagerpc.ConsistencyAll}, false)
+	if res.status != storagerpc.OK || res.value != "new" {
+		t.Errorf("Get(%s) = %v %q, want OK \"new\"", value, res.status, res.value)
+	}
+	a.mu.Lock()
+	if rec := a.find(value); rec == nil || rec.value != "new" || rec.version != 2 {
+		t.Errorf("%s = %+v after Get, want b's value", value, rec)
+	}
+	a.mu.Unlock()
+
+	// b's delete is a tombstone, whichever way the key is read.
+	if res := a.read(&storagerpc.GetArgs{Key: deleted, Consistency: storagerpc.ConsistencyAll}, true); res.status != storagerpc.KeyNotFound {
+		t.Errorf("GetList(%s) status = %v, want KeyNotFound", deleted, res.status)
+	}
+	a.mu.Lock()
+	if rec := a.store[deleted]; rec == nil || !rec.deleted || rec.version != 2 {
+		t.Errorf("%s = %+v, want b's tombstone", deleted, rec)
+	}
+	a.mu.Unlock()
+}
+
+func TestQuorumReadCatchUpRevokesLeases(t *testing.T) {
+	a, b := startReplicatedPair(t)
+	r := a.ringRange(a.nodeID, a.ring())
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("user%d:post", i); r.Contains(a.partitioner.Hash(k)) {
+			key = k
+		}
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	a.store[key] = &record{value: "old", version: 1}
+	b.store[key] = &record{value: "new", version: 2}
+	// The holder can't be reached, so its lease is waited out.
+	a.leases[key] = []leaseHolder{{hostPort: "localhost:1", expires: time.Now().Add(100 * time.Millisecond)}}
+	b.mu.Unlock()
+	a.mu.Unlock()
+
+	res := a.read(&storagerpc.GetArgs{Key: key, Consistency: storagerpc.ConsistencyAll}, false)
+	if res.status != storagerpc.OK || res.value != "new" {
+		t.Errorf("Get(%s) = %v %q, want OK \"new\"", key, res.status, res.value)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if rec := a.find(key); rec == nil || rec.value != "new" {
+		t.Errorf("%s = %+v after Get, want b's value", key, rec)
+	}
+	if holders := a.leases[key]; len(holders) != 0 {
+		t.Errorf("leases on %s = %v after catching up, want none", key, holders)
+	}
+}
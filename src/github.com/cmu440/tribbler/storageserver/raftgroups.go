@@ -0,0 +1,221 @@
+package storageserver
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/raft"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// WithRaft replicates each hash range with a Raft group made up of the
+// servers that store it (the range's owner and the servers that follow it;
+// see WithReplication), in place of primary-backup replication. Writes are
+// committed to the group's log before being applied, reads are served by the
+// group's leader while it holds a read lease, and any other member answers
+// with status NotLeader and the leader's address.
+func WithRaft() Option {
+	return func(ss *storageServer) {
+		ss.raftHost = raft.NewHost()
+		ss.raftResults = newRaftResults()
+	}
+}
+
+// WithRaftDir sets the directory in which the server's Raft groups persist
+// their terms, votes and logs, so that a server restarted on the same port
+// with the same node ID rejoins its groups without losing committed writes,
+// which it applies again to rebuild its store. The directory must belong to
+// this server alone. Without it, Raft state is kept in memory only. It has no
+// effect without WithRaft.
+func WithRaftDir(dir string) Option {
+	return func(ss *storageServer) {
+		ss.raftDir = dir
+	}
+}
+
+// raftRPCTimeout bounds each Raft RPC between storage servers.
+const raftRPCTimeout = time.Second
+
+// rangeGroup names the Raft group that replicates r.
+func rangeGroup(r storagerpc.HashRange) string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// encodeWrite encodes w as a Raft command.
+func encodeWrite(w storagerpc.Write) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(w); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeWrite decodes a Raft command created by encodeWrite.
+func decodeWrite(command []byte) (storagerpc.Write, error) {
+	var w storagerpc.Write
+	err := gob.NewDecoder(bytes.NewReader(command)).Decode(&w)
+	return w, err
+}
+
+// raftResults holds the status each applied write produced, until the
+// write's proposer collects it.
+type raftResults struct {
+	mu       sync.Mutex
+	statuses map[string]map[uint64]storagerpc.Status // By group, then log index.
+}
+
+func newRaftResults() *raftResults {
+	return &raftResults{statuses: make(map[string]map[uint64]storagerpc.Status)}
+}
+
+// record stores the status of the write applied at index in group. Only
+// writes this server proposed are recorded, since no one else collects them.
+func (r *raftResults) record(group string, index uint64, status storagerpc.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.statuses[group] == nil {
+		r.statuses[group] = make(map[uint64]storagerpc.Status)
+	}
+	r.statuses[group][index] = status
+}
+
+// take removes and returns the status of the write applied at index in
+// group, or NotReady if none was recorded.
+func (r *raftResults) take(group string, index uint64) storagerpc.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.statuses[group][index]
+	if !ok {
+		return storagerpc.NotReady
+	}
+	delete(r.statuses[group], index)
+	return status
+}
+
+// proposeWrite commits w to n's group and returns the status it was applied
+// with, or NotLeader and the leader to redirect to. If the write can't be
+// committed for any other reason (e.g. leadership changed hands while it was
+// in flight), it returns NotReady so that the client retries.
+func (ss *storageServer) proposeWrite(n *raft.Node, w storagerpc.Write) (storagerpc.Status, string) {
+	command, err := encodeWrite(w)
+	if err != nil {
+		return storagerpc.NotReady, ""
+	}
+	index, err := n.Propose(command)
+	switch err := err.(type) {
+	case nil:
+		return ss.raftResults.take(n.Group(), index), ""
+	case *raft.NotLeaderError:
+		return storagerpc.NotLeader, err.Leader
+	default:
+		return storagerpc.NotReady, ""
+	}
+}
+
+// mayRead reports whether this server may answer a read from n's group
+// itself. If not, it returns the status to reply with: NotLeader and the
+// leader's address, or NotReady if the leader isn't known or hasn't yet
+// secured its read lease.
+func mayRead(n *raft.Node) (bool, storagerpc.Status, string) {
+	ok, leader := n.ReadLease()
+	switch {
+	case ok:
+		return true, storagerpc.OK, ""
+	case leader != "":
+		return false, storagerpc.NotLeader, leader
+	default:
+		return false, storagerpc.NotReady, ""
+	}
+}
+
+// startRaft joins the Raft group of every range this server stores: its own
+// and those of the replication-1 servers preceding it. Each group's state is
+// restored from dir, unless dir is empty.
+func (ss *storageServer) startRaft(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	servers := ss.ring()
+	transport := raft.NewRPCTransport(raftRPCTimeout)
+	for _, owner := range servers {
+		members := rangeMembers(owner, servers, ss.replication)
+		peers := make([]string, 0, len(members))
+		member := false
+		for _, node := range members {
+			peers = append(peers, node.HostPort)
+			member = member || node.NodeID == ss.nodeID
+		}
+		if !member {
+			continue
+		}
+		group := rangeGroup(ss.ringRange(owner.NodeID, servers))
+		n, err := raft.NewNode(raft.Config{
+			Group: group,
+			Self:  ss.hostPort,
+			Peers: peers,
+			Apply: func(index uint64, command []byte) {
+				ss.applyCommitted(group, index, command)
+			},
+			Dir: dir,
+		}, transport)
+		if err != nil {
+			ss.raftHost.StopAll()
+			return err
+		}
+		ss.raftHost.Add(n)
+	}
+	return nil
+}
+
+// applyCommitted applies a write committed at index in group's log, and
+// records its status if this server proposed it.
+func (ss *storageServer) applyCommitted(group string, index uint64, command []byte) {
+	status := storagerpc.NotReady
+	if w, err := decodeWrite(command); err == nil {
+		ss.mu.Lock()
+		status, _ = ss.apply(w, time.Now())
+		ss.mu.Unlock()
+	}
+	if n := ss.raftHost.Node(group); n != nil && n.Proposed(index) {
+		ss.raftResults.record(group, index, status)
+	}
+}
+
+// raftNode returns this server's member of the Raft group replicating key,
+// or nil if it isn't in that group.
+func (ss *storageServer) raftNode(key string) *raft.Node {
+	servers := ss.ring()
+	owner := ss.partitioner.Owner(key, servers)
+	return ss.raftHost.Node(rangeGroup(ss.ringRange(owner.NodeID, servers)))
+}
+
+// rangeMembers returns the n servers that store the range owned by owner:
+// owner itself, followed by the servers after it in order of NodeID,
+// wrapping around.
+func rangeMembers(owner storagerpc.Node, servers []storagerpc.Node, n int) []storagerpc.Node {
+	sorted := append([]storagerpc.Node(nil), servers...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].NodeID < sorted[j].NodeID
+	})
+	start := sort.Search(len(sorted), func(i int) bool {
+		return sorted[i].NodeID >= owner.NodeID
+	})
+	if n < 1 {
+		n = 1
+	}
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+	members := make([]storagerpc.Node, n)
+	for i := range members {
+		members[i] = sorted[(start+i)%len(sorted)]
+	}
+	return members
+}
@@ -0,0 +1,21 @@
+package storageserver
+
+import (
+	"testing"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+func TestRaftResultsTake(t *testing.T) {
+	r := newRaftResults()
+	r.record("g", 3, storagerpc.ItemExists)
+	if got := r.take("g", 3); got != storagerpc.ItemExists {
+		t.Errorf("take(g, 3) = %v, want ItemExists", got)
+	}
+	if got := r.take("g", 3); got != storagerpc.NotReady {
+		t.Errorf("second take(g, 3) = %v, want NotReady", got)
+	}
+	if got := r.take("h", 1); got != storagerpc.NotReady {
+		t.Errorf("take(h, 1) = %v, want NotReady", got)
+	}
+}
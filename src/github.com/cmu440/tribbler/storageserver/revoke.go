@@ -0,0 +1,200 @@
+package storageserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// Default revocation settings.
+const (
+	defaultRevokeConcurrency = 16
+	defaultRevokeTimeout     = time.Second
+)
+
+// WithRevocation sets how many RevokeLease RPCs a single write may have
+// outstanding at once, and how long each may take before its holder is given
+// up on (freeing its slot for another holder). A holder that is given up on
+// is waited out: the write proceeds once it acks after all or once its lease
+// has expired, whichever comes first.
+func WithRevocation(concurrency int, timeout time.Duration) Option {
+	return func(ss *storageServer) {
+		ss.revoker.concurrency = concurrency
+		ss.revoker.timeout = timeout
+	}
+}
+
+// leaseHolder is a Libstore holding a lease on some key.
+type leaseHolder struct {
+	hostPort string    // The Libstore's callback address.
+	expires  time.Time // When the lease (plus its guard period) runs out.
+}
+
+// revoker revokes leases on behalf of writes.
+type revoker struct {
+	concurrency int
+	timeout     time.Duration
+
+	clients *clientPool // Connections to libstores.
+
+	mu       sync.Mutex
+	stats    adminrpc.RevocationStats
+	pending  int                      // Revocations in progress.
+	departed map[string]chan struct{} // Closed when a libstore releases its leases, by host:port.
+}
+
+func newRevoker() *revoker {
+	return &revoker{
+		concurrency: defaultRevokeConcurrency,
+		timeout:     defaultRevokeTimeout,
+		clients:     newClientPool(),
+		departed:    make(map[string]chan struct{}),
+	}
+}
+
+// revokeAll revokes the leases that holders have on key, in parallel, and
+// returns once every holder has either acknowledged the revocation or seen
+// its lease expire.
+func (r *revoker) revokeAll(key string, holders []leaseHolder) {
+	if len(holders) == 0 {
+		return
+	}
+	start := time.Now()
+	slots := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+	for _, h := range holders {
+		wg.Add(1)
+		r.mu.Lock()
+		r.pending++
+		r.mu.Unlock()
+		go func(h leaseHolder) {
+			defer wg.Done()
+			slots <- struct{}{}
+			acked := r.revoke(key, h, func() { <-slots })
+			r.record(acked, time.Since(start))
+		}(h)
+	}
+	wg.Wait()
+}
+
+// revoke revokes h's lease on key, calling release as soon as the RPC has
+// completed or timed out. It returns true if h acknowledged the revocation
+// (or released all its leases) and false if its lease had to be waited out.
+func (r *revoker) revoke(key string, h leaseHolder, release func()) bool {
+	expired := time.NewTimer(time.Until(h.expires))
+	defer expired.Stop()
+	gone := r.departure(h.hostPort)
+
+	cli, err := r.clients.get(h.hostPort)
+	if err != nil {
+		release()
+		return waitOut(expired, gone)
+	}
+	args := &storagerpc.RevokeLeaseArgs{Key: key}
+	var reply storagerpc.RevokeLeaseReply
+	call := cli.Go("LeaseCallbacks.RevokeLease", args, &reply, nil)
+
+	timeout := time.NewTimer(r.timeout)
+	defer timeout.Stop()
+	released := false
+	for {
+		select {
+		case <-call.Done:
+			if !released {
+				release()
+			}
+			if call.Error == nil {
+				// Both OK and KeyNotFound mean the holder no longer
+				// caches the key.
+				return true
+			}
+			r.clients.drop(h.hostPort, cli)
+			return waitOut(expired, gone)
+		case <-timeout.C:
+			release()
+			released = true
+		case <-expired.C:
+			if !released {
+				release()
+			}
+			return false
+		case <-gone:
+			if !released {
+				release()
+			}
+			return true
+		}
+	}
+}
+
+// waitOut waits for a lease to expire, or for its holder to release it, and
+// reports whether the holder released it.
+func waitOut(expired *time.Timer, gone <-chan struct{}) bool {
+	select {
+	case <-expired.C:
+		return false
+	case <-gone:
+		return true
+	}
+}
+
+// departure returns a channel that is closed when the libstore at hostPort
+// releases its leases.
+func (r *revoker) departure(hostPort string) <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch, ok := r.departed[hostPort]
+	if !ok {
+		ch = make(chan struct{})
+		r.departed[hostPort] = ch
+	}
+	return ch
+}
+
+// forget ends every revocation waiting on the libstore at hostPort, which has
+// released its leases, and closes the connection to it.
+func (r *revoker) forget(hostPort string) {
+	r.mu.Lock()
+	if ch, ok := r.departed[hostPort]; ok {
+		close(ch)
+		delete(r.departed, hostPort)
+	}
+	r.mu.Unlock()
+	if cli := r.clients.peek(hostPort); cli != nil {
+		r.clients.drop(hostPort, cli)
+	}
+}
+
+// record adds the outcome of one revocation to the revoker's statistics.
+func (r *revoker) record(acked bool, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending--
+	s := &r.stats
+	s.Revocations++
+	if acked {
+		s.Acked++
+	} else {
+		s.WaitedOut++
+	}
+	s.TotalLatency += latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+}
+
+// inProgress returns the number of revocations in progress.
+func (r *revoker) inProgress() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pending
+}
+
+// snapshot returns a copy of the revoker's statistics.
+func (r *revoker) snapshot() adminrpc.RevocationStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
@@ -0,0 +1,116 @@
+package storageserver
+
+import (
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// fakeHolder is a libstore holding leases. It acknowledges revocations at
+// once, unless it hangs, in which case it answers none of them until the
+// test ends.
+type fakeHolder struct {
+	hostPort string
+	hang     chan struct{} // If non-nil, revocations wait until it is closed.
+
+	mu      sync.Mutex
+	revoked []time.Time // When each revocation arrived.
+}
+
+// startFakeHolder starts a fakeHolder serving LeaseCallbacks.
+func startFakeHolder(t *testing.T, hang bool) *fakeHolder {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &fakeHolder{hostPort: l.Addr().String()}
+	if hang {
+		h.hang = make(chan struct{})
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("LeaseCallbacks", h); err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, srv)
+	t.Cleanup(func() {
+		if h.hang != nil {
+			close(h.hang)
+		}
+		l.Close()
+	})
+	return h
+}
+
+func (h *fakeHolder) RevokeLease(args *storagerpc.RevokeLeaseArgs, reply *storagerpc.RevokeLeaseReply) error {
+	h.mu.Lock()
+	h.revoked = append(h.revoked, time.Now())
+	h.mu.Unlock()
+	if h.hang != nil {
+		<-h.hang
+	}
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+// revocations returns when each revocation h received arrived.
+func (h *fakeHolder) revocations() []time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]time.Time(nil), h.revoked...)
+}
+
+func TestRevokeWaitsOutSilentHolder(t *testing.T) {
+	silent, prompt := startFakeHolder(t, true), startFakeHolder(t, false)
+	r := newRevoker()
+	r.concurrency, r.timeout = 1, 50*time.Millisecond
+
+	// With a single slot, the prompt holder is only reached once the silent
+	// one has timed out; the write then waits out the silent one's lease.
+	start := time.Now()
+	expires := start.Add(300 * time.Millisecond)
+	r.revokeAll("k", []leaseHolder{{silent.hostPort, expires}, {prompt.hostPort, expires}})
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("revokeAll took %v, want about the 300ms until the lease expired", elapsed)
+	}
+	if got := prompt.revocations(); len(got) != 1 || !got[0].Before(expires) {
+		t.Errorf("prompt holder revoked at %v, want once before the lease expired", got)
+	}
+	if got := silent.revocations(); len(got) != 1 {
+		t.Errorf("silent holder revoked %d times, want once", len(got))
+	}
+	if s := r.snapshot(); s.Revocations != 2 || s.Acked != 1 || s.WaitedOut != 1 {
+		t.Errorf("stats = %+v, want 2 revocations, 1 acked and 1 waited out", s)
+	}
+	if n := r.inProgress(); n != 0 {
+		t.Errorf("%d revocations in progress after revokeAll, want 0", n)
+	}
+}
+
+func TestRevokeEndsWhenHolderReleases(t *testing.T) {
+	silent := startFakeHolder(t, true)
+	r := newRevoker()
+	r.timeout = 10 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		r.revokeAll("k", []leaseHolder{{silent.hostPort, time.Now().Add(time.Minute)}})
+		close(done)
+	}()
+	for len(silent.revocations()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r.forget(silent.hostPort)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("revokeAll still waiting after the holder released its leases")
+	}
+	if s := r.snapshot(); s.Acked != 1 || s.WaitedOut != 0 {
+		t.Errorf("stats = %+v, want the revocation acked", s)
+	}
+}
@@ -2,7 +2,12 @@
 
 package storageserver
 
-import "github.com/cmu440/tribbler/rpc/storagerpc"
+import (
+	"context"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
 
 // StorageServer defines the set of methods that can be invoked remotely via RPCs.
 type StorageServer interface {
@@ -13,6 +18,20 @@ type StorageServer interface {
 	// of all connected nodes in the ring.
 	RegisterServer(*storagerpc.RegisterArgs, *storagerpc.RegisterReply) error
 
+	// UnregisterServer removes a storage server that is shutting down from
+	// the ring, so that GetServers no longer reports it. The server's keys
+	// pass to its successor, so it must have moved its ranges there first
+	// (see Close). Before replying, the master sends the remaining servers
+	// the new ring (see SetServers). It replies with status KeyNotFound if
+	// the server isn't in the ring.
+	UnregisterServer(*storagerpc.UnregisterArgs, *storagerpc.UnregisterReply) error
+
+	// SetServers replaces a slave's list of the nodes in the ring, which
+	// decides which keys it owns and where it replicates them, with the
+	// master's, after a server has left the ring. The master replies with
+	// status WrongServer.
+	SetServers(*storagerpc.SetServersArgs, *storagerpc.SetServersReply) error
+
 	// GetServers retrieves a list of all connected nodes in the ring. It
 	// replies with status NotReady if not all nodes in the ring have joined.
 	GetServers(*storagerpc.GetServersArgs, *storagerpc.GetServersReply) error
@@ -55,4 +74,117 @@ type StorageServer interface {
 	// the specified value is not already contained in the list, it should reply
 	// with status ItemNotFound.
 	RemoveFromList(*storagerpc.PutArgs, *storagerpc.PutReply) error
+
+	// Batch applies a sequence of writes, in order, as if each had been
+	// sent as its own Put, Delete, AppendToList or RemoveFromList RPC
+	// (including revoking any leases on the written keys). It replies with
+	// one status per write, each exactly as the corresponding RPC would have.
+	Batch(*storagerpc.BatchArgs, *storagerpc.BatchReply) error
+
+	// ReleaseLeases forgets every lease held by the Libstore at HostPort,
+	// which is shutting down, so that later writes don't try to revoke
+	// them. Writes already waiting on one of its revocations proceed at
+	// once.
+	ReleaseLeases(*storagerpc.ReleaseLeasesArgs, *storagerpc.ReleaseLeasesReply) error
+
+	// TransferRange moves every key (value or list) whose hash falls in
+	// the given range to the target node, via one or more ReceiveRange
+	// RPCs. While the move is in progress, writes to keys in the range
+	// block and no new leases are granted on them; every outstanding lease
+	// on a moved key is revoked before the keys are sent. Once the target
+	// has the keys, the master is told to route the range to it (see
+	// AssignRange); only then is the target told to take the range on,
+	// and the keys deleted locally, operations on them answered with
+	// status WrongServer, and the move reported done. If the master can't
+	// be told, or the target can't be told after it, the move is abandoned
+	// (and the master told to route the range back) and the range stays on
+	// this server. It replies with status WrongServer if the range is not
+	// owned by this server.
+	TransferRange(*storagerpc.TransferRangeArgs, *storagerpc.TransferRangeReply) error
+
+	// ReceiveRange stores one chunk of keys sent by another server's
+	// TransferRange. Once the chunk with Done set has been stored, which
+	// the sender sends only after the master routes the range here, this
+	// server takes ownership of the range.
+	ReceiveRange(*storagerpc.ReceiveRangeArgs, *storagerpc.ReceiveRangeReply) error
+
+	// MerkleTree returns the top Depth levels of the Merkle tree over the
+	// keys this server stores in the given range, so that a replica can
+	// tell which parts of the range differ from its own copy.
+	MerkleTree(*storagerpc.MerkleTreeArgs, *storagerpc.MerkleTreeReply) error
+
+	// KeyDigests returns the digest and version of every key, tombstones
+	// included, this server stores in the given ranges.
+	KeyDigests(*storagerpc.KeyDigestsArgs, *storagerpc.KeyDigestsReply) error
+
+	// RepairKeys overwrites and deletes keys as instructed by the primary
+	// of their range during anti-entropy repair, keeping any whose version
+	// here is higher, and returns the state of the keys the primary wants
+	// to fetch. Unlike a write, it revokes no leases, since a replica
+	// grants none.
+	RepairKeys(*storagerpc.RepairKeysArgs, *storagerpc.RepairKeysReply) error
+
+	// Replicate stores the state of a key sent by the key's primary, unless
+	// this server already holds a newer version of it.
+	Replicate(*storagerpc.ReplicateArgs, *storagerpc.ReplicateReply) error
+
+	// AssignRange records, on the master, that a range has been moved to
+	// a new owner by TransferRange, so that GetServers reports it to
+	// libstores. Other servers reply with status WrongServer.
+	AssignRange(*storagerpc.AssignRangeArgs, *storagerpc.AssignRangeReply) error
+
+	// Drain stops the server from admitting new requests, which are
+	// answered with status NotReady, and waits until every request in
+	// progress (including the lease revocations of in-flight writes) has
+	// completed, or until ctx is done. It is not an RPC.
+	Drain(ctx context.Context) error
+
+	// Close hands the server's ranges to its successor and deregisters it
+	// from the master, drains it (waiting at most the WithDrainTimeout
+	// timeout, 30 seconds by default, and not at all if Drain has already
+	// been called), stops its Raft groups, flushes its lease journal and
+	// stops listening. The master, servers replicating with Raft and
+	// servers whose ranges can't be moved stay on the ring. Calls after
+	// the first return the first call's error. It is not an RPC.
+	Close() error
+}
+
+// Admin defines the set of methods that operators can invoke remotely via RPCs
+// to inspect, and drain, a running storage server.
+type Admin interface {
+
+	// HotPrefixes returns the (at most) N key prefixes with the highest
+	// request rates on this server, hottest first. Since all of a user's
+	// keys share a prefix, these identify the users making the server a
+	// hotspot.
+	HotPrefixes(*adminrpc.HotPrefixesArgs, *adminrpc.HotPrefixesReply) error
+
+	// RevocationStats reports how many leases the server has revoked and
+	// how long writes have waited on the revocations.
+	RevocationStats(*adminrpc.RevocationStatsArgs, *adminrpc.RevocationStatsReply) error
+
+	// WriteQueues reports, for every key with writes in progress, how many
+	// writes are queued on it (including the one in progress).
+	WriteQueues(*adminrpc.WriteQueuesArgs, *adminrpc.WriteQueuesReply) error
+
+	// Load splits the hash range owned by the server into (about) Buckets
+	// parts and reports the number of keys, bytes stored and request rate
+	// for each, for use by the rebalancer.
+	Load(*adminrpc.LoadArgs, *adminrpc.LoadReply) error
+
+	// Repair immediately compares every range this server is the primary
+	// of with each of the range's replicas and, unless DryRun is set,
+	// repairs the replicas. It reports how far each replica had diverged.
+	Repair(*adminrpc.RepairArgs, *adminrpc.RepairReply) error
+
+	// Stats reports what the server stores (key and list counts, bytes
+	// and owned hash ranges), its outstanding leases and revocations, and
+	// how many requests it has handled for each method and how long they
+	// took.
+	Stats(*adminrpc.StatsArgs, *adminrpc.StatsReply) error
+
+	// DrainServer calls Drain, waiting at most Timeout for the requests in
+	// progress, so that the server can be drained by another process
+	// before it is closed.
+	DrainServer(*adminrpc.DrainServerArgs, *adminrpc.DrainServerReply) error
 }
@@ -1,13 +1,71 @@
 package storageserver
 
 import (
-	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/cmu440/tribbler/libstore"
+	"github.com/cmu440/tribbler/metrics"
+	"github.com/cmu440/tribbler/raft"
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/raftrpc"
 	"github.com/cmu440/tribbler/rpc/storagerpc"
 )
 
+// registerRetryInterval is how long a slave waits before asking the master to
+// register it again, while the ring is still forming or the master is not
+// yet up.
+const registerRetryInterval = time.Second
+
 type storageServer struct {
-	// TODO: implement this!
+	nodeID         uint32
+	hostPort       string       // This server's own host:port.
+	masterHostPort string       // "" if this server is the master.
+	numNodes       int          // Number of nodes the ring is made of.
+	listener       net.Listener // Closed by Close.
+	gate           *drainGate   // Admits requests until the server drains.
+
+	drainTimeout time.Duration // How long Close waits for requests in progress.
+	closeOnce    sync.Once
+	closeErr     error // What the first Close returned.
+
+	partitioner libstore.Partitioner
+	servers     []storagerpc.Node // All nodes in the ring, once they have joined; guarded by mu.
+	ready       chan struct{}     // Closed once every node has joined the ring.
+	stop        chan struct{}     // Closed by Close to stop background work.
+	hot         *hotTracker       // Request rates per key prefix.
+	revoker     *revoker          // Revokes leases before writes are applied.
+	writes      *writeQueues      // Serializes the writes to each key.
+	metrics     *metrics.Methods  // Request counts, statuses and latencies, by method.
+	granted     uint64            // Leases granted; updated atomically.
+
+	mu     sync.Mutex
+	store  map[string]*record       // Every key this server stores, by key.
+	leases map[string][]leaseHolder // Outstanding leases, by key.
+
+	incoming map[storagerpc.HashRange]*incomingRange // Keys of ranges being received, held aside until Done.
+
+	journalPath string // Where to record leases; see WithLeaseJournal.
+	noJournal   bool   // Set by WithoutLeaseJournal.
+	journal     *leaseJournal
+	fence       time.Time // Writes are refused with NotReady until then; guarded by mu.
+
+	moves    rangeMoves                   // Hash ranges moved, or being moved, to other servers.
+	assigned []storagerpc.RangeAssignment // On the master, every range moved by TransferRange; guarded by mu.
+
+	replication int         // Number of servers storing each key.
+	entropy     antiEntropy // Outcomes of comparing this server's ranges with their replicas.
+	peers       *clientPool // Connections to the other storage servers.
+
+	raftHost    *raft.Host   // Raft groups this server belongs to; nil unless WithRaft is given.
+	raftDir     string       // Where Raft groups persist their state; "" for memory only.
+	raftResults *raftResults // Statuses of writes applied through Raft.
 }
 
 // NewStorageServer creates and starts a new StorageServer. masterServerHostPort
@@ -16,40 +74,1063 @@ type storageServer struct {
 // servers in the ring. port is the port number that this server should listen on.
 // nodeID is a random, unsigned 32-bit ID identifying this server.
 //
-// This function should return only once all storage servers have joined the ring,
-// and should return a non-nil error if the storage server could not be started.
-func NewStorageServer(masterServerHostPort string, numNodes, port int, nodeID uint32) (StorageServer, error) {
-	return nil, errors.New("not implemented")
+// Keys are assigned to nodes by libstore.PrefixPartitioner unless another
+// partitioner is given with WithPartitioner. Every server in the ring must use
+// the same partitioner: a slave sends the name of its partitioner when it
+// registers, and the master rejects it with status WrongPartitioner if the
+// name doesn't match its own. Replication, Raft and TransferRange work on
+// ranges of hashes, so they need a libstore.RangePartitioner; NewStorageServer
+// refuses to replicate under any other, and TransferRange replies with status
+// WrongPartitioner.
+//
+// Besides the "StorageServer" service, the server registers an "Admin" service
+// (see the Admin interface and the adminrpc package) for operators, and
+// serves its metrics at metrics.Path, all on its own http.ServeMux.
+//
+// Writes to a key are applied one at a time, in arrival order. Before a write
+// is applied, every outstanding lease on the key is revoked; holders are
+// contacted in parallel (see WithRevocation), and only the leases of holders
+// that don't ack are waited out. No lease is granted on a key while a write
+// to it is pending. Writes to other keys are never blocked.
+//
+// With WithReplication, each key's primary (its owner) applies writes with a
+// version from nextVersion and forwards them to the key's replicas, answering
+// once as many replicas as the request's consistency level requires have the
+// write, or with status Unavailable if too few do. Reads above ConsistencyOne
+// combine the copies of enough replicas and repair those that are out of
+// date. Replicas answer reads at ConsistencyOne from their own copy, without
+// granting leases. Replicas are compared with their primary, and repaired,
+// every anti-entropy interval (see WithAntiEntropy).
+//
+// With WithRaft, each range is instead replicated by a Raft group: writes are
+// committed to the group's log before being applied, and only the group's
+// leader answers reads, grants leases and accepts writes; other members reply
+// with status NotLeader and the leader's address. Leases aren't replicated,
+// so a newly elected leader of a group that has been written to refuses writes
+// with status NotReady until LeaseSeconds+LeaseGuardSeconds after its
+// election, by which time every lease its predecessors granted has expired.
+//
+// To relieve a node of a celebrity user, restart the ring with a partitioner
+// created by libstore.NewSplitPartitioner, which spreads that user's posts
+// across all nodes.
+//
+// NewStorageServer returns once all storage servers have joined the ring, or
+// an error if the storage server could not be started.
+func NewStorageServer(masterServerHostPort string, numNodes, port int, nodeID uint32, opts ...Option) (StorageServer, error) {
+	ss := &storageServer{
+		nodeID:         nodeID,
+		masterHostPort: masterServerHostPort,
+		numNodes:       numNodes,
+		gate:           newDrainGate(),
+		drainTimeout:   defaultDrainTimeout,
+		stop:           make(chan struct{}),
+		partitioner:    libstore.PrefixPartitioner,
+		ready:          make(chan struct{}),
+		hot:            newHotTracker(),
+		revoker:        newRevoker(),
+		writes:         newWriteQueues(),
+		metrics:        metrics.NewMethods(adminrpc.LatencyBuckets),
+		store:          make(map[string]*record),
+		leases:         make(map[string][]leaseHolder),
+		replication:    1,
+		entropy:        newAntiEntropy(),
+		peers:          newClientPool(),
+	}
+	for _, opt := range opts {
+		opt(ss)
+	}
+	if _, ok := ss.partitioner.(libstore.RangePartitioner); !ok && (ss.replication > 1 || ss.raftHost != nil) {
+		return nil, fmt.Errorf("partitioner %s doesn't divide the ring into hash ranges, which replication needs", ss.partitioner.Name())
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	ss.listener = listener
+	port = listener.Addr().(*net.TCPAddr).Port
+	ss.hostPort = net.JoinHostPort("localhost", strconv.Itoa(port))
+
+	now := time.Now()
+	if ss.journalPath == "" && !ss.noJournal {
+		ss.journalPath = defaultJournalPath(port)
+	}
+	if ss.journalPath != "" {
+		journal, live, err := openLeaseJournal(ss.journalPath, now)
+		if err != nil {
+			return nil, ss.abort(err)
+		}
+		ss.journal = journal
+		ss.restoreLeases(live)
+	} else {
+		ss.fence = leaseFence(now)
+	}
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("StorageServer", storagerpc.Wrap(ss)); err != nil {
+		return nil, ss.abort(err)
+	}
+	if err := srv.RegisterName("Admin", adminrpc.Wrap(ss)); err != nil {
+		return nil, ss.abort(err)
+	}
+	if ss.raftHost != nil {
+		if err := srv.RegisterName("Raft", raftrpc.Wrap(ss.raftHost)); err != nil {
+			return nil, ss.abort(err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	mux.Handle(metrics.Path, metrics.Handler(ss.writeMetrics))
+	go http.Serve(listener, mux)
+
+	self := storagerpc.Node{HostPort: ss.hostPort, NodeID: nodeID}
+	if masterServerHostPort == "" {
+		ss.mu.Lock()
+		ss.servers = []storagerpc.Node{self}
+		ss.checkReady()
+		ss.mu.Unlock()
+	} else {
+		servers, err := ss.join(self)
+		if err != nil {
+			return nil, ss.abort(err)
+		}
+		ss.mu.Lock()
+		ss.servers = servers
+		close(ss.ready)
+		ss.mu.Unlock()
+	}
+	<-ss.ready
+
+	if ss.raftHost != nil {
+		if err := ss.startRaft(ss.raftDir); err != nil {
+			return nil, ss.abort(err)
+		}
+	} else if ss.replication > 1 && ss.entropy.interval > 0 {
+		go ss.runAntiEntropy()
+	}
+	return ss, nil
+}
+
+// abort releases what NewStorageServer has set up so far, and returns err.
+func (ss *storageServer) abort(err error) error {
+	ss.listener.Close()
+	if ss.journal != nil {
+		ss.journal.close()
+	}
+	return err
+}
+
+// join registers this server with the master, retrying until every node in
+// the ring has joined, and returns the ring's nodes.
+func (ss *storageServer) join(self storagerpc.Node) ([]storagerpc.Node, error) {
+	args := &storagerpc.RegisterArgs{ServerInfo: self, Partitioner: ss.partitioner.Name()}
+	for {
+		var reply storagerpc.RegisterReply
+		if ss.callPeer(storagerpc.Node{HostPort: ss.masterHostPort}, "StorageServer.RegisterServer", args, &reply) {
+			switch reply.Status {
+			case storagerpc.OK:
+				return reply.Servers, nil
+			case storagerpc.WrongPartitioner:
+				return nil, fmt.Errorf("storageserver: the ring doesn't use the %s partitioner", ss.partitioner.Name())
+			}
+		}
+		time.Sleep(registerRetryInterval)
+	}
+}
+
+// checkReady marks the ring as ready once every node has joined it. ss.mu
+// must be held.
+func (ss *storageServer) checkReady() {
+	if len(ss.servers) < ss.numNodes {
+		return
+	}
+	select {
+	case <-ss.ready:
+	default:
+		close(ss.ready)
+	}
+}
+
+// isReady reports whether every node has joined the ring.
+func (ss *storageServer) isReady() bool {
+	select {
+	case <-ss.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// ring returns the nodes in the ring. The slice must not be modified.
+func (ss *storageServer) ring() []storagerpc.Node {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.servers
+}
+
+// restoreLeases adds leases recovered from the lease journal to ss.leases.
+func (ss *storageServer) restoreLeases(records []leaseRecord) {
+	for _, rec := range records {
+		ss.leases[rec.Key] = append(ss.leases[rec.Key], leaseHolder{hostPort: rec.HostPort, expires: rec.Expires})
+	}
+}
+
+// acceptingWrites reports whether the server may apply writes at time now.
+func (ss *storageServer) acceptingWrites(now time.Time) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return !now.Before(ss.fence)
+}
+
+// ownsKey reports whether this server is responsible for key, taking into
+// account the ranges moved to and from it with TransferRange.
+func (ss *storageServer) ownsKey(key string) bool {
+	h := ss.partitioner.Hash(key)
+	if ss.moves.movedTo(h) != nil {
+		return false
+	}
+	return ss.moves.receivedHash(h) || ss.partitioner.Owner(key, ss.ring()).NodeID == ss.nodeID
+}
+
+// compatible reports whether a server using the named partitioner may join
+// this server's ring.
+func (ss *storageServer) compatible(partitioner string) bool {
+	p, ok := libstore.LookupPartitioner(partitioner)
+	return ok && p.Name() == ss.partitioner.Name()
+}
+
+// observe records an RPC to method that began at start and was answered with
+// *status.
+func (ss *storageServer) observe(method string, start time.Time, status *storagerpc.Status) {
+	ss.metrics.Observe(method, status.String(), time.Since(start))
+}
+
+// observeOK is observe for methods whose replies have no status of their own
+// (Batch and the Admin methods), which are counted as OK.
+func (ss *storageServer) observeOK(method string, start time.Time) {
+	status := storagerpc.OK
+	ss.observe(method, start, &status)
+}
+
+// readResult is the answer to a Get or GetList.
+type readResult struct {
+	replicaRead
+	lease  storagerpc.Lease
+	leader string // With status NotLeader, the leader's host:port.
+}
+
+// read answers a Get or, if isList is set, a GetList.
+func (ss *storageServer) read(args *storagerpc.GetArgs, isList bool) readResult {
+	now := time.Now()
+	ss.hot.record(args.Key, now)
+	if ss.raftHost != nil {
+		n := ss.raftNode(args.Key)
+		if n == nil {
+			return readResult{replicaRead: replicaRead{status: storagerpc.WrongServer}}
+		}
+		if ok, status, leader := mayRead(n); !ok {
+			return readResult{replicaRead: replicaRead{status: status}, leader: leader}
+		}
+		return ss.readLocal(args, isList, now)
+	}
+
+	if !ss.ownsKey(args.Key) {
+		if args.Consistency == storagerpc.ConsistencyOne && ss.isReplica(args.Key) {
+			ss.mu.Lock()
+			defer ss.mu.Unlock()
+			return readResult{replicaRead: ss.lookup(args.Key, isList)}
+		}
+		return readResult{replicaRead: replicaRead{status: storagerpc.WrongServer}}
+	}
+	local := ss.readLocal(args, isList, now)
+	replicas := ss.replicasOf(args.Key)
+	need := args.Consistency.Required(len(replicas) + 1)
+	if need <= 1 {
+		return local
+	}
+	local.node = storagerpc.Node{HostPort: ss.hostPort, NodeID: ss.nodeID}
+	reads, ok := ss.readReplicas(local.replicaRead, replicas, args.Key, isList, need)
+	if !ok {
+		return readResult{replicaRead: replicaRead{status: storagerpc.Unavailable}}
+	}
+	best := newest(reads)
+	ss.readRepair(args.Key, best, reads)
+	if best.version > local.version {
+		// This server missed a write; catch up, and don't hand out a
+		// lease on what it had.
+		ss.catchUp(args.Key, best)
+		return readResult{replicaRead: best}
+	}
+	return local
+}
+
+// catchUp stores a newer state of key read from a replica, as adopt does,
+// unless the read isn't the key's whole state.
+func (ss *storageServer) catchUp(key string, read replicaRead) {
+	if !read.repairable() {
+		return
+	}
+	ss.adopt(key, record{
+		value:   read.value,
+		list:    read.list,
+		isList:  read.isList,
+		deleted: read.deleted,
+		version: read.version,
+	})
+}
+
+// readLocal reads key from this server's own store, granting a lease if one
+// is wanted and may be granted.
+func (ss *storageServer) readLocal(args *storagerpc.GetArgs, isList bool, now time.Time) readResult {
+	var res readResult
+	h := ss.partitioner.Hash(args.Key)
+	if args.WantLease && !ss.moves.inMove(h) {
+		// The lease is journaled before it's known whether it will be
+		// granted, so that the sync happens outside the locks; a lease
+		// journaled but not granted only makes a restart more cautious.
+		expires := now.Add((storagerpc.LeaseSeconds + storagerpc.LeaseGuardSeconds) * time.Second)
+		if ss.journal == nil || ss.journal.record(args.Key, args.HostPort, expires) == nil {
+			granted := ss.moves.unlessMoving(h, func() bool {
+				return ss.writes.unlessPending(args.Key, func() {
+					ss.mu.Lock()
+					defer ss.mu.Unlock()
+					res.replicaRead = ss.lookup(args.Key, isList)
+					if res.status == storagerpc.OK {
+						ss.leases[args.Key] = append(ss.leases[args.Key], leaseHolder{hostPort: args.HostPort, expires: expires})
+						res.lease = storagerpc.Lease{Granted: true, ValidSeconds: storagerpc.LeaseSeconds}
+						atomic.AddUint64(&ss.granted, 1)
+					}
+				})
+			})
+			if granted {
+				return res
+			}
+		}
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	res.replicaRead = ss.lookup(args.Key, isList)
+	return res
+}
+
+// write applies w, returning its status and, with status NotLeader, the
+// leader's host:port.
+func (ss *storageServer) write(w storagerpc.Write) (storagerpc.Status, string) {
+	now := time.Now()
+	ss.hot.record(w.Key, now)
+	if !ss.acceptingWrites(now) {
+		return storagerpc.NotReady, ""
+	}
+	ss.writes.acquire(w.Key)
+	defer ss.writes.release(w.Key)
+
+	if ss.raftHost != nil {
+		n := ss.raftNode(w.Key)
+		if n == nil {
+			return storagerpc.WrongServer, ""
+		}
+		if leader, ok := n.Leader(); !ok {
+			return storagerpc.NotLeader, leader
+		}
+		// Lease grants aren't replicated, so a new leader waits out those
+		// its predecessors may have made before it writes.
+		if since, inherited, ok := n.LeaderSince(); ok && inherited && now.Before(leaseFence(since)) {
+			return storagerpc.NotReady, ""
+		}
+		ss.revokeLeases(w.Key)
+		return ss.proposeWrite(n, w)
+	}
+
+	// The write must be applied before any move of its key's range takes
+	// its snapshot, or be redirected once the move is done.
+	h := ss.partitioner.Hash(w.Key)
+	ss.moves.beginWrite(h)
+	if !ss.ownsKey(w.Key) {
+		ss.moves.endWrite(h)
+		return storagerpc.WrongServer, ""
+	}
+	ss.revokeLeases(w.Key)
+	ss.mu.Lock()
+	status, state := ss.apply(w, time.Now())
+	ss.mu.Unlock()
+	ss.moves.endWrite(h)
+	if state == nil {
+		return status, ""
+	}
+	if replicas := ss.replicasOf(w.Key); len(replicas) > 0 {
+		need := w.Consistency.Required(len(replicas)+1) - 1
+		if !ss.replicate(replicas, state, need) {
+			return storagerpc.Unavailable, ""
+		}
+	}
+	return status, ""
+}
+
+// revokeLeases revokes every outstanding lease on key and waits until each
+// has been acknowledged or has expired.
+func (ss *storageServer) revokeLeases(key string) {
+	now := time.Now()
+	ss.mu.Lock()
+	var holders []leaseHolder
+	for _, h := range ss.leases[key] {
+		if now.Before(h.expires) {
+			holders = append(holders, h)
+		}
+	}
+	delete(ss.leases, key)
+	ss.mu.Unlock()
+	ss.revoker.revokeAll(key, holders)
+}
+
+// replicasOf returns the servers other than this one that store key.
+func (ss *storageServer) replicasOf(key string) []storagerpc.Node {
+	if ss.replication <= 1 {
+		return nil
+	}
+	var replicas []storagerpc.Node
+	for _, node := range libstore.Replicas(ss.partitioner, key, ss.ring(), ss.replication) {
+		if node.NodeID != ss.nodeID {
+			replicas = append(replicas, node)
+		}
+	}
+	return replicas
+}
+
+// isReplica reports whether this server stores key on behalf of its primary.
+func (ss *storageServer) isReplica(key string) bool {
+	if ss.replication <= 1 {
+		return false
+	}
+	for _, node := range libstore.Replicas(ss.partitioner, key, ss.ring(), ss.replication)[1:] {
+		if node.NodeID == ss.nodeID {
+			return true
+		}
+	}
+	return false
 }
 
 func (ss *storageServer) RegisterServer(args *storagerpc.RegisterArgs, reply *storagerpc.RegisterReply) error {
-	return errors.New("not implemented")
+	defer ss.observe("RegisterServer", time.Now(), &reply.Status)
+	if !ss.compatible(args.Partitioner) {
+		reply.Status = storagerpc.WrongPartitioner
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	found := false
+	for _, node := range ss.servers {
+		if node.HostPort == args.ServerInfo.HostPort {
+			found = true
+			break
+		}
+	}
+	if !found {
+		// Copy on write, since ring's callers hold on to the old slice.
+		ss.servers = append(append([]storagerpc.Node(nil), ss.servers...), args.ServerInfo)
+		ss.checkReady()
+	}
+	if !ss.isReady() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	reply.Status = storagerpc.OK
+	reply.Servers = ss.servers
+	return nil
+}
+
+func (ss *storageServer) UnregisterServer(args *storagerpc.UnregisterArgs, reply *storagerpc.UnregisterReply) error {
+	defer ss.observe("UnregisterServer", time.Now(), &reply.Status)
+	ss.mu.Lock()
+	var servers []storagerpc.Node
+	for i, node := range ss.servers {
+		if node.HostPort == args.ServerInfo.HostPort {
+			servers = append(append([]storagerpc.Node(nil), ss.servers[:i]...), ss.servers[i+1:]...)
+			ss.servers = servers
+			break
+		}
+	}
+	ss.mu.Unlock()
+	if servers == nil {
+		reply.Status = storagerpc.KeyNotFound
+		return nil
+	}
+	ss.pushServers(servers)
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+// pushServers sends servers, the master's new ring, to every slave in it. A
+// slave that can't be reached keeps its old ring.
+func (ss *storageServer) pushServers(servers []storagerpc.Node) {
+	args := &storagerpc.SetServersArgs{Servers: servers}
+	var wg sync.WaitGroup
+	for _, node := range servers {
+		if node.NodeID == ss.nodeID {
+			continue
+		}
+		wg.Add(1)
+		go func(node storagerpc.Node) {
+			defer wg.Done()
+			var reply storagerpc.SetServersReply
+			ss.callPeer(node, "StorageServer.SetServers", args, &reply)
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (ss *storageServer) SetServers(args *storagerpc.SetServersArgs, reply *storagerpc.SetServersReply) error {
+	defer ss.observe("SetServers", time.Now(), &reply.Status)
+	if ss.masterHostPort == "" {
+		reply.Status = storagerpc.WrongServer
+		return nil
+	}
+	ss.mu.Lock()
+	ss.servers = args.Servers
+	ss.mu.Unlock()
+	reply.Status = storagerpc.OK
+	return nil
 }
 
 func (ss *storageServer) GetServers(args *storagerpc.GetServersArgs, reply *storagerpc.GetServersReply) error {
-	return errors.New("not implemented")
+	defer ss.observe("GetServers", time.Now(), &reply.Status)
+	if !ss.isReady() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	reply.Status = storagerpc.OK
+	reply.Servers = ss.ring()
+	reply.Partitioner = ss.partitioner.Name()
+	ss.mu.Lock()
+	reply.Assignments = ss.assigned
+	ss.mu.Unlock()
+	return nil
 }
 
 func (ss *storageServer) Get(args *storagerpc.GetArgs, reply *storagerpc.GetReply) error {
-	return errors.New("not implemented")
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("Get", time.Now(), &reply.Status)
+	res := ss.read(args, false)
+	reply.Status, reply.Value, reply.Lease = res.status, res.value, res.lease
+	reply.Version, reply.Leader, reply.Deleted = res.version, res.leader, res.deleted
+	return nil
 }
 
 func (ss *storageServer) Delete(args *storagerpc.DeleteArgs, reply *storagerpc.DeleteReply) error {
-	return errors.New("not implemented")
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("Delete", time.Now(), &reply.Status)
+	reply.Status, reply.Leader = ss.write(storagerpc.Write{Op: storagerpc.DeleteOp, Key: args.Key, Consistency: args.Consistency})
+	return nil
 }
 
 func (ss *storageServer) GetList(args *storagerpc.GetArgs, reply *storagerpc.GetListReply) error {
-	return errors.New("not implemented")
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("GetList", time.Now(), &reply.Status)
+	res := ss.read(args, true)
+	reply.Status, reply.Value, reply.Lease = res.status, res.list, res.lease
+	reply.Version, reply.Leader, reply.Deleted = res.version, res.leader, res.deleted
+	return nil
 }
 
 func (ss *storageServer) Put(args *storagerpc.PutArgs, reply *storagerpc.PutReply) error {
-	return errors.New("not implemented")
+	return ss.update("Put", storagerpc.PutOp, args, reply)
 }
 
 func (ss *storageServer) AppendToList(args *storagerpc.PutArgs, reply *storagerpc.PutReply) error {
-	return errors.New("not implemented")
+	return ss.update("AppendToList", storagerpc.AppendToListOp, args, reply)
 }
 
 func (ss *storageServer) RemoveFromList(args *storagerpc.PutArgs, reply *storagerpc.PutReply) error {
-	return errors.New("not implemented")
+	return ss.update("RemoveFromList", storagerpc.RemoveFromListOp, args, reply)
+}
+
+// update serves a Put, AppendToList or RemoveFromList RPC.
+func (ss *storageServer) update(method string, op storagerpc.WriteOp, args *storagerpc.PutArgs, reply *storagerpc.PutReply) error {
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe(method, time.Now(), &reply.Status)
+	reply.Status, reply.Leader = ss.write(storagerpc.Write{Op: op, Key: args.Key, Value: args.Value, Consistency: args.Consistency})
+	return nil
+}
+
+func (ss *storageServer) Batch(args *storagerpc.BatchArgs, reply *storagerpc.BatchReply) error {
+	reply.Statuses = make([]storagerpc.Status, len(args.Writes))
+	if !ss.gate.enter() {
+		for i := range reply.Statuses {
+			reply.Statuses[i] = storagerpc.NotReady
+		}
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observeOK("Batch", time.Now())
+	for i, w := range args.Writes {
+		reply.Statuses[i], _ = ss.write(w)
+	}
+	return nil
+}
+
+func (ss *storageServer) ReleaseLeases(args *storagerpc.ReleaseLeasesArgs, reply *storagerpc.ReleaseLeasesReply) error {
+	defer ss.observe("ReleaseLeases", time.Now(), &reply.Status)
+	ss.mu.Lock()
+	for key, holders := range ss.leases {
+		kept := holders[:0]
+		for _, h := range holders {
+			if h.hostPort != args.HostPort {
+				kept = append(kept, h)
+			}
+		}
+		if len(kept) == 0 {
+			delete(ss.leases, key)
+		} else {
+			ss.leases[key] = kept
+		}
+	}
+	ss.mu.Unlock()
+	ss.revoker.forget(args.HostPort)
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+func (ss *storageServer) TransferRange(args *storagerpc.TransferRangeArgs, reply *storagerpc.TransferRangeReply) error {
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("TransferRange", time.Now(), &reply.Status)
+	return ss.transferRange(args, reply)
+}
+
+// transferRange moves a range to another server, as TransferRange does, but
+// whether or not the server is draining.
+func (ss *storageServer) transferRange(args *storagerpc.TransferRangeArgs, reply *storagerpc.TransferRangeReply) error {
+	if _, ok := ss.partitioner.(libstore.RangePartitioner); !ok {
+		reply.Status = storagerpc.WrongPartitioner
+		return nil
+	}
+	if !ss.ownsRange(args.Range) {
+		reply.Status = storagerpc.WrongServer
+		return nil
+	}
+	mv, err := ss.moves.begin(args.Range)
+	if err != nil {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+
+	// No lease can be granted on the range from here on; revoke those
+	// already granted before sending the keys.
+	var keys []string
+	ss.mu.Lock()
+	for key := range ss.leases {
+		if args.Range.Contains(ss.partitioner.Hash(key)) {
+			keys = append(keys, key)
+		}
+	}
+	ss.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			ss.revokeLeases(key)
+		}(key)
+	}
+	wg.Wait()
+
+	// The target takes the range on only once the master routes it
+	// there; otherwise both servers would claim it if the master couldn't
+	// be told.
+	values, lists := ss.snapshot(args.Range)
+	target, err := ss.peers.get(args.Target.HostPort)
+	if err != nil {
+		ss.moves.finish(mv, nil)
+		return err
+	}
+	// Drop whatever an abandoned earlier move of the range left aside on
+	// the target before sending the keys.
+	err = abortRange(target, args.Range)
+	if err == nil {
+		err = sendRange(target, args.Range, values, lists, false)
+	}
+	if err == nil {
+		err = ss.publish(storagerpc.RangeAssignment{Range: args.Range, Owner: args.Target})
+		if err == nil {
+			if err = sendRange(target, args.Range, nil, nil, true); err != nil {
+				// Route the range back here, where every key still is.
+				// Until the master has it, libstores send the range to
+				// the target, which doesn't own it, so it stays refused
+				// here too.
+				self := storagerpc.Node{HostPort: ss.hostPort, NodeID: ss.nodeID}
+				back := storagerpc.RangeAssignment{Range: args.Range, Owner: self}
+				if perr := ss.publish(back); perr != nil {
+					abortRange(target, args.Range)
+					go ss.routeBack(mv, back)
+					return fmt.Errorf("%v; routing the range back failed, and is being retried: %v", err, perr)
+				}
+			}
+		}
+	}
+	if err != nil {
+		// If this fails too, the next move of the range drops the keys.
+		abortRange(target, args.Range)
+		ss.moves.finish(mv, nil)
+		return err
+	}
+	ss.mu.Lock()
+	for key := range values {
+		delete(ss.store, key)
+	}
+	for key := range lists {
+		delete(ss.store, key)
+	}
+	ss.mu.Unlock()
+	ss.moves.finish(mv, &args.Target)
+	reply.Status = storagerpc.OK
+	reply.Keys = len(values) + len(lists)
+	return nil
+}
+
+// routeBack publishes a, which routes the range of the failed move mv back to
+// this server, every routeBackRetryInterval until the master has it or the
+// server is closed, and then finishes mv.
+func (ss *storageServer) routeBack(mv *rangeMove, a storagerpc.RangeAssignment) {
+	defer ss.moves.finish(mv, nil)
+	for ss.publish(a) != nil {
+		select {
+		case <-ss.stop:
+			return
+		case <-time.After(routeBackRetryInterval):
+		}
+	}
+}
+
+// publish tells the master that a range has moved to a new owner, so that
+// libstores route it there.
+func (ss *storageServer) publish(a storagerpc.RangeAssignment) error {
+	if ss.masterHostPort == "" {
+		ss.assign(a)
+		return nil
+	}
+	master, err := ss.peers.get(ss.masterHostPort)
+	if err != nil {
+		return err
+	}
+	var reply storagerpc.AssignRangeReply
+	return callStatus(master, "StorageServer.AssignRange", &storagerpc.AssignRangeArgs{Assignment: a}, &reply, &reply.Status)
+}
+
+// assign records a on the master, dropping the parts of earlier assignments
+// that it overrides.
+func (ss *storageServer) assign(a storagerpc.RangeAssignment) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var assigned []storagerpc.RangeAssignment
+	for _, old := range ss.assigned {
+		for _, rest := range subtractRange(old.Range, a.Range) {
+			assigned = append(assigned, storagerpc.RangeAssignment{Range: rest, Owner: old.Owner})
+		}
+	}
+	ss.assigned = append(assigned, a)
+}
+
+func (ss *storageServer) AssignRange(args *storagerpc.AssignRangeArgs, reply *storagerpc.AssignRangeReply) error {
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("AssignRange", time.Now(), &reply.Status)
+	if ss.masterHostPort != "" {
+		reply.Status = storagerpc.WrongServer
+		return nil
+	}
+	ss.assign(args.Assignment)
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+// ownsRange reports whether every hash in r is owned by this server.
+func (ss *storageServer) ownsRange(r storagerpc.HashRange) bool {
+	rest := []storagerpc.HashRange{r}
+	for _, owned := range ss.ownedRanges() {
+		var next []storagerpc.HashRange
+		for _, part := range rest {
+			next = append(next, subtractRange(part, owned)...)
+		}
+		rest = next
+	}
+	return len(rest) == 0
+}
+
+// ownedRanges returns the hash ranges this server owns: its ring range less
+// the ranges it has moved away, plus those it has received. It returns nil if
+// the partitioner isn't a libstore.RangePartitioner.
+func (ss *storageServer) ownedRanges() []storagerpc.HashRange {
+	if _, ok := ss.partitioner.(libstore.RangePartitioner); !ok {
+		return nil
+	}
+	return ss.moves.owned(ss.ringRange(ss.nodeID, ss.ring()))
+}
+
+// ringRange returns the hash range owned by the node with the given ID on a
+// ring made up of nodes. The partitioner must be a libstore.RangePartitioner,
+// as NewStorageServer ensures whenever the server replicates ranges.
+func (ss *storageServer) ringRange(nodeID uint32, nodes []storagerpc.Node) storagerpc.HashRange {
+	return ss.partitioner.(libstore.RangePartitioner).Range(nodeID, nodes)
+}
+
+func (ss *storageServer) ReceiveRange(args *storagerpc.ReceiveRangeArgs, reply *storagerpc.ReceiveRangeReply) error {
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("ReceiveRange", time.Now(), &reply.Status)
+	reply.Status = storagerpc.OK
+	ss.mu.Lock()
+	if args.Abort {
+		delete(ss.incoming, args.Range)
+		ss.mu.Unlock()
+		return nil
+	}
+	in := ss.incoming[args.Range]
+	if in == nil {
+		in = &incomingRange{values: make(map[string]string), lists: make(map[string][]string)}
+		if ss.incoming == nil {
+			ss.incoming = make(map[storagerpc.HashRange]*incomingRange)
+		}
+		ss.incoming[args.Range] = in
+	}
+	for key, value := range args.Values {
+		in.values[key] = value
+	}
+	for key, list := range args.Lists {
+		in.lists[key] = list
+	}
+	if !args.Done {
+		ss.mu.Unlock()
+		return nil
+	}
+
+	delete(ss.incoming, args.Range)
+	now := time.Now()
+	for key, value := range in.values {
+		ss.put(key, record{value: value, version: nextVersion(ss.version(key), now)}, now)
+	}
+	for key, list := range in.lists {
+		ss.put(key, record{list: list, isList: true, version: nextVersion(ss.version(key), now)}, now)
+	}
+	ss.mu.Unlock()
+	ss.moves.receive(args.Range)
+	return nil
+}
+
+func (ss *storageServer) MerkleTree(args *storagerpc.MerkleTreeArgs, reply *storagerpc.MerkleTreeReply) error {
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("MerkleTree", time.Now(), &reply.Status)
+	reply.Nodes = buildMerkleTree(args.Range, ss.partitioner.Hash, ss.records(args.Range)).top(args.Depth)
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+func (ss *storageServer) KeyDigests(args *storagerpc.KeyDigestsArgs, reply *storagerpc.KeyDigestsReply) error {
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("KeyDigests", time.Now(), &reply.Status)
+	reply.Digests, reply.Versions = keyDigests(args.Ranges, ss.partitioner.Hash, ss.records(storagerpc.HashRange{}))
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+func (ss *storageServer) RepairKeys(args *storagerpc.RepairKeysArgs, reply *storagerpc.RepairKeysReply) error {
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("RepairKeys", time.Now(), &reply.Status)
+	now := time.Now()
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	repair := func(key string, rec record) {
+		if version, ok := args.Versions[key]; ok {
+			if version < ss.version(key) {
+				return
+			}
+			rec.version = version
+		} else {
+			rec.version = nextVersion(ss.version(key), now)
+		}
+		ss.put(key, rec, now)
+	}
+	for key, value := range args.Values {
+		repair(key, record{value: value})
+	}
+	for key, list := range args.Lists {
+		repair(key, record{list: list, isList: true})
+	}
+	for _, key := range args.Deleted {
+		repair(key, record{deleted: true})
+	}
+
+	fetched := &storagerpc.RepairKeysArgs{Versions: make(map[string]uint64)}
+	for _, key := range args.Fetch {
+		if rec, ok := ss.store[key]; ok {
+			addRepair(fetched, key, rec)
+		}
+	}
+	reply.Values, reply.Lists, reply.Deleted, reply.Versions = fetched.Values, fetched.Lists, fetched.Deleted, fetched.Versions
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+func (ss *storageServer) Replicate(args *storagerpc.ReplicateArgs, reply *storagerpc.ReplicateReply) error {
+	if !ss.gate.enter() {
+		reply.Status = storagerpc.NotReady
+		return nil
+	}
+	defer ss.gate.leave()
+	defer ss.observe("Replicate", time.Now(), &reply.Status)
+	ss.mu.Lock()
+	if args.Version > ss.version(args.Key) {
+		ss.put(args.Key, record{
+			value:   args.Value,
+			list:    args.List,
+			isList:  args.IsList,
+			deleted: args.Deleted,
+			version: args.Version,
+		}, time.Now())
+	}
+	ss.mu.Unlock()
+	reply.Status = storagerpc.OK
+	return nil
+}
+
+func (ss *storageServer) HotPrefixes(args *adminrpc.HotPrefixesArgs, reply *adminrpc.HotPrefixesReply) error {
+	defer ss.observeOK("HotPrefixes", time.Now())
+	reply.Prefixes = ss.hot.top(args.N, time.Now())
+	return nil
+}
+
+func (ss *storageServer) RevocationStats(args *adminrpc.RevocationStatsArgs, reply *adminrpc.RevocationStatsReply) error {
+	defer ss.observeOK("RevocationStats", time.Now())
+	reply.Stats = ss.revoker.snapshot()
+	return nil
+}
+
+func (ss *storageServer) WriteQueues(args *adminrpc.WriteQueuesArgs, reply *adminrpc.WriteQueuesReply) error {
+	defer ss.observeOK("WriteQueues", time.Now())
+	reply.Queues = ss.writes.depths()
+	return nil
+}
+
+// Load attributes each key prefix's request rate to the bucket holding the
+// prefix's hash, which is exact for partitioners that keep a user's keys
+// together.
+func (ss *storageServer) Load(args *adminrpc.LoadArgs, reply *adminrpc.LoadReply) error {
+	defer ss.observeOK("Load", time.Now())
+	n := args.Buckets
+	if n < 1 {
+		n = 1
+	}
+	var buckets []adminrpc.BucketLoad
+	for _, r := range ss.ownedRanges() {
+		size := rangeSize(r)
+		parts := uint64(n)
+		if parts > size {
+			parts = size
+		}
+		for i := uint64(0); i < parts; i++ {
+			buckets = append(buckets, adminrpc.BucketLoad{Range: storagerpc.HashRange{
+				Start: r.Start + uint32(i*size/parts),
+				End:   r.Start + uint32((i+1)*size/parts),
+			}})
+		}
+	}
+	bucketOf := func(h uint32) *adminrpc.BucketLoad {
+		for i := range buckets {
+			if buckets[i].Range.Contains(h) {
+				return &buckets[i]
+			}
+		}
+		return nil
+	}
+
+	ss.mu.Lock()
+	for key, rec := range ss.store {
+		b := bucketOf(ss.partitioner.Hash(key))
+		if b == nil || rec.deleted {
+			continue
+		}
+		b.Keys++
+		b.Bytes += recordBytes(key, rec)
+	}
+	ss.mu.Unlock()
+	for _, p := range ss.hot.top(-1, time.Now()) {
+		if b := bucketOf(ss.partitioner.Hash(p.Prefix)); b != nil {
+			b.Rate += p.Rate
+		}
+	}
+	reply.Buckets = buckets
+	return nil
+}
+
+// recordBytes returns the size of key and its value or list.
+func recordBytes(key string, rec *record) int64 {
+	n := int64(len(key) + len(rec.value))
+	for _, item := range rec.list {
+		n += int64(len(item))
+	}
+	return n
+}
+
+func (ss *storageServer) Repair(args *adminrpc.RepairArgs, reply *adminrpc.RepairReply) error {
+	defer ss.observeOK("Repair", time.Now())
+	reply.Ranges = ss.repairAll(args.DryRun)
+	return nil
+}
+
+func (ss *storageServer) Stats(args *adminrpc.StatsArgs, reply *adminrpc.StatsReply) error {
+	defer ss.observeOK("Stats", time.Now())
+	now := time.Now()
+	ss.mu.Lock()
+	for key, rec := range ss.store {
+		if rec.deleted {
+			continue
+		}
+		if rec.isList {
+			reply.Lists++
+		} else {
+			reply.Keys++
+		}
+		reply.Bytes += recordBytes(key, rec)
+	}
+	reply.Leases = leaseCounts(ss.leases, now)
+	ss.mu.Unlock()
+	reply.Ranges = ss.ownedRanges()
+	reply.PendingRevocations = ss.revoker.inProgress()
+	reply.Methods = methodStats(ss.metrics.Snapshot())
+	return nil
 }
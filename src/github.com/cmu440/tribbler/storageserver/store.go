@@ -0,0 +1,177 @@
+package storageserver
+
+import (
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// tombstoneLifetime is how long a replicated server remembers that a key was
+// deleted. A replica that misses a delete and isn't repaired within this
+// time may bring the key back.
+const tombstoneLifetime = time.Hour
+
+// record is the state of a key: a value, a list or, once the key has been
+// deleted from a replicated ring, a tombstone, so that a replica that missed
+// the delete can be told apart from one that missed the key's creation.
+type record struct {
+	value   string
+	list    []string
+	isList  bool
+	deleted bool      // The key is a tombstone; value and list are empty.
+	version uint64    // See nextVersion.
+	written time.Time // When the record was last changed locally.
+}
+
+// find returns the live record for key, or nil if there is none. ss.mu must
+// be held.
+func (ss *storageServer) find(key string) *record {
+	if rec, ok := ss.store[key]; ok && !rec.deleted {
+		return rec
+	}
+	return nil
+}
+
+// version returns the version of key's record, tombstone or not. ss.mu must
+// be held.
+func (ss *storageServer) version(key string) uint64 {
+	if rec, ok := ss.store[key]; ok {
+		return rec.version
+	}
+	return 0
+}
+
+// lookup returns the status and state of key as a read of a value or, if
+// isList is set, a list would see them. ss.mu must be held.
+func (ss *storageServer) lookup(key string, isList bool) replicaRead {
+	rec, ok := ss.store[key]
+	if !ok {
+		return replicaRead{status: storagerpc.KeyNotFound}
+	}
+	read := replicaRead{status: storagerpc.KeyNotFound, isList: rec.isList, deleted: rec.deleted, version: rec.version}
+	if rec.deleted || rec.isList != isList {
+		return read
+	}
+	read.status = storagerpc.OK
+	read.value = rec.value
+	read.list = append([]string(nil), rec.list...)
+	return read
+}
+
+// apply applies w to the store at time now, returning the write's status and,
+// if it changed the key, the key's new state for the replicas. ss.mu must be
+// held.
+func (ss *storageServer) apply(w storagerpc.Write, now time.Time) (storagerpc.Status, *storagerpc.ReplicateArgs) {
+	rec := ss.find(w.Key)
+	var next record
+	switch w.Op {
+	case storagerpc.PutOp:
+		next = record{value: w.Value}
+	case storagerpc.DeleteOp:
+		if rec == nil {
+			return storagerpc.KeyNotFound, nil
+		}
+		next = record{deleted: true}
+	case storagerpc.AppendToListOp:
+		var list []string
+		if rec != nil && rec.isList {
+			for _, item := range rec.list {
+				if item == w.Value {
+					return storagerpc.ItemExists, nil
+				}
+			}
+			list = rec.list
+		}
+		next = record{list: append(append([]string(nil), list...), w.Value), isList: true}
+	case storagerpc.RemoveFromListOp:
+		if rec == nil || !rec.isList {
+			return storagerpc.ItemNotFound, nil
+		}
+		i := indexOf(rec.list, w.Value)
+		if i < 0 {
+			return storagerpc.ItemNotFound, nil
+		}
+		list := append([]string(nil), rec.list[:i]...)
+		next = record{list: append(list, rec.list[i+1:]...), isList: true}
+	default:
+		return storagerpc.NotReady, nil
+	}
+	next.version = nextVersion(ss.version(w.Key), now)
+	ss.put(w.Key, next, now)
+	return storagerpc.OK, &storagerpc.ReplicateArgs{
+		Key:     w.Key,
+		Value:   next.value,
+		List:    next.list,
+		IsList:  next.isList,
+		Deleted: next.deleted,
+		Version: next.version,
+	}
+}
+
+// put stores rec as key's record at time now. Without replication there is
+// nobody to tell about a delete, so tombstones aren't kept. ss.mu must be
+// held.
+func (ss *storageServer) put(key string, rec record, now time.Time) {
+	if rec.deleted && ss.replication <= 1 {
+		delete(ss.store, key)
+		return
+	}
+	rec.written = now
+	ss.store[key] = &rec
+}
+
+// sweepTombstones forgets the tombstones written before time cutoff.
+func (ss *storageServer) sweepTombstones(cutoff time.Time) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for key, rec := range ss.store {
+		if rec.deleted && rec.written.Before(cutoff) {
+			delete(ss.store, key)
+		}
+	}
+}
+
+// snapshot returns copies of the values and lists stored under keys whose
+// hash falls in r.
+func (ss *storageServer) snapshot(r storagerpc.HashRange) (map[string]string, map[string][]string) {
+	values := make(map[string]string)
+	lists := make(map[string][]string)
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for key, rec := range ss.store {
+		if rec.deleted || !r.Contains(ss.partitioner.Hash(key)) {
+			continue
+		}
+		if rec.isList {
+			lists[key] = append([]string(nil), rec.list...)
+		} else {
+			values[key] = rec.value
+		}
+	}
+	return values, lists
+}
+
+// records returns copies of the records, tombstones included, of the keys
+// whose hash falls in r.
+func (ss *storageServer) records(r storagerpc.HashRange) map[string]*record {
+	records := make(map[string]*record)
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for key, rec := range ss.store {
+		if r.Contains(ss.partitioner.Hash(key)) {
+			copied := *rec
+			records[key] = &copied
+		}
+	}
+	return records
+}
+
+// indexOf returns the index of item in list, or -1 if it isn't there.
+func indexOf(list []string, item string) int {
+	for i, x := range list {
+		if x == item {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,94 @@
+package storageserver
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+)
+
+// keyQueue holds the writes to a single key that are in progress or waiting.
+type keyQueue struct {
+	depth int             // Writes in progress (at most one) or waiting.
+	turns []chan struct{} // One per waiting write, in arrival order.
+}
+
+// writeQueues serializes the writes to each key. While a write to a key is
+// in progress (including while it waits for leases to be revoked) later
+// writes to the key queue up behind it and are applied in FIFO order, and no
+// new leases may be granted on the key.
+type writeQueues struct {
+	mu   sync.Mutex
+	keys map[string]*keyQueue
+}
+
+func newWriteQueues() *writeQueues {
+	return &writeQueues{keys: make(map[string]*keyQueue)}
+}
+
+// acquire blocks until all earlier writes to key have been released. Every
+// call must be followed by a call to release.
+func (q *writeQueues) acquire(key string) {
+	q.mu.Lock()
+	kq, ok := q.keys[key]
+	if !ok {
+		kq = new(keyQueue)
+		q.keys[key] = kq
+	}
+	kq.depth++
+	if kq.depth == 1 {
+		q.mu.Unlock()
+		return
+	}
+	turn := make(chan struct{})
+	kq.turns = append(kq.turns, turn)
+	q.mu.Unlock()
+	<-turn
+}
+
+// release completes the current write to key and lets the next one proceed.
+func (q *writeQueues) release(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kq := q.keys[key]
+	kq.depth--
+	if len(kq.turns) > 0 {
+		close(kq.turns[0])
+		kq.turns = kq.turns[1:]
+	} else if kq.depth == 0 {
+		delete(q.keys, key)
+	}
+}
+
+// unlessPending calls grant, and reports true, unless a write to key is in
+// progress or waiting, in which case a Get or GetList must not grant a lease
+// on it. grant runs with q locked, so a write to key that arrives meanwhile
+// isn't admitted until grant has returned, and sees the lease it grants.
+func (q *writeQueues) unlessPending(key string, grant func()) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.keys[key]; ok {
+		return false
+	}
+	grant()
+	return true
+}
+
+// depths returns the queue depth of every key with pending writes, deepest
+// first.
+func (q *writeQueues) depths() []adminrpc.QueueDepth {
+	q.mu.Lock()
+	depths := make([]adminrpc.QueueDepth, 0, len(q.keys))
+	for key, kq := range q.keys {
+		depths = append(depths, adminrpc.QueueDepth{Key: key, Depth: kq.depth})
+	}
+	q.mu.Unlock()
+
+	sort.Slice(depths, func(i, j int) bool {
+		if depths[i].Depth != depths[j].Depth {
+			return depths[i].Depth > depths[j].Depth
+		}
+		return depths[i].Key < depths[j].Key
+	})
+	return depths
+}
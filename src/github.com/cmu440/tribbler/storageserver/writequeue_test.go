@@ -0,0 +1,43 @@
+package storageserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteQueuesFIFO(t *testing.T) {
+	const n = 5
+	q := newWriteQueues()
+	q.acquire("k")
+
+	// Queue the writes one at a time, so that their arrival order is known.
+	order := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			q.acquire("k")
+			order <- i
+			q.release("k")
+		}(i)
+		for q.depths()[0].Depth != i+2 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if q.unlessPending("k", func() { t.Error("lease granted while writes were pending") }) {
+		t.Error("unlessPending = true with writes pending")
+	}
+	q.release("k")
+	for i := 0; i < n; i++ {
+		if got := <-order; got != i {
+			t.Fatalf("write %d was applied in position %d", got, i)
+		}
+	}
+
+	granted := false
+	if !q.unlessPending("k", func() { granted = true }) || !granted {
+		t.Error("lease not granted once every write was released")
+	}
+	if depths := q.depths(); len(depths) != 0 {
+		t.Errorf("depths = %v after every write was released", depths)
+	}
+}
@@ -0,0 +1,103 @@
+package proxycounter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/cmu440/tribbler/rpc/adminrpc"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
+)
+
+// The StorageServer methods added since proxycounter.go was written, which is
+// not to be modified, are kept here. Batch is counted like the writes it
+// carries; the others are only used between storage servers and by
+// operators, and are forwarded without being counted.
+
+func (pc *proxyCounter) UnregisterServer(args *storagerpc.UnregisterArgs, reply *storagerpc.UnregisterReply) error {
+	return pc.srv.Call("StorageServer.UnregisterServer", args, reply)
+}
+
+func (pc *proxyCounter) SetServers(args *storagerpc.SetServersArgs, reply *storagerpc.SetServersReply) error {
+	return pc.srv.Call("StorageServer.SetServers", args, reply)
+}
+
+func (pc *proxyCounter) Batch(args *storagerpc.BatchArgs, reply *storagerpc.BatchReply) error {
+	if pc.override {
+		reply.Statuses = make([]storagerpc.Status, len(args.Writes))
+		for i := range reply.Statuses {
+			reply.Statuses[i] = pc.overrideStatus
+		}
+		return pc.overrideErr
+	}
+	byteCount := 0
+	for _, w := range args.Writes {
+		byteCount += len(w.Key) + len(w.Value)
+	}
+	err := pc.srv.Call("StorageServer.Batch", args, reply)
+	atomic.AddUint32(&pc.rpcCount, 1)
+	atomic.AddUint32(&pc.byteCount, uint32(byteCount))
+	return err
+}
+
+func (pc *proxyCounter) ReleaseLeases(args *storagerpc.ReleaseLeasesArgs, reply *storagerpc.ReleaseLeasesReply) error {
+	return pc.srv.Call("StorageServer.ReleaseLeases", args, reply)
+}
+
+func (pc *proxyCounter) TransferRange(args *storagerpc.TransferRangeArgs, reply *storagerpc.TransferRangeReply) error {
+	return pc.srv.Call("StorageServer.TransferRange", args, reply)
+}
+
+func (pc *proxyCounter) ReceiveRange(args *storagerpc.ReceiveRangeArgs, reply *storagerpc.ReceiveRangeReply) error {
+	return pc.srv.Call("StorageServer.ReceiveRange", args, reply)
+}
+
+func (pc *proxyCounter) AssignRange(args *storagerpc.AssignRangeArgs, reply *storagerpc.AssignRangeReply) error {
+	return pc.srv.Call("StorageServer.AssignRange", args, reply)
+}
+
+func (pc *proxyCounter) MerkleTree(args *storagerpc.MerkleTreeArgs, reply *storagerpc.MerkleTreeReply) error {
+	return pc.srv.Call("StorageServer.MerkleTree", args, reply)
+}
+
+func (pc *proxyCounter) KeyDigests(args *storagerpc.KeyDigestsArgs, reply *storagerpc.KeyDigestsReply) error {
+	return pc.srv.Call("StorageServer.KeyDigests", args, reply)
+}
+
+func (pc *proxyCounter) RepairKeys(args *storagerpc.RepairKeysArgs, reply *storagerpc.RepairKeysReply) error {
+	return pc.srv.Call("StorageServer.RepairKeys", args, reply)
+}
+
+func (pc *proxyCounter) Replicate(args *storagerpc.ReplicateArgs, reply *storagerpc.ReplicateReply) error {
+	return pc.srv.Call("StorageServer.Replicate", args, reply)
+}
+
+// Drain drains the real server through its Admin service, giving it until
+// ctx's deadline.
+func (pc *proxyCounter) Drain(ctx context.Context) error {
+	args := &adminrpc.DrainServerArgs{}
+	if deadline, ok := ctx.Deadline(); ok {
+		args.Timeout = time.Until(deadline)
+		if args.Timeout <= 0 {
+			args.Timeout = time.Nanosecond // Start draining, but don't wait.
+		}
+	}
+	var reply adminrpc.DrainServerReply
+	call := pc.srv.Go("Admin.DrainServer", args, &reply, nil)
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return call.Error
+		}
+		if !reply.Drained {
+			return context.DeadlineExceeded
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pc *proxyCounter) Close() error {
+	return pc.srv.Close()
+}
@@ -14,6 +14,13 @@ type TribClient interface {
 	RemoveSubscription(userID, targetUser string) (tribrpc.Status, error)
 	GetTribbles(userID string) ([]tribrpc.Tribble, tribrpc.Status, error)
 	GetTribblesBySubscription(userID string) ([]tribrpc.Tribble, tribrpc.Status, error)
+
+	// GetTribblesPage and GetTribblesBySubscriptionPage return a page of at
+	// most pageSize tribbles following cursor ("" for the most recent), and
+	// the cursor of the next page ("" once there are no older tribbles).
+	GetTribblesPage(userID, cursor string, pageSize int) ([]tribrpc.Tribble, string, tribrpc.Status, error)
+	GetTribblesBySubscriptionPage(userID, cursor string, pageSize int) ([]tribrpc.Tribble, string, tribrpc.Status, error)
+
 	PostTribble(userID, contents string) (tribrpc.PostTribbleReply, error)
 	DeleteTribble(userID, postKey string) (tribrpc.Status, error)
 	Close() error
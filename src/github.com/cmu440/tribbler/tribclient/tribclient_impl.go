@@ -64,20 +64,30 @@ func (tc *tribClient) doSub(funcName, userID, targetUserID string) (tribrpc.Stat
 }
 
 func (tc *tribClient) GetTribbles(userID string) ([]tribrpc.Tribble, tribrpc.Status, error) {
-	return tc.doTrib("TribServer.GetTribbles", userID)
+	tribbles, _, status, err := tc.doTrib("TribServer.GetTribbles", userID, "", 0)
+	return tribbles, status, err
 }
 
 func (tc *tribClient) GetTribblesBySubscription(userID string) ([]tribrpc.Tribble, tribrpc.Status, error) {
-	return tc.doTrib("TribServer.GetTribblesBySubscription", userID)
+	tribbles, _, status, err := tc.doTrib("TribServer.GetTribblesBySubscription", userID, "", 0)
+	return tribbles, status, err
 }
 
-func (tc *tribClient) doTrib(funcName, userID string) ([]tribrpc.Tribble, tribrpc.Status, error) {
-	args := &tribrpc.GetTribblesArgs{UserID: userID}
+func (tc *tribClient) GetTribblesPage(userID, cursor string, pageSize int) ([]tribrpc.Tribble, string, tribrpc.Status, error) {
+	return tc.doTrib("TribServer.GetTribbles", userID, cursor, pageSize)
+}
+
+func (tc *tribClient) GetTribblesBySubscriptionPage(userID, cursor string, pageSize int) ([]tribrpc.Tribble, string, tribrpc.Status, error) {
+	return tc.doTrib("TribServer.GetTribblesBySubscription", userID, cursor, pageSize)
+}
+
+func (tc *tribClient) doTrib(funcName, userID, cursor string, pageSize int) ([]tribrpc.Tribble, string, tribrpc.Status, error) {
+	args := &tribrpc.GetTribblesArgs{UserID: userID, Cursor: cursor, PageSize: pageSize}
 	var reply tribrpc.GetTribblesReply
 	if err := tc.client.Call(funcName, args, &reply); err != nil {
-		return nil, 0, err
+		return nil, "", 0, err
 	}
-	return reply.Tribbles, reply.Status, nil
+	return reply.Tribbles, reply.NextCursor, reply.Status, nil
 }
 
 func (tc *tribClient) PostTribble(userID, contents string) (tribrpc.PostTribbleReply, error) {
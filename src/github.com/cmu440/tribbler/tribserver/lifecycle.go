@@ -0,0 +1,101 @@
+package tribserver
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrClosed is returned by the RPCs of a TribServer that is shutting down.
+var ErrClosed = errors.New("tribserver: server is shutting down")
+
+// begin admits an RPC, returning false once Close has been called. Every
+// admitted RPC must call end when it completes.
+func (ts *tribServer) begin() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return false
+	}
+	ts.inflight.Add(1)
+	return true
+}
+
+// end marks an admitted RPC as complete.
+func (ts *tribServer) end() {
+	ts.inflight.Done()
+}
+
+func (ts *tribServer) Close() error {
+	ts.mu.Lock()
+	if ts.closed {
+		ts.mu.Unlock()
+		return nil
+	}
+	ts.closed = true
+	ts.mu.Unlock()
+
+	// Closing the listener stops new connections, but net/rpc keeps
+	// serving the connections it has already hijacked. Once the RPCs in
+	// progress are done, close those too, so that clients and storage
+	// servers can no longer reach the services (which net/rpc has no way to
+	// unregister), and only then release the Libstore's leases.
+	err := ts.listener.Close()
+	ts.inflight.Wait()
+	ts.listener.closeConns()
+	if lerr := ts.ls.Close(); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+// connListener is a net.Listener that keeps track of the connections it has
+// accepted and not yet seen closed, so that they can be closed when the
+// server shuts down.
+type connListener struct {
+	net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newConnListener(l net.Listener) *connListener {
+	return &connListener{Listener: l, conns: make(map[net.Conn]struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn := &trackedConn{Conn: c, l: l}
+	l.mu.Lock()
+	l.conns[conn] = struct{}{}
+	l.mu.Unlock()
+	return conn, nil
+}
+
+// closeConns closes every connection l has accepted that is still open.
+func (l *connListener) closeConns() {
+	l.mu.Lock()
+	conns := l.conns
+	l.conns = make(map[net.Conn]struct{})
+	l.mu.Unlock()
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+// trackedConn is a connection accepted by a connListener, which it leaves
+// when closed.
+type trackedConn struct {
+	net.Conn
+	l *connListener
+}
+
+func (c *trackedConn) Close() error {
+	c.l.mu.Lock()
+	delete(c.l.conns, c)
+	c.l.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,56 @@
+package tribserver
+
+import (
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/cmu440/tribbler/rpc/tribrpc"
+	"github.com/cmu440/tribbler/storageserver"
+)
+
+// freePort returns a port that was free a moment ago.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestCloseDropsConnections(t *testing.T) {
+	storagePort := freePort(t)
+	ss, err := storageserver.NewStorageServer("", 1, storagePort, 1,
+		storageserver.WithLeaseJournal(filepath.Join(t.TempDir(), "leases")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	hostPort := net.JoinHostPort("localhost", strconv.Itoa(freePort(t)))
+	ts, err := NewTribServer(net.JoinHostPort("localhost", strconv.Itoa(storagePort)), hostPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := rpc.DialHTTP("tcp", hostPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	var reply tribrpc.CreateUserReply
+	if err := cli.Call("TribServer.CreateUser", &tribrpc.CreateUserArgs{UserID: "alice"}, &reply); err != nil || reply.Status != tribrpc.OK {
+		t.Fatalf("CreateUser = %v, %v; want OK", reply.Status, err)
+	}
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The server must not answer at all, not even with ErrClosed.
+	err = cli.Call("TribServer.CreateUser", &tribrpc.CreateUserArgs{UserID: "bob"}, &reply)
+	if _, answered := err.(rpc.ServerError); err == nil || answered {
+		t.Errorf("CreateUser on a connection made before Close = %v; want the connection closed", err)
+	}
+}
@@ -0,0 +1,26 @@
+package tribserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cmu440/tribbler/libstore"
+	"github.com/cmu440/tribbler/metrics"
+)
+
+// observe records an RPC to method that began at start and was answered with
+// status, or failed with err.
+func (ts *tribServer) observe(method string, start time.Time, status fmt.Stringer, err error) {
+	name := "error"
+	if err == nil {
+		name = status.String()
+	}
+	ts.metrics.Observe(method, name, time.Since(start))
+}
+
+// writeMetrics writes the server's metrics, and those of its Libstore, for
+// the handler served at metrics.Path.
+func (ts *tribServer) writeMetrics(w *metrics.Writer) {
+	ts.metrics.Write(w, "tribserver_rpc")
+	libstore.WriteMetrics(w, ts.ls.Stats())
+}
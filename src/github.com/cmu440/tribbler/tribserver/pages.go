@@ -0,0 +1,95 @@
+package tribserver
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cmu440/tribbler/rpc/tribrpc"
+)
+
+// errBadCursor is returned by page when the cursor it is given is not one it
+// returned. GetTribbles and GetTribblesBySubscription reply with status
+// BadCursor.
+var errBadCursor = errors.New("tribserver: malformed cursor")
+
+// pageSize returns the number of tribbles to return for a requested page
+// size of n.
+func pageSize(n int) int {
+	if n <= 0 || n > tribrpc.MaxPageSize {
+		return tribrpc.MaxPageSize
+	}
+	return n
+}
+
+// postRef is a PostKey along with the time it encodes.
+type postRef struct {
+	key    string
+	posted int64 // UnixNano; 0 if the key is malformed.
+}
+
+// parsePostKey extracts the posting time from a PostKey formatted by
+// util.FormatPostKey ("<user>:post_<time>_<tiebreak>", in hex).
+func parsePostKey(key string) (postRef, bool) {
+	i := strings.LastIndex(key, ":post_")
+	if i < 0 {
+		return postRef{key: key}, false
+	}
+	rest := key[i+len(":post_"):]
+	if j := strings.IndexByte(rest, '_'); j >= 0 {
+		rest = rest[:j]
+	}
+	posted, err := strconv.ParseInt(rest, 16, 64)
+	if err != nil {
+		return postRef{key: key}, false
+	}
+	return postRef{key: key, posted: posted}, true
+}
+
+// before reports whether a comes before b in reverse chronological order.
+// Posts made at the same instant are ordered by key so that the order is
+// total, and so that a page never splits them ambiguously.
+func (a postRef) before(b postRef) bool {
+	if a.posted != b.posted {
+		return a.posted > b.posted
+	}
+	return a.key > b.key
+}
+
+// page orders postKeys most recent first and returns the (at most) size of
+// them that come after cursor, or from the start if cursor is empty, along
+// with the cursor for the following page ("" if no keys remain). A cursor is
+// the PostKey of the last tribble on the previous page; since PostKeys embed
+// their posting time, it stays valid if that tribble is deleted or newer ones
+// are posted in the meantime.
+func page(postKeys []string, cursor string, size int) ([]string, string, error) {
+	var after postRef
+	if cursor != "" {
+		var ok bool
+		if after, ok = parsePostKey(cursor); !ok {
+			return nil, "", errBadCursor
+		}
+	}
+	refs := make([]postRef, 0, len(postKeys))
+	for _, key := range postKeys {
+		ref, _ := parsePostKey(key)
+		if cursor == "" || after.before(ref) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].before(refs[j])
+	})
+
+	next := ""
+	if len(refs) > size {
+		refs = refs[:size]
+		next = refs[size-1].key
+	}
+	keys := make([]string, len(refs))
+	for i, ref := range refs {
+		keys[i] = ref.key
+	}
+	return keys, next, nil
+}
@@ -0,0 +1,58 @@
+package tribserver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/cmu440/tribbler/rpc/tribrpc"
+)
+
+func postKey(user string, posted int64) string {
+	return fmt.Sprintf("%s:post_%x_%x", user, posted, 0)
+}
+
+func TestPage(t *testing.T) {
+	a1, a2, a3 := postKey("a", 1), postKey("a", 2), postKey("a", 3)
+	b2, b4 := postKey("b", 2), postKey("b", 4)
+	keys := []string{a1, b2, a3, a2, b4}
+	tests := []struct {
+		cursor   string
+		size     int
+		want     []string
+		wantNext string
+	}{
+		{cursor: "", size: 10, want: []string{b4, a3, b2, a2, a1}},
+		{cursor: "", size: 2, want: []string{b4, a3}, wantNext: a3},
+		{cursor: a3, size: 2, want: []string{b2, a2}, wantNext: a2},
+		{cursor: a2, size: 2, want: []string{a1}},
+		{cursor: a1, size: 2, want: []string{}},
+		// The cursor's tribble may have been deleted since.
+		{cursor: postKey("c", 3), size: 1, want: []string{a3}, wantNext: a3},
+	}
+	for _, tt := range tests {
+		got, next, err := page(keys, tt.cursor, tt.size)
+		if err != nil {
+			t.Errorf("page(%q, %d) failed: %v", tt.cursor, tt.size, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) || next != tt.wantNext {
+			t.Errorf("page(%q, %d) = %q, %q; want %q, %q", tt.cursor, tt.size, got, next, tt.want, tt.wantNext)
+		}
+	}
+
+	for _, cursor := range []string{"garbage", "a:post_zz_0"} {
+		if _, _, err := page(keys, cursor, 2); err != errBadCursor {
+			t.Errorf("page(%q) error = %v, want errBadCursor", cursor, err)
+		}
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	max := tribrpc.MaxPageSize
+	for n, want := range map[int]int{-1: max, 0: max, 1: 1, max: max, max + 1: max} {
+		if got := pageSize(n); got != want {
+			t.Errorf("pageSize(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
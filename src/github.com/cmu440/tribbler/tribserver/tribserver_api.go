@@ -34,14 +34,21 @@ type TribServer interface {
 	// Replies with status NoSuchPost if the specified PostKey does not exist.
 	DeleteTribble(args *tribrpc.DeleteTribbleArgs, reply *tribrpc.DeleteTribbleReply) error
 
-	// GetTribbles retrieves a list of at most 100 tribbles posted by the specified
-	// UserID in reverse chronological order (most recent first).
-	// Replies with status NoSuchUser if the specified UserID does not exist.
+	// GetTribbles retrieves a page of at most PageSize (by default and at most
+	// tribrpc.MaxPageSize) tribbles posted by the specified UserID in reverse
+	// chronological order (most recent first), starting after Cursor, and sets
+	// NextCursor to continue from. Replies with status NoSuchUser if the specified
+	// UserID does not exist, and BadCursor if Cursor is malformed.
 	GetTribbles(args *tribrpc.GetTribblesArgs, reply *tribrpc.GetTribblesReply) error
 
-	// GetTribblesBySubscription retrieves a list of at most 100 tribbles posted by
-	// all users to which the specified UserID is subscribed in reverse chronological
-	// order (most recent first).  Replies with status NoSuchUser if the specified UserID
-	// does not exist.
+	// GetTribblesBySubscription retrieves a page of tribbles posted by all users
+	// to which the specified UserID is subscribed in reverse chronological order
+	// (most recent first), paged as for GetTribbles. Replies with status
+	// NoSuchUser if the specified UserID does not exist.
 	GetTribblesBySubscription(args *tribrpc.GetTribblesArgs, reply *tribrpc.GetTribblesReply) error
+
+	// Close stops the TribServer: it stops accepting connections, fails
+	// new RPCs with ErrClosed, waits for the RPCs in progress to complete,
+	// and closes its Libstore (releasing its leases). It is not an RPC.
+	Close() error
 }
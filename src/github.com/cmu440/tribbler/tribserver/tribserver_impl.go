@@ -1,13 +1,28 @@
 package tribserver
 
 import (
-	"errors"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"time"
 
+	"github.com/cmu440/tribbler/libstore"
+	"github.com/cmu440/tribbler/metrics"
+	"github.com/cmu440/tribbler/rpc/storagerpc"
 	"github.com/cmu440/tribbler/rpc/tribrpc"
+	"github.com/cmu440/tribbler/util"
 )
 
 type tribServer struct {
-	// TODO: implement this!
+	ls       libstore.Libstore
+	listener *connListener
+	metrics  *metrics.Methods // Request counts, statuses and latencies, by method.
+
+	mu       sync.Mutex
+	closed   bool           // Set by Close; new RPCs are refused.
+	inflight sync.WaitGroup // RPCs in progress.
 }
 
 // NewTribServer creates, starts and returns a new TribServer. masterServerHostPort
@@ -15,39 +30,355 @@ type tribServer struct {
 // the TribServer should listen. A non-nil error should be returned if the TribServer
 // could not be started.
 //
-// For hints on how to properly setup RPC, see the rpc/tribrpc package.
-func NewTribServer(masterServerHostPort, myHostPort string) (TribServer, error) {
-	return nil, errors.New("not implemented")
+// The "TribServer" service, and the Libstore's "LeaseCallbacks" service, are
+// registered with the server's own rpc.Server, served along with its metrics
+// (at metrics.Path) from its own http.ServeMux, so that a new TribServer can
+// be started in the same process once this one is closed.
+//
+// CreateUser checks for and creates users at storagerpc.ConsistencyAll, so
+// that a user exists on every replica once created; everything else is read
+// and written at the Libstore's default consistency.
+//
+// The Libstore is created with the given options. If they include
+// libstore.WithStaleGrace, reads that return a stale value (with a
+// *libstore.StaleError) are served with that value rather than failing, so
+// that users can still read tribbles while a storage node is unreachable.
+func NewTribServer(masterServerHostPort, myHostPort string, opts ...libstore.Option) (TribServer, error) {
+	_, port, err := net.SplitHostPort(myHostPort)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("", port))
+	if err != nil {
+		return nil, err
+	}
+
+	srv := rpc.NewServer()
+	opts = append([]libstore.Option{libstore.WithCallbackServer(srv)}, opts...)
+	ls, err := libstore.NewLibstore(masterServerHostPort, myHostPort, libstore.Normal, opts...)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	ts := &tribServer{
+		ls:       ls,
+		listener: newConnListener(listener),
+		metrics:  metrics.NewMethods(metrics.DefaultBuckets),
+	}
+	if err := srv.RegisterName("TribServer", tribrpc.Wrap(ts)); err != nil {
+		listener.Close()
+		ls.Close()
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	mux.Handle(metrics.Path, metrics.Handler(ts.writeMetrics))
+	go http.Serve(ts.listener, mux)
+	return ts, nil
+}
+
+// hasStatus reports whether err is a *libstore.StatusError with the given
+// status.
+func hasStatus(err error, status storagerpc.Status) bool {
+	serr, ok := err.(*libstore.StatusError)
+	return ok && serr.Status == status
+}
+
+// allowStale returns the error of a read, or nil if the read returned a stale
+// value (see libstore.WithStaleGrace), which is served like a fresh one.
+func allowStale(err error) error {
+	if _, ok := err.(*libstore.StaleError); ok {
+		return nil
+	}
+	return err
 }
 
-func (ts *tribServer) CreateUser(args *tribrpc.CreateUserArgs, reply *tribrpc.CreateUserReply) error {
-	return errors.New("not implemented")
+// userExists reports whether userID has been created, reading through ls.
+func userExists(ls libstore.Libstore, userID string) (bool, error) {
+	_, err := ls.Get(util.FormatUserKey(userID))
+	if hasStatus(err, storagerpc.KeyNotFound) {
+		return false, nil
+	}
+	err = allowStale(err)
+	return err == nil, err
 }
 
-func (ts *tribServer) AddSubscription(args *tribrpc.SubscriptionArgs, reply *tribrpc.SubscriptionReply) error {
-	return errors.New("not implemented")
+// getList reads the list at key, which is empty if it doesn't exist.
+func (ts *tribServer) getList(key string) ([]string, error) {
+	list, err := ts.ls.GetList(key)
+	if hasStatus(err, storagerpc.KeyNotFound) {
+		return nil, nil
+	}
+	return list, allowStale(err)
 }
 
-func (ts *tribServer) RemoveSubscription(args *tribrpc.SubscriptionArgs, reply *tribrpc.SubscriptionReply) error {
-	return errors.New("not implemented")
+func (ts *tribServer) CreateUser(args *tribrpc.CreateUserArgs, reply *tribrpc.CreateUserReply) (err error) {
+	if !ts.begin() {
+		return ErrClosed
+	}
+	defer ts.end()
+	defer func(start time.Time) { ts.observe("CreateUser", start, reply.Status, err) }(time.Now())
+
+	all := ts.ls.AtConsistency(storagerpc.ConsistencyAll)
+	exists, err := userExists(all, args.UserID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		reply.Status = tribrpc.Exists
+		return nil
+	}
+	if err := all.Put(util.FormatUserKey(args.UserID), args.UserID); err != nil {
+		return err
+	}
+	reply.Status = tribrpc.OK
+	return nil
+}
+
+func (ts *tribServer) AddSubscription(args *tribrpc.SubscriptionArgs, reply *tribrpc.SubscriptionReply) (err error) {
+	if !ts.begin() {
+		return ErrClosed
+	}
+	defer ts.end()
+	defer func(start time.Time) { ts.observe("AddSubscription", start, reply.Status, err) }(time.Now())
+
+	reply.Status, err = ts.checkSubscription(args)
+	if err != nil || reply.Status != tribrpc.OK {
+		return err
+	}
+	err = ts.ls.AppendToList(util.FormatSubListKey(args.UserID), args.TargetUserID)
+	if hasStatus(err, storagerpc.ItemExists) {
+		reply.Status = tribrpc.Exists
+		return nil
+	}
+	return err
+}
+
+func (ts *tribServer) RemoveSubscription(args *tribrpc.SubscriptionArgs, reply *tribrpc.SubscriptionReply) (err error) {
+	if !ts.begin() {
+		return ErrClosed
+	}
+	defer ts.end()
+	defer func(start time.Time) { ts.observe("RemoveSubscription", start, reply.Status, err) }(time.Now())
+
+	reply.Status, err = ts.checkSubscription(args)
+	if err != nil || reply.Status != tribrpc.OK {
+		return err
+	}
+	err = ts.ls.RemoveFromList(util.FormatSubListKey(args.UserID), args.TargetUserID)
+	if hasStatus(err, storagerpc.ItemNotFound) || hasStatus(err, storagerpc.KeyNotFound) {
+		reply.Status = tribrpc.NoSuchTargetUser
+		return nil
+	}
+	return err
 }
 
-func (ts *tribServer) GetFriends(args *tribrpc.GetFriendsArgs, reply *tribrpc.GetFriendsReply) error {
-	return errors.New("not implemented")
+// checkUser returns OK if userID exists, or else the status to reply with.
+func (ts *tribServer) checkUser(userID string) (tribrpc.Status, error) {
+	exists, err := userExists(ts.ls, userID)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return tribrpc.NoSuchUser, nil
+	}
+	return tribrpc.OK, nil
 }
 
-func (ts *tribServer) PostTribble(args *tribrpc.PostTribbleArgs, reply *tribrpc.PostTribbleReply) error {
-	return errors.New("not implemented")
+// checkSubscription returns OK if both users named in args exist, or else
+// the status to reply with.
+func (ts *tribServer) checkSubscription(args *tribrpc.SubscriptionArgs) (tribrpc.Status, error) {
+	if status, err := ts.checkUser(args.UserID); err != nil || status != tribrpc.OK {
+		return status, err
+	}
+	status, err := ts.checkUser(args.TargetUserID)
+	if status == tribrpc.NoSuchUser {
+		status = tribrpc.NoSuchTargetUser
+	}
+	return status, err
 }
 
-func (ts *tribServer) DeleteTribble(args *tribrpc.DeleteTribbleArgs, reply *tribrpc.DeleteTribbleReply) error {
-	return errors.New("not implemented")
+func (ts *tribServer) GetFriends(args *tribrpc.GetFriendsArgs, reply *tribrpc.GetFriendsReply) (err error) {
+	if !ts.begin() {
+		return ErrClosed
+	}
+	defer ts.end()
+	defer func(start time.Time) { ts.observe("GetFriends", start, reply.Status, err) }(time.Now())
+
+	if reply.Status, err = ts.checkUser(args.UserID); err != nil || reply.Status != tribrpc.OK {
+		return err
+	}
+	subscriptions, err := ts.getList(util.FormatSubListKey(args.UserID))
+	if err != nil {
+		return err
+	}
+	ts.prefetchSubscriptions(subscriptions)
+
+	// A friend is a subscription who subscribes back.
+	futures := make([]*libstore.GetListFuture, len(subscriptions))
+	for i, userID := range subscriptions {
+		futures[i] = ts.ls.GetListAsync(util.FormatSubListKey(userID))
+	}
+	reply.UserIDs = []string{}
+	for i, f := range futures {
+		theirs, err := f.Wait()
+		if hasStatus(err, storagerpc.KeyNotFound) {
+			continue
+		} else if err = allowStale(err); err != nil {
+			return err
+		}
+		for _, userID := range theirs {
+			if userID == args.UserID {
+				reply.UserIDs = append(reply.UserIDs, subscriptions[i])
+				break
+			}
+		}
+	}
+	reply.Status = tribrpc.OK
+	return nil
+}
+
+func (ts *tribServer) PostTribble(args *tribrpc.PostTribbleArgs, reply *tribrpc.PostTribbleReply) (err error) {
+	if !ts.begin() {
+		return ErrClosed
+	}
+	defer ts.end()
+	defer func(start time.Time) { ts.observe("PostTribble", start, reply.Status, err) }(time.Now())
+
+	if reply.Status, err = ts.checkUser(args.UserID); err != nil || reply.Status != tribrpc.OK {
+		return err
+	}
+	tribble := tribrpc.Tribble{UserID: args.UserID, Posted: time.Now(), Contents: args.Contents}
+	value, err := json.Marshal(&tribble)
+	if err != nil {
+		return err
+	}
+	postKey := util.FormatPostKey(args.UserID, tribble.Posted.UnixNano())
+	if err := ts.ls.Put(postKey, string(value)); err != nil {
+		return err
+	}
+	if err := ts.ls.AppendToList(util.FormatTribListKey(args.UserID), postKey); err != nil {
+		return err
+	}
+	reply.PostKey = postKey
+	reply.Status = tribrpc.OK
+	return nil
+}
+
+func (ts *tribServer) DeleteTribble(args *tribrpc.DeleteTribbleArgs, reply *tribrpc.DeleteTribbleReply) (err error) {
+	if !ts.begin() {
+		return ErrClosed
+	}
+	defer ts.end()
+	defer func(start time.Time) { ts.observe("DeleteTribble", start, reply.Status, err) }(time.Now())
+
+	if reply.Status, err = ts.checkUser(args.UserID); err != nil || reply.Status != tribrpc.OK {
+		return err
+	}
+	err = ts.ls.RemoveFromList(util.FormatTribListKey(args.UserID), args.PostKey)
+	if hasStatus(err, storagerpc.ItemNotFound) || hasStatus(err, storagerpc.KeyNotFound) {
+		reply.Status = tribrpc.NoSuchPost
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := ts.ls.Delete(args.PostKey); err != nil && !hasStatus(err, storagerpc.KeyNotFound) {
+		return err
+	}
+	reply.Status = tribrpc.OK
+	return nil
+}
+
+func (ts *tribServer) GetTribbles(args *tribrpc.GetTribblesArgs, reply *tribrpc.GetTribblesReply) (err error) {
+	if !ts.begin() {
+		return ErrClosed
+	}
+	defer ts.end()
+	defer func(start time.Time) { ts.observe("GetTribbles", start, reply.Status, err) }(time.Now())
+
+	if reply.Status, err = ts.checkUser(args.UserID); err != nil || reply.Status != tribrpc.OK {
+		return err
+	}
+	postKeys, err := ts.getList(util.FormatTribListKey(args.UserID))
+	if err != nil {
+		return err
+	}
+	return ts.pageTribbles(postKeys, args, reply)
+}
+
+func (ts *tribServer) GetTribblesBySubscription(args *tribrpc.GetTribblesArgs, reply *tribrpc.GetTribblesReply) (err error) {
+	if !ts.begin() {
+		return ErrClosed
+	}
+	defer ts.end()
+	defer func(start time.Time) { ts.observe("GetTribblesBySubscription", start, reply.Status, err) }(time.Now())
+
+	if reply.Status, err = ts.checkUser(args.UserID); err != nil || reply.Status != tribrpc.OK {
+		return err
+	}
+	subscriptions, err := ts.getList(util.FormatSubListKey(args.UserID))
+	if err != nil {
+		return err
+	}
+	futures := make([]*libstore.GetListFuture, len(subscriptions))
+	for i, userID := range subscriptions {
+		futures[i] = ts.ls.GetListAsync(util.FormatTribListKey(userID))
+	}
+	var postKeys []string
+	for _, f := range futures {
+		keys, err := f.Wait()
+		if err = allowStale(err); err != nil && !hasStatus(err, storagerpc.KeyNotFound) {
+			return err
+		}
+		postKeys = append(postKeys, keys...)
+	}
+	if err := ts.pageTribbles(postKeys, args, reply); err != nil {
+		return err
+	}
+	// A refresh reads the same lists again; have them leased by then.
+	ts.prefetchSubscriptions(subscriptions)
+	return nil
 }
 
-func (ts *tribServer) GetTribbles(args *tribrpc.GetTribblesArgs, reply *tribrpc.GetTribblesReply) error {
-	return errors.New("not implemented")
+// pageTribbles fills reply with the page of the tribbles at postKeys that
+// args asks for, replying with status BadCursor if its Cursor is malformed.
+// Tribbles deleted since postKeys was read are left out.
+func (ts *tribServer) pageTribbles(postKeys []string, args *tribrpc.GetTribblesArgs, reply *tribrpc.GetTribblesReply) error {
+	keys, next, err := page(postKeys, args.Cursor, pageSize(args.PageSize))
+	if err != nil {
+		reply.Status = tribrpc.BadCursor
+		return nil
+	}
+	futures := make([]*libstore.GetFuture, len(keys))
+	for i, key := range keys {
+		futures[i] = ts.ls.GetAsync(key)
+	}
+	reply.Tribbles = make([]tribrpc.Tribble, 0, len(keys))
+	for _, f := range futures {
+		value, err := f.Wait()
+		if hasStatus(err, storagerpc.KeyNotFound) {
+			continue
+		} else if err = allowStale(err); err != nil {
+			return err
+		}
+		var tribble tribrpc.Tribble
+		if err := json.Unmarshal([]byte(value), &tribble); err != nil {
+			return err
+		}
+		reply.Tribbles = append(reply.Tribbles, tribble)
+	}
+	reply.NextCursor = next
+	reply.Status = tribrpc.OK
+	return nil
 }
 
-func (ts *tribServer) GetTribblesBySubscription(args *tribrpc.GetTribblesArgs, reply *tribrpc.GetTribblesReply) error {
-	return errors.New("not implemented")
+// prefetchSubscriptions warms the libstore cache with the tribble lists of
+// the given users, which GetTribblesBySubscription is likely to read soon
+// after a user calls GetFriends, and again each time the user refreshes.
+func (ts *tribServer) prefetchSubscriptions(userIDs []string) {
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = util.FormatTribListKey(userID)
+	}
+	ts.ls.PrefetchList(keys...)
 }
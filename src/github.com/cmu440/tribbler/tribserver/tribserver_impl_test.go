@@ -0,0 +1,96 @@
+package tribserver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cmu440/tribbler/libstore"
+	"github.com/cmu440/tribbler/metrics"
+	"github.com/cmu440/tribbler/rpc/tribrpc"
+	"github.com/cmu440/tribbler/util"
+)
+
+// staleLibstore is a Libstore whose Get and GetList return the values they
+// read along with a *libstore.StaleError, as if the storage node had been
+// unreachable and the values had come from an expired cache entry.
+type staleLibstore struct {
+	libstore.Libstore
+}
+
+var errUnreachable = errors.New("node unreachable")
+
+func (ls staleLibstore) Get(key string) (string, error) {
+	value, err := ls.Libstore.Get(key)
+	if err != nil {
+		return "", err
+	}
+	return value, &libstore.StaleError{Key: key, Err: errUnreachable}
+}
+
+func (ls staleLibstore) GetList(key string) ([]string, error) {
+	list, err := ls.Libstore.GetList(key)
+	if err != nil {
+		return nil, err
+	}
+	return list, &libstore.StaleError{Key: key, Err: errUnreachable}
+}
+
+// prefetchLibstore is a Libstore that records the keys passed to PrefetchList.
+type prefetchLibstore struct {
+	libstore.Libstore
+	prefetched []string
+}
+
+func (ls *prefetchLibstore) PrefetchList(keys ...string) {
+	ls.prefetched = append(ls.prefetched, keys...)
+	ls.Libstore.PrefetchList(keys...)
+}
+
+func TestSubscriptionTimelinePrefetched(t *testing.T) {
+	ls := &prefetchLibstore{Libstore: libstore.NewMemLibstore()}
+	ts := &tribServer{ls: ls, metrics: metrics.NewMethods(metrics.DefaultBuckets)}
+	for _, userID := range []string{"alice", "bob"} {
+		var create tribrpc.CreateUserReply
+		if err := ts.CreateUser(&tribrpc.CreateUserArgs{UserID: userID}, &create); err != nil || create.Status != tribrpc.OK {
+			t.Fatalf("CreateUser(%s) = %v, %v; want OK", userID, create.Status, err)
+		}
+	}
+	var sub tribrpc.SubscriptionReply
+	if err := ts.AddSubscription(&tribrpc.SubscriptionArgs{UserID: "alice", TargetUserID: "bob"}, &sub); err != nil || sub.Status != tribrpc.OK {
+		t.Fatalf("AddSubscription = %v, %v; want OK", sub.Status, err)
+	}
+
+	var get tribrpc.GetTribblesReply
+	if err := ts.GetTribblesBySubscription(&tribrpc.GetTribblesArgs{UserID: "alice"}, &get); err != nil || get.Status != tribrpc.OK {
+		t.Fatalf("GetTribblesBySubscription = %v, %v; want OK", get.Status, err)
+	}
+	if want := util.FormatTribListKey("bob"); len(ls.prefetched) != 1 || ls.prefetched[0] != want {
+		t.Errorf("prefetched %v, want [%s]", ls.prefetched, want)
+	}
+}
+
+func TestStaleReadsServed(t *testing.T) {
+	mem := libstore.NewMemLibstore()
+	ts := &tribServer{ls: mem, metrics: metrics.NewMethods(metrics.DefaultBuckets)}
+	var create tribrpc.CreateUserReply
+	if err := ts.CreateUser(&tribrpc.CreateUserArgs{UserID: "alice"}, &create); err != nil || create.Status != tribrpc.OK {
+		t.Fatalf("CreateUser = %v, %v; want OK", create.Status, err)
+	}
+	var post tribrpc.PostTribbleReply
+	if err := ts.PostTribble(&tribrpc.PostTribbleArgs{UserID: "alice", Contents: "hi"}, &post); err != nil || post.Status != tribrpc.OK {
+		t.Fatalf("PostTribble = %v, %v; want OK", post.Status, err)
+	}
+
+	ts.ls = staleLibstore{mem}
+	var get tribrpc.GetTribblesReply
+	if err := ts.GetTribbles(&tribrpc.GetTribblesArgs{UserID: "alice"}, &get); err != nil || get.Status != tribrpc.OK {
+		t.Fatalf("GetTribbles from stale reads = %v, %v; want OK", get.Status, err)
+	}
+	if len(get.Tribbles) != 1 || get.Tribbles[0].Contents != "hi" {
+		t.Errorf("GetTribbles = %+v, want the one tribble posted", get.Tribbles)
+	}
+	get = tribrpc.GetTribblesReply{}
+	if err := ts.GetTribbles(&tribrpc.GetTribblesArgs{UserID: "bob"}, &get); err != nil || get.Status != tribrpc.NoSuchUser {
+		t.Errorf("GetTribbles for a missing user = %v, %v; want NoSuchUser", get.Status, err)
+	}
+}